@@ -5,9 +5,11 @@
 package api
 
 import (
-	"encoding/json"
+	"fmt"
 	"strconv"
+	"strings"
 
+	"github.com/bogem/nehm/apihealth"
 	"github.com/bogem/nehm/logs"
 	"github.com/bogem/nehm/track"
 )
@@ -40,8 +42,121 @@ func AllFavorites(uid string) ([]track.Track, error) {
 	return tracks, nil
 }
 
+// UserTracks returns the last tracks uploaded by user with uid.
+func UserTracks(limit uint, uid string) ([]track.Track, error) {
+	p := NewPaginator(FormUserTracksURL(limit, uid))
+	return p.NextPage()
+}
+
+// RelatedTracks returns tracks, which SoundCloud considers
+// related to the track with id.
+func RelatedTracks(id int, limit uint) ([]track.Track, error) {
+	bTracks, err := get(FormRelatedURL(limit, id))
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []track.Track
+	if err := decode(bTracks, &tracks); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal JSON with related tracks: %v", err)
+	}
+	checkTracks(tracks)
+	return tracks, nil
+}
+
+// Track returns the track with id.
+func Track(id int) (track.Track, error) {
+	return trackFrom(get(formTrackURL(id)))
+}
+
+// LookupTrack is like Track, but it returns error on unexpected status
+// of response (e.g. 429 or 5xx) instead of terminating the program.
+func LookupTrack(id int) (track.Track, error) {
+	return trackFrom(tryGet(formTrackURL(id)))
+}
+
+// trackFrom unmarshals track from body of response.
+func trackFrom(body []byte, err error) (track.Track, error) {
+	if err != nil {
+		return track.Track{}, err
+	}
+
+	var t track.Track
+	if err := decode(body, &t); err != nil {
+		return t, fmt.Errorf("couldn't unmarshal JSON with track: %v", err)
+	}
+	checkTracks([]track.Track{t})
+	return t, nil
+}
+
+// StreamURL returns the URL of stream of transcoding.
+func StreamURL(tr track.Transcoding) (string, error) {
+	sep := "?"
+	if strings.Contains(tr.URL, "?") {
+		sep = "&"
+	}
+	body, err := get(tr.URL + sep + "client_id=" + clientID)
+	if err != nil {
+		return "", err
+	}
+
+	var stream struct {
+		URL string `json:"url"`
+	}
+	if err := decode(body, &stream); err != nil {
+		return "", fmt.Errorf("couldn't unmarshal JSON with stream: %v", err)
+	}
+	if stream.URL == "" {
+		apihealth.Anomaly("stream without url")
+		return "", fmt.Errorf("there is no URL of stream")
+	}
+	return stream.URL, nil
+}
+
+// JSONComment is the comment on track. Timestamp is the position
+// in milliseconds, where comment was left.
+type JSONComment struct {
+	Body      string `json:"body"`
+	Timestamp *int   `json:"timestamp"`
+	CreatedAt string `json:"created_at"`
+	User      struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// Comments returns comments on the track with id.
+func Comments(id int) ([]JSONComment, error) {
+	bComments, err := get(FormCommentsURL(maxLimit, id))
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []JSONComment
+	if err := decode(bComments, &comments); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal JSON with comments: %v", err)
+	}
+	return comments, nil
+}
+
 type JSONUser struct {
-	ID int `json:"id"`
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	Permalink string `json:"permalink"`
+	Plan      string `json:"plan"`
+}
+
+// Me returns the user authenticated with oauthToken.
+func Me(oauthToken string) (JSONUser, error) {
+	var jUser JSONUser
+
+	bUser, err := get(formMeURL(oauthToken))
+	if err != nil {
+		return jUser, err
+	}
+	if err := decode(bUser, &jUser); err != nil {
+		return jUser, fmt.Errorf("couldn't unmarshall JSON with user object: %v", err)
+	}
+	return jUser, nil
 }
 
 func UID(permalink string) string {
@@ -52,7 +167,7 @@ func UID(permalink string) string {
 	}
 
 	var jUser JSONUser
-	if err := json.Unmarshal(bUser, &jUser); err != nil {
+	if err := decode(bUser, &jUser); err != nil {
 		logs.FATAL.Fatalln("couldn't unmarshall JSON with user object:", err)
 	}
 
@@ -73,9 +188,47 @@ func TrackFromURL(url string) []track.Track {
 	}
 
 	var t track.Track
-	if err := json.Unmarshal(bTrack, &t); err != nil {
+	if err := decode(bTrack, &t); err != nil {
 		logs.FATAL.Fatalln("couldn't unmarshal JSON with track from URL:", err)
 	}
 
 	return []track.Track{t}
 }
+
+// ResolveTracks returns tracks from URL of track or playlist,
+// e.g. from URL of embedded player.
+func ResolveTracks(url string) ([]track.Track, error) {
+	_, tracks, err := ResolvePlaylist(url)
+	return tracks, err
+}
+
+// ResolvePlaylist is like ResolveTracks, but it also returns the title
+// of playlist. If url is the URL of track, title is blank.
+func ResolvePlaylist(url string) (title string, tracks []track.Track, err error) {
+	body, err := get(formResolveURL("url=" + url))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var resource struct {
+		Kind   string        `json:"kind"`
+		Title  string        `json:"title"`
+		Tracks []track.Track `json:"tracks"`
+	}
+	if err := decode(body, &resource); err != nil {
+		return "", nil, fmt.Errorf("couldn't unmarshal JSON with resolved object: %v", err)
+	}
+	switch resource.Kind {
+	case "playlist":
+		checkTracks(resource.Tracks)
+		return resource.Title, resource.Tracks, nil
+	case "track":
+		var t track.Track
+		if err := decode(body, &t); err != nil {
+			return "", nil, fmt.Errorf("couldn't unmarshal JSON with track: %v", err)
+		}
+		return "", []track.Track{t}, nil
+	default:
+		return "", nil, fmt.Errorf("%q is %v, not track or playlist", url, resource.Kind)
+	}
+}
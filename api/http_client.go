@@ -5,11 +5,16 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"regexp"
 	"strconv"
 
+	"github.com/bogem/nehm/apihealth"
+	"github.com/bogem/nehm/httpclient"
 	"github.com/bogem/nehm/logs"
-	"github.com/valyala/fasthttp"
+	"github.com/bogem/nehm/track"
 )
 
 const (
@@ -27,6 +32,14 @@ func formResolveURL(query string) string {
 	return apiURL + "/resolve?client_id=" + clientID + "&" + query
 }
 
+func formTrackURL(id int) string {
+	return apiURL + "/tracks/" + strconv.Itoa(id) + "?client_id=" + clientID
+}
+
+func formMeURL(oauthToken string) string {
+	return apiURL + "/me?oauth_token=" + oauthToken
+}
+
 func FormSearchURL(limit uint, query string) string {
 	url := apiURL + "/tracks?" + baseParams
 	url += "&limit=" + utoa(limit) + "&q=" + query
@@ -39,18 +52,82 @@ func FormFavoritesURL(limit uint, uid string) string {
 	return url
 }
 
+func FormUserTracksURL(limit uint, uid string) string {
+	url := apiURL + "/users/" + uid + "/tracks?" + baseParams
+	url += "&limit=" + utoa(limit)
+	return url
+}
+
+func FormRelatedURL(limit uint, id int) string {
+	url := apiURL + "/tracks/" + strconv.Itoa(id) + "/related?client_id=" + clientID
+	url += "&limit=" + utoa(limit)
+	return url
+}
+
+func FormCommentsURL(limit uint, id int) string {
+	url := apiURL + "/tracks/" + strconv.Itoa(id) + "/comments?client_id=" + clientID
+	url += "&limit=" + utoa(limit)
+	return url
+}
+
 func get(url string) ([]byte, error) {
-	logs.INFO.Println("GET", url)
-	statusCode, body, err := fasthttp.Get(nil, url)
+	return request(url, handleStatusCode)
+}
+
+// tryGet is like get, but it returns error on unexpected status
+// instead of terminating the program.
+func tryGet(url string) ([]byte, error) {
+	return request(url, statusError)
+}
+
+// request requests url and checks status of response with check.
+func request(url string, check func(int) error) ([]byte, error) {
+	logs.INFO.Println("GET", redactToken(url))
+	statusCode, body, err := httpclient.Get(nil, url)
 	if err != nil {
+		apihealth.Request(0)
 		return nil, err
 	}
-	if err := handleStatusCode(statusCode); err != nil {
+	apihealth.Request(statusCode)
+	if err := check(statusCode); err != nil {
 		return nil, err
 	}
 	return body, nil
 }
 
+// decode unmarshals JSON response of API to v. If response doesn't
+// match v, it's recorded as anomaly of API.
+func decode(data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		apihealth.Anomaly(fmt.Sprintf("invalid JSON in %T", v))
+	}
+	return err
+}
+
+// checkTracks records anomalies of API, if tracks miss fields,
+// which are needed to download them.
+func checkTracks(tracks []track.Track) {
+	for _, t := range tracks {
+		if t.JID == 0 {
+			apihealth.Anomaly("track without id")
+		}
+		if t.JTitle == "" {
+			apihealth.Anomaly("track without title")
+		}
+		if t.JURL == "" && !t.JDownloadable && len(t.Transcodings()) == 0 {
+			apihealth.Anomaly("track without stream_url")
+		}
+	}
+}
+
+var tokenRe = regexp.MustCompile(`oauth_token=[^&]*`)
+
+// redactToken hides OAuth token in url, so it doesn't leak to logs.
+func redactToken(url string) string {
+	return tokenRe.ReplaceAllString(url, "oauth_token=***")
+}
+
 func handleStatusCode(statusCode int) error {
 	switch {
 	case statusCode == 403:
@@ -65,6 +142,19 @@ func handleStatusCode(statusCode int) error {
 	return nil
 }
 
+// statusError returns error, if statusCode is not successful.
+func statusError(statusCode int) error {
+	switch {
+	case statusCode == 403:
+		return ErrForbidden
+	case statusCode == 404:
+		return ErrNotFound
+	case statusCode >= 300:
+		return fmt.Errorf("invalid response from SoundCloud: %v", statusCode)
+	}
+	return nil
+}
+
 func utoa(u uint) string {
 	return strconv.Itoa(int(u))
 }
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"encoding/json"
 	"errors"
 
 	"github.com/bogem/nehm/track"
@@ -33,6 +32,25 @@ func NewPaginator(firstPageURL string) *Paginator {
 	return &Paginator{currentPage: -1, nextHref: firstPageURL}
 }
 
+// NewPaginatorFromTracks returns new paginator, which shows tracks
+// by pages with pageSize tracks on each page without any requests.
+func NewPaginatorFromTracks(tracks []track.Track, pageSize uint) *Paginator {
+	if pageSize == 0 {
+		pageSize = uint(len(tracks))
+	}
+
+	p := &Paginator{currentPage: -1}
+	for len(tracks) > 0 {
+		n := int(pageSize)
+		if n > len(tracks) {
+			n = len(tracks)
+		}
+		p.tracksCache = append(p.tracksCache, tracks[:n])
+		tracks = tracks[n:]
+	}
+	return p
+}
+
 func getPage(url string) (paginatedResponse, error) {
 	pResponse := paginatedResponse{}
 
@@ -41,7 +59,9 @@ func getPage(url string) (paginatedResponse, error) {
 		return pResponse, err
 	}
 
-	err = json.Unmarshal(response, &pResponse)
+	if err = decode(response, &pResponse); err == nil {
+		checkTracks(pResponse.Collection)
+	}
 	return pResponse, err
 }
 
@@ -70,7 +90,7 @@ func (p *Paginator) NextPage() ([]track.Track, error) {
 
 // OnLastPage checks, if current page is last.
 func (p Paginator) OnLastPage() bool {
-	return p.nextHref == ""
+	return p.nextHref == "" && p.currentPage >= len(p.tracksCache)-1
 }
 
 // PrevPage returns tracks on the previous page and error, if it occured.
@@ -0,0 +1,194 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package apihealth collects statistics of errors and schema anomalies
+// of SoundCloud API by days. Statistics are only kept locally
+// in state folder and only if apiHealth is enabled in config.
+package apihealth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/config"
+)
+
+// maxDays is the count of days, which statistics are kept for.
+const maxDays = 90
+
+const dayLayout = "2006-01-02"
+
+// Day is the statistics of API for one day. Errors are counted by
+// their kinds, e.g. "404" or "network", anomalies by their descriptions.
+type Day struct {
+	Date      string         `json:"date"`
+	Requests  int            `json:"requests"`
+	Errors    map[string]int `json:"errors,omitempty"`
+	Anomalies map[string]int `json:"anomalies,omitempty"`
+}
+
+// ErrorCount returns the count of failed requests.
+func (d Day) ErrorCount() int {
+	var n int
+	for _, c := range d.Errors {
+		n += c
+	}
+	return n
+}
+
+// AnomalyCount returns the count of found anomalies.
+func (d Day) AnomalyCount() int {
+	var n int
+	for _, c := range d.Anomalies {
+		n += c
+	}
+	return n
+}
+
+var (
+	mu      sync.Mutex
+	days    map[string]*Day
+	loaded  bool
+	changed bool
+)
+
+// Enabled reports whether statistics are collected.
+func Enabled() bool {
+	return config.GetBool("apiHealth")
+}
+
+func statsPath() string {
+	return filepath.Join(config.StateDir(), "apihealth.json")
+}
+
+// Request records the finished request to API.
+// statusCode is 0, if request failed because of network.
+func Request(statusCode int) {
+	record(func(d *Day) {
+		d.Requests++
+		switch {
+		case statusCode == 0:
+			d.Errors["network"]++
+		case statusCode >= 300:
+			d.Errors[fmt.Sprint(statusCode)]++
+		}
+	})
+	// Errors are often fatal, so they're saved immediately.
+	if statusCode == 0 || statusCode >= 300 {
+		Save()
+	}
+}
+
+// Anomaly records, that response of API didn't match the expected schema.
+func Anomaly(description string) {
+	record(func(d *Day) {
+		d.Anomalies[description]++
+	})
+}
+
+func record(f func(*Day)) {
+	if !Enabled() {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !loaded {
+		days, _ = load()
+		loaded = true
+	}
+	date := time.Now().Format(dayLayout)
+	d, ok := days[date]
+	if !ok {
+		d = &Day{Date: date}
+		days[date] = d
+	}
+	if d.Errors == nil {
+		d.Errors = make(map[string]int)
+	}
+	if d.Anomalies == nil {
+		d.Anomalies = make(map[string]int)
+	}
+	f(d)
+	changed = true
+}
+
+func load() (map[string]*Day, error) {
+	m := make(map[string]*Day)
+	data, err := ioutil.ReadFile(statsPath())
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, fmt.Errorf("couldn't read statistics of API: %v", err)
+	}
+
+	var list []*Day
+	if err := json.Unmarshal(data, &list); err != nil {
+		return m, fmt.Errorf("couldn't unmarshal statistics of API: %v", err)
+	}
+	for _, d := range list {
+		m[d.Date] = d
+	}
+	return m, nil
+}
+
+// Save writes recorded statistics to disk. Days older than
+// maxDays are removed.
+func Save() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	threshold := time.Now().AddDate(0, 0, -maxDays).Format(dayLayout)
+	list := make([]*Day, 0, len(days))
+	for date, d := range days {
+		if date >= threshold {
+			list = append(list, d)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Date < list[j].Date })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.StateDir(), 0755); err != nil {
+		return fmt.Errorf("couldn't create state folder: %v", err)
+	}
+	if err := ioutil.WriteFile(statsPath(), data, 0644); err != nil {
+		return fmt.Errorf("couldn't save statistics of API: %v", err)
+	}
+	changed = false
+	return nil
+}
+
+// Days returns statistics of the last n days, from the oldest one.
+// Days without requests are skipped.
+func Days(n int) ([]Day, error) {
+	m, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := time.Now().AddDate(0, 0, -n+1).Format(dayLayout)
+	var list []Day
+	for date, d := range m {
+		if date >= threshold {
+			list = append(list, *d)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Date < list[j].Date })
+	return list, nil
+}
@@ -5,43 +5,236 @@
 package applescript
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/bogem/nehm/tempdir"
+)
+
+// Names of applications, which can be controlled with script.
+// macOS 10.15 (Catalina) renamed iTunes to Music.
+const (
+	ITunes = "iTunes"
+	Music  = "Music"
 )
 
+// App is the name of application, which is controlled with script.
+// If it's blank, it's detected by the version of macOS.
+var App string
+
 var (
 	script = []byte(`
 on run argv
 	set commandType to first item of argv as string
 	if (commandType is equal to "add_track_to_playlist") then
-		add_track_to_playlist(second item of argv, third item of argv)
+		add_track_to_playlist(second item of argv, third item of argv, fourth item of argv, fifth item of argv)
+	else if (commandType is equal to "add_tracks_to_playlist") then
+		add_tracks_to_playlist(argv)
+	else if (commandType is equal to "create_playlist") then
+		create_playlist(second item of argv)
+	else if (commandType is equal to "check_and_create_playlist") then
+		check_and_create_playlist(second item of argv, third item of argv)
 	else if (commandType is equal to "list_of_playlists") then
 		list_of_playlists()
+	else if (commandType is equal to "list_tracks_of_playlist") then
+		list_tracks_of_playlist(second item of argv)
 	end if
 end run
 
-on add_track_to_playlist(trackPath, playlistName)
+on add_track_to_playlist(trackPath, playlistName, isLoved, starRating)
 	tell application "iTunes"
-		add (trackPath as POSIX file) to playlist playlistName
+		set addedTrack to add (trackPath as POSIX file) to playlist playlistName
+		-- iTunes adds nothing without error, if format of file is not supported.
+		if addedTrack is missing value then
+			error "iTunes rejected " & trackPath & ", its format may be unsupported"
+		end if
+		if isLoved is equal to "true" then
+			set loved of addedTrack to true
+		end if
+		if starRating as integer > 0 then
+			set rating of addedTrack to (starRating as integer) * 20
+		end if
+		set trackID to persistent ID of addedTrack
+		if not (exists (some track of playlist playlistName whose persistent ID is trackID)) then
+			error "track " & trackPath & " isn't in playlist " & playlistName & " after adding"
+		end if
+		return trackID & tab & POSIX path of (location of addedTrack)
 	end tell
 end add_track_to_playlist
 
+-- argv is the command, the playlist and then path, loved and rating of each track.
+-- Tracks are added, even if some of them fail. The result of each track
+-- is the line with its persistent ID and location or with the error.
+on add_tracks_to_playlist(argv)
+	set playlistName to second item of argv
+	set output to ""
+	repeat with i from 3 to (count of argv) by 3
+		try
+			set output to output & "ok" & tab & add_track_to_playlist(item i of argv, playlistName, item (i + 1) of argv, item (i + 2) of argv) & linefeed
+		on error errorMessage
+			set output to output & "error" & tab & errorMessage & linefeed
+		end try
+	end repeat
+	return output
+end add_tracks_to_playlist
+
+on create_playlist(playlistName)
+	tell application "iTunes"
+		if not (exists user playlist playlistName) then
+			make new user playlist with properties {name:playlistName}
+		end if
+	end tell
+end create_playlist
+
+on check_and_create_playlist(playlistName, folderName)
+	tell application "iTunes"
+		if not (exists user playlist playlistName) then
+			set newPlaylist to make new user playlist with properties {name:playlistName}
+			if folderName is not equal to "" then
+				if not (exists folder playlist folderName) then
+					make new folder playlist with properties {name:folderName}
+				end if
+				move newPlaylist to folder playlist folderName
+			end if
+		end if
+	end tell
+end check_and_create_playlist
+
 on list_of_playlists()
 	tell application "iTunes"
 		get name of playlists
 	end tell
 end list_of_playlists
+
+on list_tracks_of_playlist(playlistName)
+	tell application "iTunes"
+		set output to ""
+		repeat with t in (file tracks of playlist playlistName)
+			set trackLocation to ""
+			try
+				set trackLocation to POSIX path of (location of t)
+			end try
+			set output to output & (artist of t) & tab & (name of t) & tab & trackLocation & linefeed
+		end repeat
+		return output
+	end tell
+end list_tracks_of_playlist
 `)
 
 	scriptFile *os.File
 )
 
-func AddTrackToPlaylist(trackPath, playlistName string) error {
-	_, err := executeOSAScript("add_track_to_playlist", "./"+trackPath, playlistName)
+// TrackProperties are the properties set to track added to iTunes.
+// Zero values don't change the properties of track.
+type TrackProperties struct {
+	Loved bool
+	// Rating is the rating in stars from 1 to 5.
+	Rating int
+}
+
+// AddedTrack is the track added to iTunes.
+type AddedTrack struct {
+	// PersistentID is the ID of track in iTunes library, e.g. "6E2F4F8B1A2C3D4E".
+	PersistentID string
+	// Location is the path of track file in iTunes library.
+	Location string
+}
+
+// AddResult is the result of adding one track with AddTracksToPlaylist.
+type AddResult struct {
+	AddedTrack
+	Err error
+}
+
+// AddTrackToPlaylist adds track to iTunes playlist, sets props to it, checks,
+// that track is in playlist, and returns the added track. If iTunes is set up
+// to copy files to its media folder, its location differs from trackPath.
+// If iTunes rejected the file (e.g. because of format), error is returned.
+func AddTrackToPlaylist(trackPath, playlistName string, props TrackProperties) (AddedTrack, error) {
+	absPath, err := filepath.Abs(trackPath)
+	if err != nil {
+		return AddedTrack{}, err
+	}
+	out, err := executeOSAScript("add_track_to_playlist", absPath, playlistName,
+		strconv.FormatBool(props.Loved), strconv.Itoa(props.Rating))
+	if err != nil {
+		return AddedTrack{}, err
+	}
+	return parseAddedTrack(out)
+}
+
+// AddTracksToPlaylist adds tracks at trackPaths to iTunes playlist with one
+// call of osascript, so iTunes isn't woken up for each track. props[i]
+// are set to the track at trackPaths[i] and results[i] is its result.
+// Error is returned, only if osascript failed.
+func AddTracksToPlaylist(trackPaths []string, playlistName string, props []TrackProperties) (results []AddResult, err error) {
+	args := []string{"add_tracks_to_playlist", playlistName}
+	for i, path := range trackPaths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, absPath, strconv.FormatBool(props[i].Loved), strconv.Itoa(props[i].Rating))
+	}
+	out, err := executeOSAScript(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(out, "\n")
+	results = make([]AddResult, len(trackPaths))
+	for i := range results {
+		if i >= len(lines) {
+			results[i].Err = errors.New("iTunes didn't report the result of adding track")
+			continue
+		}
+		fields := strings.SplitN(lines[i], "\t", 2)
+		if len(fields) == 2 && fields[0] == "ok" {
+			results[i].AddedTrack, results[i].Err = parseAddedTrack(fields[1])
+		} else {
+			results[i].Err = errors.New(fields[len(fields)-1])
+		}
+	}
+	return results, nil
+}
+
+// parseAddedTrack parses the output "persistentID\tlocation" of add_track_to_playlist.
+func parseAddedTrack(out string) (AddedTrack, error) {
+	fields := strings.SplitN(out, "\t", 2)
+	if len(fields) != 2 {
+		return AddedTrack{}, fmt.Errorf("unexpected answer of iTunes %q", out)
+	}
+	return AddedTrack{PersistentID: fields[0], Location: fields[1]}, nil
+}
+
+// IsBusy reports whether err means, that iTunes is busy (e.g. it's syncing)
+// and didn't answer in time.
+func IsBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "AppleEvent timed out") || strings.Contains(msg, "(-1712)")
+}
+
+// CreatePlaylist creates iTunes playlist with playlistName,
+// if it doesn't exist yet.
+func CreatePlaylist(playlistName string) error {
+	_, err := executeOSAScript("create_playlist", playlistName)
+	return err
+}
+
+// CheckAndCreatePlaylist creates iTunes playlist with playlistName,
+// if it doesn't exist yet. If folderName is not blank, new playlist
+// is created in folder with folderName, which is created, if needed.
+func CheckAndCreatePlaylist(playlistName, folderName string) error {
+	_, err := executeOSAScript("check_and_create_playlist", playlistName, folderName)
 	return err
 }
 
@@ -49,15 +242,44 @@ func ListOfPlaylists() (string, error) {
 	return executeOSAScript("list_of_playlists")
 }
 
+// PlaylistTrack is the track in iTunes playlist.
+type PlaylistTrack struct {
+	Artist, Title string
+	// Location is the path of track file. It's blank,
+	// if file of track is missing.
+	Location string
+}
+
+// TracksOfPlaylist returns tracks of iTunes playlist.
+func TracksOfPlaylist(playlistName string) ([]PlaylistTrack, error) {
+	out, err := executeOSAScript("list_tracks_of_playlist", playlistName)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []PlaylistTrack
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		tracks = append(tracks, PlaylistTrack{fields[0], fields[1], fields[2]})
+	}
+	return tracks, nil
+}
+
 // executeOSAScript executes AppleScript script with args and returns output and error.
 func executeOSAScript(args ...string) (string, error) {
 	if scriptFile == nil {
 		var err error
-		scriptFile, err = ioutil.TempFile("", "nehm-osascript")
+		scriptFile, err = tempdir.TempFile("osascript")
 		if err != nil {
 			return "", fmt.Errorf("couldn't create osascript file: %v", err)
 		}
-		if _, err = scriptFile.Write(script); err != nil {
+		// Application can't be a variable in AppleScript, because its
+		// terminology is loaded while compiling the script.
+		s := bytes.Replace(script, []byte(`application "iTunes"`), []byte(`application "`+app()+`"`), -1)
+		if _, err = scriptFile.Write(s); err != nil {
 			return "", fmt.Errorf("couldn't write script to file: %v", err)
 		}
 	}
@@ -71,3 +293,25 @@ func executeOSAScript(args ...string) (string, error) {
 	}
 	return out, err
 }
+
+// app returns App or, if it's blank, the application of installed macOS.
+func app() string {
+	if App != "" {
+		return App
+	}
+	out, err := exec.Command("sw_vers", "-productVersion").Output()
+	if err != nil {
+		return ITunes
+	}
+	// Version is like "10.14.6" or "11.2".
+	parts := strings.Split(strings.TrimSpace(string(out)), ".")
+	major, _ := strconv.Atoi(parts[0])
+	var minor int
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	if major > 10 || (major == 10 && minor >= 15) {
+		return Music
+	}
+	return ITunes
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package audit keeps the append-only log of destructive operations
+// made by nehm with files, like overwriting and renaming.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+)
+
+// Operations logged in audit log.
+const (
+	Overwrite = "overwrite"
+	Rename    = "rename"
+	Remove    = "remove"
+)
+
+// Record is one record in audit log.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Path      string    `json:"path"`
+	Reason    string    `json:"reason,omitempty"`
+	// Label is the label of batch (--label flag), if it's set.
+	Label string `json:"label,omitempty"`
+}
+
+var mu sync.Mutex
+
+func auditPath() string {
+	return filepath.Join(config.StateDir(), "audit.log")
+}
+
+// Log appends the record about operation with path to audit log.
+// Errors are only reported, because they shouldn't stop the operation.
+func Log(operation, path, reason string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := write(Record{time.Now(), operation, path, reason, config.Get("label")}); err != nil {
+		logs.WARN.Println("couldn't write to audit log:", err)
+	}
+}
+
+func write(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(config.StateDir(), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(auditPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Records returns all records from audit log in chronological order.
+func Records() ([]Record, error) {
+	f, err := os.Open(auditPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return records, fmt.Errorf("couldn't parse audit log: %v", err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
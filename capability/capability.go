@@ -0,0 +1,180 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package capability probes optional external programs, which some
+// features of nehm need, so these features are disabled at startup
+// with clear message instead of failing in the middle of downloading.
+// Results of probing are cached in state folder.
+package capability
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+)
+
+// Tool is the optional external program.
+type Tool struct {
+	Name string
+	// ConfigKey is the key in config with the path of program, if it can be set.
+	ConfigKey string
+	// VersionArgs are arguments, with which program prints its version.
+	VersionArgs []string
+	// Features are the features of nehm, which need tool.
+	Features string
+	// OS is the only system, where tool is used. Blank means all systems.
+	OS string
+}
+
+// Tools are the programs, which are probed.
+var Tools = []Tool{
+	{Name: "ffmpeg", ConfigKey: "ffmpegPath", VersionArgs: []string{"-version"}, Features: "trims"},
+	{Name: "osascript", Features: "adding to iTunes, desktop notifications", OS: "darwin"},
+	{Name: "notify-send", VersionArgs: []string{"--version"}, Features: "desktop notifications", OS: "linux"},
+	{Name: "cscript", Features: "adding to iTunes", OS: "windows"},
+}
+
+// Result is the result of probing of tool.
+type Result struct {
+	Tool `json:"-"`
+	// Program is the program, which was looked for, e.g. ffmpegPath.
+	Program string `json:"program"`
+	// Path is the path of found program. It's blank, if program wasn't found.
+	Path string `json:"path,omitempty"`
+	// Version is the first line of version of program, if it's known.
+	Version   string    `json:"version,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Found reports whether program was found.
+func (r Result) Found() bool {
+	return r.Path != ""
+}
+
+// cacheTTL is the time, after which programs are probed again.
+const cacheTTL = 24 * time.Hour
+
+var (
+	mu     sync.Mutex
+	cache  map[string]Result
+	loaded bool
+)
+
+func cachePath() string {
+	return filepath.Join(config.StateDir(), "capabilities.json")
+}
+
+// Available reports whether tool with name is found. Tools, which are not
+// used on this system, are never available.
+func Available(name string) bool {
+	for _, t := range Tools {
+		if t.Name == name {
+			return Check(t).Found()
+		}
+	}
+	return false
+}
+
+// Check returns the result of probing of t. Cached result is used,
+// if it's fresh and program wasn't changed in config.
+func Check(t Tool) Result {
+	mu.Lock()
+	defer mu.Unlock()
+
+	load()
+	program := programOf(t)
+	if r, ok := cache[t.Name]; ok && r.Program == program && time.Since(r.CheckedAt) < cacheTTL {
+		r.Tool = t
+		return r
+	}
+	r := probe(t, program)
+	cache[t.Name] = r
+	save()
+	return r
+}
+
+// Refresh probes all tools used on this system again and returns the results.
+func Refresh() []Result {
+	mu.Lock()
+	defer mu.Unlock()
+
+	load()
+	var results []Result
+	for _, t := range Tools {
+		if t.OS != "" && t.OS != runtime.GOOS {
+			continue
+		}
+		r := probe(t, programOf(t))
+		cache[t.Name] = r
+		results = append(results, r)
+	}
+	save()
+	return results
+}
+
+func programOf(t Tool) string {
+	if t.ConfigKey != "" {
+		if path := config.Get(t.ConfigKey); path != "" {
+			return path
+		}
+	}
+	return t.Name
+}
+
+func probe(t Tool, program string) Result {
+	r := Result{Tool: t, Program: program, CheckedAt: time.Now()}
+	if t.OS != "" && t.OS != runtime.GOOS {
+		return r
+	}
+	path, err := exec.LookPath(program)
+	if err != nil {
+		logs.INFO.Printf("%v isn't found: %v\n", program, err)
+		return r
+	}
+	r.Path = path
+	if len(t.VersionArgs) > 0 {
+		if out, err := exec.Command(path, t.VersionArgs...).Output(); err == nil {
+			r.Version = strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+		}
+	}
+	return r
+}
+
+// load reads the cache from disk once. Broken cache is ignored,
+// so tools are probed again.
+func load() {
+	if loaded {
+		return
+	}
+	loaded = true
+	cache = make(map[string]Result)
+	if data, err := ioutil.ReadFile(cachePath()); err == nil {
+		if err := json.Unmarshal(data, &cache); err != nil {
+			cache = make(map[string]Result)
+		}
+	}
+}
+
+// save writes the cache to disk. Errors are only logged,
+// because tools can be probed again next time.
+func save() {
+	data, err := json.Marshal(cache)
+	if err == nil {
+		if err = os.MkdirAll(config.StateDir(), 0755); err == nil {
+			err = ioutil.WriteFile(cachePath(), data, 0644)
+		}
+	}
+	if err != nil {
+		logs.INFO.Println("couldn't save the cache of capabilities:", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bogem/nehm/apihealth"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	apihealthCommand = &cobra.Command{
+		Use:   "apihealth",
+		Short: "Show statistics of errors and anomalies of SoundCloud API.",
+		Long:  "This command shows error rates and unexpected responses of SoundCloud API by days, so you can tell, if your config is broken or SoundCloud changed its API. Statistics are collected only if apiHealth is set to true in config.",
+		Run:   showAPIHealth,
+	}
+)
+
+var healthDays int
+
+func init() {
+	apihealthCommand.Flags().IntVar(&healthDays, "days", 7, "count of last days to show")
+}
+
+func showAPIHealth(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	days, err := apihealth.Days(healthDays)
+	if err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+	if len(days) == 0 {
+		if !apihealth.Enabled() {
+			logs.FEEDBACK.Println("Statistics of API are not collected. Set apiHealth to true in config to collect them")
+		} else {
+			logs.FEEDBACK.Println("There are no statistics of API for these days")
+		}
+		return
+	}
+
+	for _, d := range days {
+		logs.FEEDBACK.Printf("%v  requests: %v, errors: %v (%.1f%%), anomalies: %v\n",
+			d.Date, d.Requests, d.ErrorCount(), rate(d.ErrorCount(), d.Requests), d.AnomalyCount())
+		if len(d.Errors) > 0 {
+			logs.FEEDBACK.Println("    errors:", counts(d.Errors))
+		}
+		if len(d.Anomalies) > 0 {
+			logs.FEEDBACK.Println("    anomalies:", counts(d.Anomalies))
+		}
+	}
+
+	if hint := healthHint(days); hint != "" {
+		logs.FEEDBACK.Println()
+		logs.FEEDBACK.Println(hint)
+	}
+}
+
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// counts formats counts like "404: 3, network: 1".
+func counts(m map[string]int) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%v: %v", k, m[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// healthHint guesses the cause of problems on the last day.
+func healthHint(days []apihealth.Day) string {
+	last := days[len(days)-1]
+	if last.ErrorCount() == 0 && last.AnomalyCount() == 0 {
+		return "There were no problems with SoundCloud API on the last day."
+	}
+
+	auth := last.Errors["401"] + last.Errors["403"]
+	var server int
+	for kind, c := range last.Errors {
+		if strings.HasPrefix(kind, "5") {
+			server += c
+		}
+	}
+
+	var anomaliesBefore int
+	for _, d := range days[:len(days)-1] {
+		anomaliesBefore += d.AnomalyCount()
+	}
+
+	switch {
+	case last.AnomalyCount() > 0 && anomaliesBefore == 0 && len(days) > 1:
+		return "Responses of SoundCloud API changed recently. It's probably not a problem with your config."
+	case 2*auth > last.ErrorCount():
+		return "Most errors are 401 and 403. Check oauthToken and permalink in config."
+	case 2*server > last.ErrorCount():
+		return "Most errors are on SoundCloud side. Please wait a while."
+	case 2*last.Errors["network"] > last.ErrorCount():
+		return "Most errors are network errors. Check your internet connection and proxy."
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogem/nehm/api"
+	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	artworkCommand = &cobra.Command{
+		Use:   "artwork <folder|url>",
+		Short: "Fetch artworks of downloaded tracks again and embed them.",
+		Long:  "This command fetches artworks (in artworkSize) of tracks in folder or of downloaded tracks from URL of track or playlist and embeds them instead of existing ones. Tracks in folder are found by index or by ID in their tags. Audio is not downloaded again.",
+		Run:   fetchArtworks,
+	}
+)
+
+// artworkExts are extensions of files, to which artworks can be embedded.
+var artworkExts = map[string]bool{".mp3": true, ".flac": true, ".m4a": true, ".opus": true}
+
+func fetchArtworks(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	if len(args) != 1 {
+		logs.FATAL.Fatalln("you didn't give a folder or URL. Use 'nehm artwork <folder|url>'.")
+	}
+
+	var paths []string
+	var ids []int
+	if fi, err := os.Stat(args[0]); err == nil && fi.IsDir() {
+		paths, ids = tracksInFolder(args[0])
+	} else {
+		paths, ids = downloadedTracksFromURL(args[0])
+	}
+	if len(paths) == 0 {
+		logs.FEEDBACK.Println("There are no downloaded tracks to fetch artworks for")
+		return
+	}
+
+	dl := downloader.NewConfiguredDownloader()
+	var failed int
+	for i, path := range paths {
+		logs.FEEDBACK.Printf("Fetching artwork for %q ... ", filepath.Base(path))
+		t, err := api.Track(ids[i])
+		if err == nil {
+			err = dl.ReplaceArtwork(path, t)
+		}
+		if err != nil {
+			failed++
+			logs.FEEDBACK.Println("✘")
+			logs.ERROR.Println(err)
+			continue
+		}
+		logs.FEEDBACK.Println("✔︎")
+	}
+
+	logs.FEEDBACK.Printf("\n%v artwork(s) embedded, %v failed\n", len(paths)-failed, failed)
+	if failed > 0 {
+		exit(1)
+	}
+}
+
+// tracksInFolder returns paths and IDs of tracks in folder,
+// whose source is known.
+func tracksInFolder(folder string) (paths []string, ids []int) {
+	var unknown int
+	err := filepath.Walk(folder, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			logs.WARN.Println(err)
+			return nil
+		}
+		if fi.IsDir() || !artworkExts[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		id, ok := downloader.SourceID(path)
+		if !ok {
+			unknown++
+			return nil
+		}
+		paths = append(paths, path)
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		logs.FATAL.Fatalln("couldn't scan folder:", err)
+	}
+	if unknown > 0 {
+		logs.WARN.Printf("%v file(s) are skipped, because they're not in index and have no ID of track in tag\n", unknown)
+	}
+	return paths, ids
+}
+
+// downloadedTracksFromURL returns paths and IDs of downloaded tracks
+// from URL of track or playlist.
+func downloadedTracksFromURL(url string) (paths []string, ids []int) {
+	tracks, err := api.ResolveTracks(url)
+	if err != nil {
+		logs.FATAL.Fatalln("couldn't get tracks from URL:", err)
+	}
+	for _, t := range tracks {
+		e, exists := index.Get(t.ID())
+		if !exists {
+			logs.WARN.Printf("%q isn't downloaded\n", t.Fullname())
+			continue
+		}
+		paths = append(paths, e.Path)
+		ids = append(ids, t.ID())
+	}
+	return paths, ids
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"strconv"
+
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	buylistCommand = &cobra.Command{
+		Use:   "buylist [ids of purchased tracks]",
+		Short: "Show links to buy or download better versions of downloaded tracks.",
+		Long:  "This command shows downloaded tracks, which have purchase or free download links. Use flag '--mark' with IDs of tracks to mark them as purchased.",
+		Run:   buylist,
+	}
+)
+
+// markPurchased is the flag, which marks tracks with IDs from arguments
+// as purchased.
+var markPurchased bool
+
+func init() {
+	buylistCommand.Flags().BoolVar(&markPurchased, "mark", false, "mark tracks with entered IDs as purchased")
+}
+
+func buylist(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	if markPurchased {
+		markTracksPurchased(args)
+		return
+	}
+
+	var count int
+	for _, e := range index.All() {
+		if e.Purchased || (e.BuyURL == "" && e.FreeDownloadURL == "") {
+			continue
+		}
+		count++
+
+		logs.FEEDBACK.Printf("%v (ID: %v)\n", e.Fullname(), e.ID)
+		if e.BuyURL != "" {
+			logs.FEEDBACK.Println("  Buy:          ", e.BuyURL)
+		}
+		if e.FreeDownloadURL != "" {
+			logs.FEEDBACK.Println("  Free download:", e.FreeDownloadURL)
+		}
+	}
+
+	if count == 0 {
+		logs.FEEDBACK.Println("There are no tracks to buy")
+	}
+}
+
+func markTracksPurchased(ids []string) {
+	if len(ids) == 0 {
+		logs.FATAL.Fatalln("you didn't enter IDs of purchased tracks")
+	}
+
+	for _, arg := range ids {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			logs.FATAL.Fatalf("invalid ID %q\n", arg)
+		}
+		if !index.MarkPurchased(id) {
+			logs.ERROR.Printf("there is no track with ID %v in index\n", id)
+			continue
+		}
+		logs.FEEDBACK.Printf("Track %v is marked as purchased\n", id)
+	}
+
+	if err := index.Save(); err != nil {
+		logs.FATAL.Fatalln("couldn't save the index of downloaded tracks:", err)
+	}
+}
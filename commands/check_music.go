@@ -0,0 +1,100 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/library"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkMusicCommand = &cobra.Command{
+		Use:   "check-music",
+		Short: "Find downloaded tracks, which are not in iTunes playlist.",
+		Long:  "This command compares tracks in iTunes playlist with downloaded ones and offers to add missing tracks to playlist (e.g. if iTunes was closed while downloading).",
+		Run:   checkMusic,
+	}
+)
+
+// importMissing is the flag, which adds missing tracks without asking.
+var importMissing bool
+
+func init() {
+	addItunesPlaylistFlag(checkMusicCommand)
+	checkMusicCommand.Flags().BoolVar(&importMissing, "import", false, "add missing tracks to playlist without asking")
+}
+
+func checkMusic(cmd *cobra.Command, args []string) {
+	if !library.Supported() {
+		logs.FATAL.Fatalln("iTunes isn't supported on this OS")
+	}
+	initializeConfig(cmd)
+
+	playlist := config.Get("itunesPlaylist")
+	if playlist == "" {
+		logs.FATAL.Fatalln("you didn't set an iTunes playlist. Use flag '-i' or set itunesPlaylist in config file.")
+	}
+
+	playlistTracks, err := library.Default.TracksOfPlaylist(playlist)
+	if err != nil {
+		logs.FATAL.Fatalln("couldn't get tracks of playlist:", err)
+	}
+	inPlaylist := make(map[string]bool, 2*len(playlistTracks))
+	for _, t := range playlistTracks {
+		inPlaylist[strings.ToLower(t.Artist+"\t"+t.Title)] = true
+		if t.Location != "" {
+			inPlaylist[filepath.Clean(t.Location)] = true
+		}
+	}
+
+	var missing []index.Entry
+	for _, e := range index.All() {
+		if inPlaylist[filepath.Clean(e.Path)] || inPlaylist[strings.ToLower(e.Artist+"\t"+e.Title)] {
+			continue
+		}
+		if _, err := os.Stat(e.Path); err != nil {
+			logs.INFO.Printf("skipping %q: file doesn't exist\n", e.Path)
+			continue
+		}
+		missing = append(missing, e)
+	}
+
+	if len(missing) == 0 {
+		logs.FEEDBACK.Printf("All downloaded tracks are in playlist %q\n", playlist)
+		return
+	}
+
+	logs.FEEDBACK.Printf("%v downloaded track(s) are not in playlist %q:\n", len(missing), playlist)
+	for _, e := range missing {
+		logs.FEEDBACK.Println(" ", e.Fullname())
+	}
+
+	if !importMissing {
+		logs.FEEDBACK.Print("\nAdd them to playlist now? [y/N]: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return
+		}
+	}
+
+	for _, e := range missing {
+		logs.FEEDBACK.Printf("Adding %q to iTunes ... ", e.Fullname())
+		if _, err := downloader.AddToItunes(e.Path, playlist, library.TrackProperties{}); err != nil {
+			logs.FEEDBACK.Println("✘")
+			logs.ERROR.Printf("couldn't add %q to playlist: %v\n", e.Fullname(), err)
+			continue
+		}
+		logs.FEEDBACK.Println("✔︎")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/tempdir"
+	"github.com/bogem/nehm/util"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cleanCommand = &cobra.Command{
+		Use:   "clean",
+		Short: "Remove temporary and partially downloaded files left after crashes.",
+		Long:  "This command removes temporary folders and partial downloads of nehm in tmpDir and .part and .nehm-tmp files in dlFolder, which are older than cleanAfter (24h by default). nehm also does it on start.",
+		Run:   clean,
+	}
+)
+
+var cleanAfter time.Duration
+
+func init() {
+	addDlFolderFlag(cleanCommand)
+	cleanCommand.Flags().DurationVar(&cleanAfter, "older-than", 0, "remove files older than this (e.g. 1h)")
+}
+
+func clean(cmd *cobra.Command, args []string) {
+	if cmd.Flags().Changed("older-than") {
+		config.Set("cleanAfter", cleanAfter.String())
+	}
+	initializeConfig(cmd)
+	cleanStaleFiles(config.Get("dlFolder"), true)
+}
+
+// cleanStaleFiles removes intermediate files left after crashed runs.
+// If verbose is false, it reports only if something was removed.
+func cleanStaleFiles(dlFolder string, verbose bool) {
+	olderThan, err := tempdir.StaleAfter()
+	if err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+
+	removed, reclaimed, err := tempdir.CleanStale(dlFolder, olderThan)
+	for _, path := range removed {
+		logs.INFO.Println("removed", path)
+	}
+	if err != nil {
+		logs.WARN.Println("couldn't remove stale temporary files:", err)
+	}
+	if len(removed) > 0 {
+		logs.FEEDBACK.Printf("Removed %v stale temporary file(s), reclaimed %v\n", len(removed), util.SizeString(reclaimed))
+	} else if verbose {
+		logs.FEEDBACK.Println("There are no stale temporary files")
+	}
+}
@@ -6,12 +6,27 @@ package commands
 
 import (
 	"os"
-	"runtime"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 
+	"github.com/bogem/nehm/api"
+	"github.com/bogem/nehm/apihealth"
 	"github.com/bogem/nehm/applescript"
+	"github.com/bogem/nehm/capability"
 	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/coord"
+	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/httpclient"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/library"
 	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/normalize"
+	"github.com/bogem/nehm/notify"
+	"github.com/bogem/nehm/progress"
+	"github.com/bogem/nehm/tempdir"
+	"github.com/bogem/nehm/track"
 	"github.com/bogem/nehm/util"
 	"github.com/spf13/cobra"
 )
@@ -20,25 +35,117 @@ var rootCmd = listCommand
 
 // Variables used in flags.
 var (
-	limit                               uint
+	limit, parallel                     uint
 	dlFolder, itunesPlaylist, permalink string
-	verbose                             bool
+	playlistName, quality, label        string
+	account, ipVersion, playlistFile    string
+	editMetadata, failFast, verbose     bool
+	dryRun, force                       bool
 )
 
 func Execute() {
+	downloader.Version = version
+	rootCmd.AddCommand(apihealthCommand)
+	rootCmd.AddCommand(artworkCommand)
+	rootCmd.AddCommand(buylistCommand)
+	rootCmd.AddCommand(checkMusicCommand)
+	rootCmd.AddCommand(cleanCommand)
+	rootCmd.AddCommand(diffCommand)
+	rootCmd.AddCommand(discoverCommand)
+	rootCmd.AddCommand(doctorCommand)
+	rootCmd.AddCommand(dupesCommand)
 	rootCmd.AddCommand(getCommand)
+	rootCmd.AddCommand(historyCommand)
+	rootCmd.AddCommand(importPendingCommand)
+	rootCmd.AddCommand(protectCommand)
+	rootCmd.AddCommand(rescanCommand)
+	rootCmd.AddCommand(retagCommand)
+	rootCmd.AddCommand(retryCommand)
 	rootCmd.AddCommand(searchCommand)
+	rootCmd.AddCommand(siteCommand)
 	rootCmd.AddCommand(syncCommand)
+	rootCmd.AddCommand(verifyCommand)
 	rootCmd.AddCommand(versionCommand)
+	rootCmd.AddCommand(watchCommand)
+	rootCmd.AddCommand(whoamiCommand)
+	cleanupOnInterrupt()
 	rootCmd.Execute()
+	saveAPIHealth()
+	tempdir.Cleanup()
+}
+
+// exit exits nehm with code after cleanup.
+func exit(code int) {
+	saveAPIHealth()
+	tempdir.Cleanup()
+	os.Exit(code)
+}
+
+// downloadAll downloads tracks with dl. If downloading was aborted,
+// nehm exits with the code of reason after cleanup.
+func downloadAll(dl *downloader.Downloader, tracks []track.Track) {
+	switch dl.DownloadAll(tracks) {
+	case downloader.ErrAuthAborted:
+		exit(downloader.ExitAuth)
+	case downloader.ErrFailFast:
+		exit(1)
+	}
+}
+
+func saveAPIHealth() {
+	if err := apihealth.Save(); err != nil {
+		logs.WARN.Println(err)
+	}
+}
+
+// cleanupOnInterrupt removes temporary files, if nehm is interrupted.
+func cleanupOnInterrupt() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		exit(1)
+	}()
 }
 
 func addDlFolderFlag(cmd *cobra.Command) {
 	cmd.Flags().StringVarP(&dlFolder, "dlFolder", "f", "", "filesystem path to download folder")
 }
 
+func addDryRunFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "only show, which tracks would be downloaded and where")
+}
+
+func addEditFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&editMetadata, "edit", false, "edit artist, title and album of each track before tagging")
+}
+
+func addFailFastFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&failFast, "fail-fast", false, "abort downloading on first error")
+}
+
+func addParallelFlag(cmd *cobra.Command) {
+	cmd.Flags().UintVar(&parallel, "parallel", 1, "count of tracks downloaded at the same time (0 to tune it by network)")
+}
+
+func addLabelFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&label, "label", "", "label of downloaded tracks, e.g. \"festival prep\"")
+}
+
+func addPlaylistFileFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&playlistFile, "playlist-file", "", "name of M3U8 playlist in download folder, to which downloaded tracks are appended")
+}
+
+func addQualityFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&quality, "quality", "", "quality of streams: low (Opus), standard (MP3) or high (if available)")
+}
+
+func addForceFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&force, "force", false, "download tracks again, even if they're already downloaded")
+}
+
 func addItunesPlaylistFlag(cmd *cobra.Command) {
-	if runtime.GOOS == "darwin" {
+	if library.Supported() {
 		cmd.Flags().StringVarP(&itunesPlaylist, "itunesPlaylist", "i", "", "name of iTunes playlist")
 	}
 }
@@ -47,6 +154,10 @@ func addLimitFlag(cmd *cobra.Command) {
 	cmd.Flags().UintVarP(&limit, "limit", "l", 9, "count of tracks on each page")
 }
 
+func addPlaylistFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&playlistName, "playlist", "", "name of playlist in playlists section of config or URL of playlist")
+}
+
 func addPermalinkFlag(cmd *cobra.Command) {
 	cmd.Flags().StringVarP(&permalink, "permalink", "p", "", "user's permalink")
 }
@@ -57,21 +168,83 @@ func initializeConfig(cmd *cobra.Command) {
 	readInConfig()
 
 	flags := cmd.Flags()
+	if flags.Changed("account") {
+		config.Set("account", account)
+	}
+	if name := config.Get("account"); name != "" {
+		if err := config.UseAccount(name); err != nil {
+			logs.FATAL.Fatalln(err)
+		}
+	}
+	if flags.Changed("playlist") {
+		if err := config.UsePlaylist(playlistName); err != nil {
+			logs.FATAL.Fatalln(err)
+		}
+	}
+	if flags.Changed("ip-version") {
+		config.Set("ipVersion", ipVersion)
+	}
+	configureHTTPClient()
+	configureNormalization()
+	configureUploaderAliases()
+	configureArtworkSize()
+	configureMusicApp()
+	configureCapabilities()
+	configureFilenameTemplate()
+	openProgressFile()
+	loadIndex()
+
+	if flags.Lookup("dry-run") != nil {
+		initializeBoolFlag(cmd, "dry-run", "dryRun")
+	}
 	if flags.Lookup("dlFolder") != nil {
 		initializeDlFolder(cmd)
 	}
-	if flags.Lookup("permalink") != nil {
+	// clean command reports the result of cleaning itself.
+	// Nothing is removed in dry-run mode.
+	if cmd.Name() != "clean" && !config.GetBool("dryRun") {
+		cleanStaleFiles(config.Get("dlFolder"), false)
+	}
+	// Playlists are synchronised without user's profile.
+	if flags.Lookup("permalink") != nil && config.PlaylistName() == "" {
 		initializePermalink(cmd)
 	}
 	if flags.Lookup("itunesPlaylist") != nil {
 		initializeItunesPlaylist(cmd)
 	}
+	if flags.Lookup("edit") != nil {
+		initializeBoolFlag(cmd, "edit", "editMetadata")
+	}
+	if flags.Lookup("fail-fast") != nil {
+		initializeBoolFlag(cmd, "fail-fast", "failFast")
+	}
+	if flags.Lookup("force") != nil {
+		initializeBoolFlag(cmd, "force", "redownload")
+	}
+	if flags.Changed("parallel") && parallel == 0 {
+		config.Set("downloadWorkers", "auto")
+	} else if flags.Changed("parallel") {
+		config.Set("downloadWorkers", strconv.FormatUint(uint64(parallel), 10))
+	}
+	if flags.Changed("quality") {
+		config.Set("quality", quality)
+	}
+	if flags.Changed("label") {
+		config.Set("label", label)
+	}
+	if flags.Changed("playlist-file") {
+		config.Set("playlistFile", playlistFile)
+	}
 }
 
 func readInConfig() {
 	err := config.ReadInConfig()
 	if err == config.ErrNotExist {
-		logs.WARN.Println("there is no config file. Read README to configure nehm")
+		// In containers nehm is usually configured with flags
+		// and environment variables only.
+		if !util.InContainer() {
+			logs.WARN.Println("there is no config file. Read README to configure nehm")
+		}
 		return
 	}
 	if err != nil {
@@ -79,8 +252,97 @@ func readInConfig() {
 	}
 }
 
+func configureHTTPClient() {
+	if err := httpclient.Configure(); err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+
+	if value := config.Get("rateLimit"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			logs.FATAL.Fatalf("invalid rateLimit %q: should be positive count of requests per second\n", value)
+		}
+		coord.Rate = rate
+	}
+	httpclient.Throttle = coord.Take
+}
+
+func configureNormalization() {
+	if rules := normalize.RulesFromConfig(); rules.Enabled() {
+		track.Normalize = rules.Normalize
+	}
+}
+
+func configureUploaderAliases() {
+	track.UploaderAliases = config.GetStringMap("uploaderAliases")
+}
+
+func configureArtworkSize() {
+	size := config.Get("artworkSize")
+	if size == "" {
+		return
+	}
+	if !track.ValidArtworkSize(size) {
+		logs.FATAL.Fatalf("invalid artworkSize %q. Use one of: %v.\n", size, strings.Join(track.ArtworkSizes, ", "))
+	}
+	track.ArtworkSize = size
+}
+
+// configureCapabilities disables features, which external programs
+// aren't found for. Trims are disabled by downloader and adding to iTunes
+// by initializeItunesPlaylist.
+func configureCapabilities() {
+	if config.GetBool("notifyDesktop") {
+		if program := notify.DesktopProgram(); program != "" && !capability.Available(program) {
+			logs.WARN.Printf("%v isn't found, so desktop notifications are disabled. Run 'nehm doctor' for details.\n", program)
+			config.Set("notifyDesktop", "false")
+		}
+	}
+}
+
+func configureMusicApp() {
+	app := config.Get("musicApp")
+	if app == "" {
+		return
+	}
+	if app != applescript.ITunes && app != applescript.Music {
+		logs.FATAL.Fatalf("invalid musicApp %q. Use %q or %q.\n", app, applescript.ITunes, applescript.Music)
+	}
+	applescript.App = app
+}
+
+func configureFilenameTemplate() {
+	text := config.Get("fileNameTemplate")
+	if text == "" {
+		return
+	}
+	if err := track.SetFilenameTemplate(text); err != nil {
+		logs.FATAL.Fatalln("invalid fileNameTemplate:", err)
+	}
+}
+
+func openProgressFile() {
+	path := config.Get("progressFile")
+	if path == "" {
+		return
+	}
+	if err := progress.Open(util.SanitizePath(path)); err != nil {
+		logs.WARN.Println("couldn't open progress file:", err)
+	}
+}
+
+func loadIndex() {
+	if err := index.Load(); err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+}
+
+// containerDataDir is the conventional volume for downloads in containers.
+const containerDataDir = "/data"
+
 // initializeDlFolder initializes dlFolder value. If there is no dlFolder
-// set up, then dlFolder is set to HOME env variable.
+// set up, then dlFolder is set to HOME env variable or, if nehm is running
+// in container, to /data.
 func initializeDlFolder(cmd *cobra.Command) {
 	var df string
 
@@ -90,6 +352,12 @@ func initializeDlFolder(cmd *cobra.Command) {
 		df = config.Get("dlFolder")
 	}
 
+	if df == "" && util.InContainer() {
+		if fi, err := os.Stat(containerDataDir); err == nil && fi.IsDir() {
+			df = containerDataDir
+		}
+	}
+
 	if df == "" {
 		logs.WARN.Println("you didn't set a download folder. Tracks will be downloaded to your home directory.")
 		df = os.Getenv("HOME")
@@ -99,7 +367,8 @@ func initializeDlFolder(cmd *cobra.Command) {
 }
 
 // initializePermalink initializes permalink value. If there is no permalink
-// set up, then program is terminating.
+// set up and nehm isn't authenticated with oauthToken,
+// then program is terminating.
 func initializePermalink(cmd *cobra.Command) {
 	var p string
 
@@ -109,22 +378,41 @@ func initializePermalink(cmd *cobra.Command) {
 		p = config.Get("permalink")
 	}
 
-	if p == "" {
+	if p == "" && config.Get("oauthToken") == "" {
 		logs.FATAL.Fatalln("you didn't set a permalink. Use flag '-p' or set permalink in config file.\nTo know, what is permalink, read FAQ.")
 	} else {
 		config.Set("permalink", p)
 	}
 }
 
+// userID returns the ID of user with permalink. If permalink is not set,
+// it returns the ID of user authenticated with oauthToken.
+func userID() string {
+	if p := config.Get("permalink"); p != "" {
+		return api.UID(p)
+	}
+
+	me, err := api.Me(config.Get("oauthToken"))
+	if err != nil {
+		logs.FATAL.Fatalln("couldn't get authenticated user:", err)
+	}
+	return strconv.Itoa(me.ID)
+}
+
 // initializeItunesPlaylist initializes itunesPlaylist value. If there is no
 // itunesPlaylist set up, then itunesPlaylist set up to blank string. Blank
 // string is the sign, what tracks should not to be added to iTunes.
 //
-// initializeItunesPlaylist sets blank string to config, if OS is not darwin.
+// initializeItunesPlaylist sets blank string to config, if there is
+// no iTunes on this OS.
 func initializeItunesPlaylist(cmd *cobra.Command) {
 	var playlist string
 
-	if runtime.GOOS == "darwin" {
+	if !library.Supported() {
+		if config.Get("itunesPlaylist") != "" {
+			logs.WARN.Println("iTunes isn't supported on this OS. Tracks won't be added to iTunes.")
+		}
+	} else {
 		if cmd.Flags().Changed("itunesPlaylist") {
 			playlist = itunesPlaylist
 		} else {
@@ -133,16 +421,35 @@ func initializeItunesPlaylist(cmd *cobra.Command) {
 
 		if playlist == "" {
 			logs.WARN.Println("you didn't set an iTunes playlist. Tracks won't be added to iTunes.")
+		} else if !capability.Available(library.Program) {
+			logs.WARN.Printf("%v isn't found. Tracks won't be added to iTunes. Run 'nehm doctor' for details.\n", library.Program)
+			playlist = ""
 		} else {
-			playlistsList, err := applescript.ListOfPlaylists()
+			playlists, err := library.Default.Playlists()
 			if err != nil {
 				logs.FATAL.Fatalln("couldn't get list of playlists:", err)
 			}
-			if !strings.Contains(playlistsList, playlist) {
-				logs.FATAL.Fatalf("playlist %q doesn't exist. Please enter correct name.\n", playlist)
+			exists := false
+			for _, name := range playlists {
+				if name == playlist {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				logs.INFO.Printf("playlist %q doesn't exist, it will be created\n", playlist)
 			}
 		}
 	}
 
 	config.Set("itunesPlaylist", playlist)
 }
+
+// initializeBoolFlag sets the value of boolean flag to config key,
+// if flag was changed. Otherwise the value from config file is used.
+func initializeBoolFlag(cmd *cobra.Command, flag, key string) {
+	if cmd.Flags().Changed(flag) {
+		value, _ := cmd.Flags().GetBool(flag)
+		config.Set(key, strconv.FormatBool(value))
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/bogem/nehm/api"
+	"github.com/bogem/nehm/color"
+	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/track"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffCommand = &cobra.Command{
+		Use:   "diff",
+		Short: "Show the difference between your favorites and downloaded tracks.",
+		Long:  "This command compares downloaded tracks with your favorites on SoundCloud and shows, what sync would download, without downloading anything.",
+		Run:   showDiff,
+	}
+)
+
+func init() {
+	addDlFolderFlag(diffCommand)
+	addPermalinkFlag(diffCommand)
+}
+
+func showDiff(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	logs.FEEDBACK.Println("Getting favorites")
+	favs, err := api.AllFavorites(userID())
+	if err != nil {
+		logs.FATAL.Fatalln("can't get tracks from SoundCloud", err)
+	}
+
+	var additions, changes []string
+	inFavorites := make(map[int]bool, len(favs))
+	missing := nonexistentTracks(downloader.NewConfiguredDownloader(), favs)
+	isMissing := make(map[int]bool, len(missing))
+	for _, t := range missing {
+		isMissing[t.ID()] = true
+	}
+
+	for _, t := range favs {
+		inFavorites[t.ID()] = true
+
+		e, exists := index.Get(t.ID())
+		if !exists {
+			if isMissing[t.ID()] {
+				additions = append(additions, t.Fullname())
+			}
+			continue
+		}
+		if change := entryChange(e, t); change != "" {
+			changes = append(changes, change)
+		}
+	}
+
+	var removals []string
+	for _, e := range index.All() {
+		if !inFavorites[e.ID] {
+			removals = append(removals, e.Fullname())
+		}
+	}
+
+	if len(additions)+len(removals)+len(changes) == 0 {
+		logs.FEEDBACK.Println("Folder is already synchronised with favorites")
+		return
+	}
+
+	for _, a := range additions {
+		logs.FEEDBACK.Println(color.GreenString("+ " + a))
+	}
+	for _, r := range removals {
+		logs.FEEDBACK.Println(color.RedString("- " + r))
+	}
+	for _, c := range changes {
+		logs.FEEDBACK.Println(color.YellowString("~ " + c))
+	}
+	logs.FEEDBACK.Printf("\n%v to download, %v not in favorites anymore, %v changed\n",
+		len(additions), len(removals), len(changes))
+}
+
+// entryChange returns description of metadata change between
+// downloaded track e and its current state t on SoundCloud.
+// If nothing was changed, it returns blank string.
+func entryChange(e index.Entry, t track.Track) string {
+	if e.Artist == t.Artist() && e.Title == t.Title() {
+		return ""
+	}
+	return e.Fullname() + " → " + t.Fullname()
+}
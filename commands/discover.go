@@ -0,0 +1,137 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"os"
+	"sort"
+
+	"github.com/bogem/nehm/api"
+	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/menu"
+	"github.com/bogem/nehm/track"
+	"github.com/spf13/cobra"
+)
+
+var (
+	discoverCommand = &cobra.Command{
+		Use:   "discover",
+		Short: "Find tracks similar to your recent likes, show them, download and set tags.",
+		Long:  "This command samples your recent likes, gets related tracks for each of them, ranks them by how many of your likes they're related to and shows the best ones, which you haven't downloaded yet.",
+		Run:   discover,
+	}
+
+	discoverCount, discoverSample uint
+)
+
+// relatedLimit is the count of related tracks requested for each like.
+const relatedLimit = 50
+
+func init() {
+	addDlFolderFlag(discoverCommand)
+	addDryRunFlag(discoverCommand)
+	addEditFlag(discoverCommand)
+	addFailFastFlag(discoverCommand)
+	addForceFlag(discoverCommand)
+	addParallelFlag(discoverCommand)
+	addQualityFlag(discoverCommand)
+	addItunesPlaylistFlag(discoverCommand)
+	addLabelFlag(discoverCommand)
+	addPlaylistFileFlag(discoverCommand)
+	addLimitFlag(discoverCommand)
+	addPermalinkFlag(discoverCommand)
+	discoverCommand.Flags().UintVarP(&discoverCount, "count", "c", 30, "count of tracks to discover")
+	discoverCommand.Flags().UintVar(&discoverSample, "sample", 10, "count of recent likes to sample")
+}
+
+// candidate is the track found by discover.
+type candidate struct {
+	track.Track
+
+	// overlap is the count of likes, which candidate is related to.
+	overlap int
+}
+
+func discover(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	logs.FEEDBACK.Println("Getting ID of user")
+	uid := userID()
+
+	logs.FEEDBACK.Println("Getting recent likes")
+	likes, err := api.Favorites(discoverSample, uid)
+	if err != nil {
+		logs.FATAL.Fatalln("can't get tracks from SoundCloud:", err)
+	}
+
+	isLiked := make(map[int]bool, len(likes))
+	for _, t := range likes {
+		isLiked[t.ID()] = true
+	}
+
+	logs.FEEDBACK.Println("Getting related tracks")
+	candidates := make(map[int]*candidate)
+	for _, like := range likes {
+		related, err := api.RelatedTracks(like.ID(), relatedLimit)
+		if err != nil {
+			logs.WARN.Printf("couldn't get tracks related to %q: %v\n", like.Fullname(), err)
+			continue
+		}
+		for _, t := range related {
+			if isLiked[t.ID()] {
+				continue
+			}
+			if c, exists := candidates[t.ID()]; exists {
+				c.overlap++
+			} else {
+				candidates[t.ID()] = &candidate{Track: t, overlap: 1}
+			}
+		}
+	}
+
+	tracks := rankCandidates(downloader.NewConfiguredDownloader(), candidates, int(discoverCount))
+	if len(tracks) == 0 {
+		logs.FEEDBACK.Println("There are no new tracks to show")
+		exit(0)
+	}
+
+	tm := menu.NewTracksMenuFromTracks(tracks, limit)
+	downloadTracks := tm.Show()
+
+	downloadAll(downloader.NewConfiguredDownloader(), downloadTracks)
+}
+
+// rankCandidates returns up to count candidates, which aren't downloaded
+// yet, sorted by overlap and then by playback count.
+func rankCandidates(dl *downloader.Downloader, candidates map[int]*candidate, count int) []track.Track {
+	list := make([]*candidate, 0, len(candidates))
+	for _, c := range candidates {
+		if _, downloaded := index.Get(c.ID()); downloaded {
+			continue
+		}
+		if _, err := os.Stat(dl.TrackPath(c.Track)); err == nil {
+			continue
+		}
+		list = append(list, c)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].overlap != list[j].overlap {
+			return list[i].overlap > list[j].overlap
+		}
+		return list[i].PlaybackCount() > list[j].PlaybackCount()
+	})
+
+	if len(list) > count {
+		list = list[:count]
+	}
+	tracks := make([]track.Track, 0, len(list))
+	for _, c := range list {
+		tracks = append(tracks, c.Track)
+	}
+	return tracks
+}
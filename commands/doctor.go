@@ -0,0 +1,36 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/bogem/nehm/capability"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	doctorCommand = &cobra.Command{
+		Use:   "doctor",
+		Short: "Check external programs, which some features need.",
+		Long:  "This command looks for optional external programs (e.g. ffmpeg for trims) and shows, which features are available. Results are cached for a day and features without programs are disabled at startup.",
+		Run:   doctor,
+	}
+)
+
+func doctor(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	for _, r := range capability.Refresh() {
+		if !r.Found() {
+			logs.FEEDBACK.Printf("✘ %v isn't found, disabled: %v\n", r.Program, r.Features)
+			continue
+		}
+		line := "✔︎ " + r.Name + " (" + r.Path
+		if r.Version != "" {
+			line += ", " + r.Version
+		}
+		logs.FEEDBACK.Println(line + "): " + r.Features)
+	}
+}
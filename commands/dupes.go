@@ -0,0 +1,131 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bogem/nehm/audit"
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/dupes"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/util"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dupesCommand = &cobra.Command{
+		Use:   "dupes",
+		Short: "Find and remove duplicates of downloaded tracks.",
+		Long:  "This command finds tracks with the same artist and title (ignoring case, spaces and punctuation) and similar duration and asks, which one to keep. With --auto the track with the highest bitrate is kept.",
+		Run:   findDupes,
+	}
+)
+
+var (
+	autoResolve    bool
+	scanFolder     bool
+	dupesTolerance time.Duration
+)
+
+func init() {
+	addDlFolderFlag(dupesCommand)
+	dupesCommand.Flags().BoolVar(&autoResolve, "auto", false, "keep track with the highest bitrate without asking")
+	dupesCommand.Flags().BoolVar(&scanFolder, "scan", false, "scan download folder for tracks, which are not in index")
+	dupesCommand.Flags().DurationVar(&dupesTolerance, "tolerance", 3*time.Second, "maximal difference of durations of duplicates")
+}
+
+func findDupes(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	var folder string
+	if scanFolder {
+		folder = config.Get("dlFolder")
+	}
+	files, err := dupes.Files(folder)
+	if err != nil {
+		logs.FATAL.Fatalln("couldn't scan download folder:", err)
+	}
+
+	groups := dupes.Find(files, dupesTolerance)
+	if len(groups) == 0 {
+		logs.FEEDBACK.Println("There are no duplicates")
+		return
+	}
+	if config.GetBool("archive") {
+		logs.WARN.Println("duplicates can't be removed in archive mode")
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	var removed int
+	for _, g := range groups {
+		logs.FEEDBACK.Println()
+		for i, f := range g {
+			logs.FEEDBACK.Printf("%v. %v (%v kbit/s, %v, %v)\n   %v\n", i+1, f.Fullname(),
+				f.Bitrate, f.Duration.Truncate(time.Second), util.SizeString(f.Size), f.Path)
+		}
+		if config.GetBool("archive") {
+			continue
+		}
+
+		keep := g.Best()
+		if !autoResolve {
+			logs.FEEDBACK.Printf("Which one to keep? [1-%v, Enter to skip]: ", len(g))
+			answer, _ := stdin.ReadString('\n')
+			n, err := strconv.Atoi(strings.TrimSpace(answer))
+			if err != nil || n < 1 || n > len(g) {
+				continue
+			}
+			keep = n - 1
+		}
+
+		for i, f := range g {
+			if i == keep {
+				continue
+			}
+			if err := removeDupe(f, g[keep]); err != nil {
+				logs.ERROR.Printf("couldn't remove %q: %v\n", f.Path, err)
+				continue
+			}
+			logs.FEEDBACK.Println("Removed", f.Path)
+			removed++
+		}
+	}
+
+	if err := index.Save(); err != nil {
+		logs.ERROR.Println("couldn't save the index of downloaded tracks:", err)
+	}
+	logs.FEEDBACK.Printf("\n%v duplicate group(s) found, %v file(s) removed\n", len(groups), removed)
+}
+
+// removeDupe removes file f, which is a duplicate of kept file.
+// If f is in index, its entry is removed, unless it's the same
+// track as kept one. Then entry is moved to the path of kept.
+func removeDupe(f, kept dupes.File) error {
+	if err := os.Remove(f.Path); err != nil {
+		return err
+	}
+	audit.Log(audit.Remove, f.Path, "duplicate of "+kept.Path)
+
+	if f.ID == 0 {
+		return nil
+	}
+	e, exists := index.Get(f.ID)
+	if !exists || e.Path != f.Path {
+		return nil
+	}
+	if kept.ID == f.ID || kept.ID == 0 {
+		e.Path = kept.Path
+		index.Add(e)
+		return nil
+	}
+	index.Remove(f.ID)
+	return nil
+}
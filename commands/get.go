@@ -11,8 +11,11 @@ import (
 	"github.com/bogem/nehm/api"
 	"github.com/bogem/nehm/config"
 	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/httpclient"
 	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/menu"
 	"github.com/bogem/nehm/track"
+	"github.com/bogem/nehm/webpage"
 	"github.com/spf13/cobra"
 )
 
@@ -20,6 +23,7 @@ var (
 	getCommand = &cobra.Command{
 		Use:     "get [number or url]",
 		Short:   "Download either inputed count of likes or track from entered url, set tags (and add to your iTunes library).",
+		Long:    "If url is not a SoundCloud one, nehm looks for SoundCloud players embedded in this page and offers their tracks for download.",
 		Aliases: []string{"g"},
 		Run:     getTracks,
 	}
@@ -27,7 +31,16 @@ var (
 
 func init() {
 	addDlFolderFlag(getCommand)
+	addDryRunFlag(getCommand)
+	addEditFlag(getCommand)
+	addFailFastFlag(getCommand)
+	addForceFlag(getCommand)
+	addParallelFlag(getCommand)
+	addQualityFlag(getCommand)
 	addItunesPlaylistFlag(getCommand)
+	addLabelFlag(getCommand)
+	addPlaylistFileFlag(getCommand)
+	addLimitFlag(getCommand)
 	addPermalinkFlag(getCommand)
 }
 
@@ -44,6 +57,8 @@ func getTracks(cmd *cobra.Command, args []string) {
 	var downloadTracks []track.Track
 	if isSoundCloudURL(arg) {
 		downloadTracks = getTrackFromURL(arg)
+	} else if isWebURL(arg) {
+		downloadTracks = getTracksFromPage(arg)
 	} else if num, err := strconv.Atoi(arg); err == nil {
 		downloadTracks, err = getLastTracks(uint(num))
 		if err != nil {
@@ -53,18 +68,81 @@ func getTracks(cmd *cobra.Command, args []string) {
 		logs.FATAL.Fatalln("you've entered invalid argument. Run 'nehm get --help' for usage.", nil)
 	}
 
-	downloader.NewConfiguredDownloader().DownloadAll(downloadTracks)
+	dl := downloader.NewConfiguredDownloader()
+	if fromPlaylist {
+		dl.UsePlaylistPositions(downloadTracks)
+	}
+	downloadAll(dl, downloadTracks)
 }
 
+// fromPlaylist is set, if tracks are downloaded from the URL of playlist.
+var fromPlaylist bool
+
 func getLastTracks(count uint) ([]track.Track, error) {
 	logs.FEEDBACK.Println("Getting ID of user")
-	return api.Favorites(count, api.UID(config.Get("permalink")))
+	return api.Favorites(count, userID())
 }
 
 func isSoundCloudURL(url string) bool {
 	return strings.Contains(url, "soundcloud.com")
 }
 
+// getTrackFromURL returns tracks from url. If url is the URL of playlist,
+// its title is used as album, unless album is set in config.
 func getTrackFromURL(url string) []track.Track {
-	return api.TrackFromURL(url)
+	if !strings.Contains(url, "/sets/") {
+		return api.TrackFromURL(url)
+	}
+
+	title, tracks, err := api.ResolvePlaylist(url)
+	if err != nil {
+		logs.FATAL.Fatalln("couldn't get tracks of playlist:", err)
+	}
+	if config.Get("album") == "" && title != "" {
+		config.Set("album", title)
+	}
+	fromPlaylist = true
+	return tracks
+}
+
+func isWebURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// getTracksFromPage returns tracks of SoundCloud players embedded
+// in page at url. If there are several tracks, they're shown in menu.
+func getTracksFromPage(url string) []track.Track {
+	logs.FEEDBACK.Println("Searching for SoundCloud players on page")
+	statusCode, page, err := httpclient.Get(nil, url)
+	if err != nil {
+		logs.FATAL.Fatalln("couldn't get page:", err)
+	}
+	if statusCode >= 400 {
+		logs.FATAL.Fatalf("couldn't get page: HTTP %v\n", statusCode)
+	}
+
+	var tracks []track.Track
+	seen := make(map[int]bool)
+	for _, embedded := range webpage.SoundCloudURLs(page) {
+		resolved, err := api.ResolveTracks(embedded)
+		if err != nil {
+			logs.WARN.Printf("couldn't resolve %q: %v\n", embedded, err)
+			continue
+		}
+		for _, t := range resolved {
+			if !seen[t.ID()] {
+				seen[t.ID()] = true
+				tracks = append(tracks, t)
+			}
+		}
+	}
+
+	switch len(tracks) {
+	case 0:
+		logs.FATAL.Fatalln("there are no SoundCloud tracks on this page")
+	case 1:
+		return tracks
+	}
+	logs.FEEDBACK.Printf("%v track(s) found\n", len(tracks))
+	return menu.NewTracksMenuFromTracks(tracks, limit).Show()
 }
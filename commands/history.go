@@ -0,0 +1,43 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/bogem/nehm/audit"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyCommand = &cobra.Command{
+		Use:   "history",
+		Short: "Show the history of overwritten and renamed files.",
+		Run:   showHistory,
+	}
+)
+
+func showHistory(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	records, err := audit.Records()
+	if err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+	if len(records) == 0 {
+		logs.FEEDBACK.Println("There are no destructive operations in history")
+		return
+	}
+
+	for _, r := range records {
+		line := r.Time.Format("2006-01-02 15:04:05") + "  " + r.Operation + "  " + r.Path
+		if r.Reason != "" {
+			line += " (" + r.Reason + ")"
+		}
+		if r.Label != "" {
+			line += " [" + r.Label + "]"
+		}
+		logs.FEEDBACK.Println(line)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/library"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importPendingCommand = &cobra.Command{
+		Use:   "import-pending",
+		Short: "Add tracks to iTunes, which weren't added because it was busy.",
+		Run:   importPending,
+	}
+)
+
+func importPending(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	if !library.Supported() {
+		logs.FATAL.Fatalln("iTunes isn't supported on this OS")
+	}
+
+	imported, remaining, err := downloader.ImportPending()
+	if err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+	logs.FEEDBACK.Printf("%v track(s) added to iTunes, %v track(s) are still pending\n", imported, remaining)
+}
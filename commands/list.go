@@ -6,8 +6,8 @@ package commands
 
 import (
 	"github.com/bogem/nehm/api"
-	"github.com/bogem/nehm/config"
 	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/index"
 	"github.com/bogem/nehm/logs"
 	"github.com/bogem/nehm/menu"
 	"github.com/spf13/cobra"
@@ -33,20 +33,47 @@ func activateVerboseOutput(cmd *cobra.Command, args []string) {
 
 func init() {
 	listCommand.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	listCommand.PersistentFlags().StringVar(&account, "account", "", "name of account from accounts section of config")
+	listCommand.PersistentFlags().StringVar(&ipVersion, "ip-version", "", "use only IPv4 (4) or IPv6 (6) to connect")
 	addDlFolderFlag(listCommand)
+	addDryRunFlag(listCommand)
+	addEditFlag(listCommand)
+	addFailFastFlag(listCommand)
+	addForceFlag(listCommand)
+	addParallelFlag(listCommand)
+	addQualityFlag(listCommand)
 	addItunesPlaylistFlag(listCommand)
 	addLimitFlag(listCommand)
+	listCommand.Flags().StringVar(&label, "label", "", "show downloaded tracks with label instead of likes")
 	addPermalinkFlag(listCommand)
 }
 
 func showListOfTracks(cmd *cobra.Command, args []string) {
 	initializeConfig(cmd)
 
+	if label != "" {
+		showLabeledTracks(label)
+		return
+	}
+
 	logs.FEEDBACK.Println("Getting ID of user")
-	uid := api.UID(config.Get("permalink"))
+	uid := userID()
 
 	tm := menu.NewTracksMenu(api.FormFavoritesURL(limit, uid))
 	downloadTracks := tm.Show()
 
-	downloader.NewConfiguredDownloader().DownloadAll(downloadTracks)
+	downloadAll(downloader.NewConfiguredDownloader(), downloadTracks)
+}
+
+// showLabeledTracks prints downloaded tracks with label.
+func showLabeledTracks(label string) {
+	entries := index.WithLabel(label)
+	if len(entries) == 0 {
+		logs.FEEDBACK.Printf("There are no tracks with label %q\n", label)
+		return
+	}
+	for _, e := range entries {
+		logs.FEEDBACK.Println(e.AddedAt.Format("2006-01-02 15:04") + "  " + e.Fullname() + "  " + e.Path)
+	}
+	logs.FEEDBACK.Printf("\n%v track(s) with label %q\n", len(entries), label)
 }
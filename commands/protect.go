@@ -0,0 +1,78 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"strconv"
+
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	protectCommand = &cobra.Command{
+		Use:   "protect [ids of tracks]",
+		Short: "Mark downloaded tracks as protected or show protected tracks.",
+		Long: "This command marks downloaded tracks with entered IDs as protected. If protected track " +
+			"disappears from SoundCloud, sync sends the notification with high priority (notifyDesktop " +
+			"and notifyWebhook), because local copy is the only one then. Without IDs it shows protected tracks.",
+		Run: protect,
+	}
+)
+
+// unprotect is the flag, which removes protection from tracks.
+var unprotect bool
+
+func init() {
+	protectCommand.Flags().BoolVar(&unprotect, "remove", false, "remove protection from tracks with entered IDs")
+}
+
+func protect(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	if len(args) == 0 {
+		if unprotect {
+			logs.FATAL.Fatalln("you didn't enter IDs of tracks")
+		}
+		showProtectedTracks()
+		return
+	}
+
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			logs.FATAL.Fatalf("invalid ID %q\n", arg)
+		}
+		if !index.SetProtected(id, !unprotect) {
+			logs.ERROR.Printf("there is no track with ID %v in index\n", id)
+			continue
+		}
+		if unprotect {
+			logs.FEEDBACK.Printf("Track %v is not protected anymore\n", id)
+		} else {
+			logs.FEEDBACK.Printf("Track %v is protected\n", id)
+		}
+	}
+
+	if err := index.Save(); err != nil {
+		logs.FATAL.Fatalln("couldn't save the index of downloaded tracks:", err)
+	}
+}
+
+func showProtectedTracks() {
+	protected := index.Protected()
+	if len(protected) == 0 {
+		logs.FEEDBACK.Println("There are no protected tracks")
+		return
+	}
+	for _, e := range protected {
+		line := e.Fullname() + " (ID: " + strconv.Itoa(e.ID) + ")"
+		if u, missing := index.GetUnavailable(e.ID); missing {
+			line += " — disappeared on " + u.Since.Format("2006-01-02")
+		}
+		logs.FEEDBACK.Println(line)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rescanCommand = &cobra.Command{
+		Use:   "rescan",
+		Short: "Rebuild the index of downloaded tracks from download folder.",
+		Long:  "This command reads ID3 tags of tracks in download folder and adds them to the index of downloaded tracks, so they're not downloaded again. Tracks, whose files don't exist anymore, are removed from index.",
+		Run:   rescan,
+	}
+)
+
+func init() {
+	addDlFolderFlag(rescanCommand)
+}
+
+func rescan(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	logs.FEEDBACK.Println("Scanning", config.Get("dlFolder"))
+	r, err := downloader.Rescan(config.Get("dlFolder"))
+	if err != nil {
+		logs.FATAL.Fatalln("couldn't rescan download folder:", err)
+	}
+
+	logs.FEEDBACK.Printf("%v track(s) found, %v removed from index\n", r.Found, r.Removed)
+	if r.Untagged > 0 {
+		logs.WARN.Printf("%v file(s) have no ID of track in tag (e.g. they were downloaded by older nehm) and were skipped\n", r.Untagged)
+	}
+}
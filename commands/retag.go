@@ -0,0 +1,119 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	retagCommand = &cobra.Command{
+		Use:   "retag",
+		Short: "Change tags of downloaded tracks.",
+		Long: "This command sets tags of downloaded tracks matching all filters, e.g.:\n" +
+			"  nehm retag --set album=\"SC 2017\" --filter genre=Techno\n\n" +
+			"Tags: " + strings.Join(downloader.TagFields[:], ", ") + ".\n" +
+			"Filters: id, artist, title, album, genre. Values of filters are case-insensitive.",
+		Run: retag,
+	}
+)
+
+var retagSet, retagFilter []string
+
+func init() {
+	retagCommand.Flags().StringArrayVar(&retagSet, "set", nil, "tag to set as field=value")
+	retagCommand.Flags().StringArrayVar(&retagFilter, "filter", nil, "filter of tracks as field=value")
+}
+
+func retag(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	fields := parseAssignments(retagSet, "--set")
+	if len(fields) == 0 {
+		logs.FATAL.Fatalln("you didn't set any tag. Use flag '--set field=value'")
+	}
+	for field := range fields {
+		if !isTagField(field) {
+			logs.FATAL.Fatalf("tag %q can't be set. Available tags: %v\n", field, strings.Join(downloader.TagFields[:], ", "))
+		}
+	}
+	filters := parseAssignments(retagFilter, "--filter")
+
+	var count int
+	for _, e := range index.All() {
+		if !matchesFilters(e, filters) {
+			continue
+		}
+
+		logs.FEEDBACK.Printf("Retagging %q ... ", e.Fullname())
+		retagged, err := downloader.Retag(e, fields)
+		if err != nil {
+			logs.FEEDBACK.Println("✘")
+			logs.ERROR.Printf("couldn't retag %q: %v\n", e.Fullname(), err)
+			continue
+		}
+		index.Add(retagged)
+		logs.FEEDBACK.Println("✔︎")
+		count++
+	}
+
+	if err := index.Save(); err != nil {
+		logs.ERROR.Println("couldn't save the index of downloaded tracks:", err)
+	}
+	logs.FEEDBACK.Printf("%v track(s) retagged\n", count)
+}
+
+// parseAssignments parses list of "field=value" from flag.
+func parseAssignments(list []string, flag string) map[string]string {
+	m := make(map[string]string, len(list))
+	for _, a := range list {
+		parts := strings.SplitN(a, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			logs.FATAL.Fatalf("invalid %v %q: should be field=value\n", flag, a)
+		}
+		m[strings.ToLower(strings.TrimSpace(parts[0]))] = parts[1]
+	}
+	return m
+}
+
+func isTagField(field string) bool {
+	for _, f := range downloader.TagFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFilters reports whether e matches all filters.
+func matchesFilters(e index.Entry, filters map[string]string) bool {
+	for field, value := range filters {
+		var actual string
+		switch field {
+		case "id":
+			actual = strconv.Itoa(e.ID)
+		case "artist":
+			actual = e.Artist
+		case "title":
+			actual = e.Title
+		case "album":
+			actual = e.Album
+		case "genre":
+			actual = e.Genre
+		default:
+			logs.FATAL.Fatalf("invalid filter %q. Available filters: id, artist, title, album, genre\n", field)
+		}
+		if !strings.EqualFold(actual, value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	retryCommand = &cobra.Command{
+		Use:   "retry",
+		Short: "Download tracks, which failed to download earlier.",
+		Long:  "This command downloads only tracks, which failed to download in previous runs, instead of running the whole sync again.",
+		Run:   retryFailed,
+	}
+
+	// retryTimeout is used to set higher timeout for retried tracks.
+	retryTimeout time.Duration
+)
+
+func init() {
+	addDlFolderFlag(retryCommand)
+	addDryRunFlag(retryCommand)
+	addEditFlag(retryCommand)
+	addFailFastFlag(retryCommand)
+	addParallelFlag(retryCommand)
+	addQualityFlag(retryCommand)
+	addItunesPlaylistFlag(retryCommand)
+	addLabelFlag(retryCommand)
+	addPlaylistFileFlag(retryCommand)
+	addPlaylistFlag(retryCommand)
+	retryCommand.Flags().DurationVar(&retryTimeout, "timeout", 0, "timeout of network operations (e.g. 2m)")
+}
+
+func retryFailed(cmd *cobra.Command, args []string) {
+	if cmd.Flags().Changed("timeout") {
+		config.Set("timeout", retryTimeout.String())
+	}
+	initializeConfig(cmd)
+	// Tracks, which failed after they were written (e.g. on tagging,
+	// upload or adding to MPD), are in index, so they aren't skipped
+	// as already downloaded.
+	config.Set("redownload", "true")
+
+	tracks, err := downloader.FailedTracks()
+	if err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+	if len(tracks) == 0 {
+		logs.FEEDBACK.Println("There are no failed tracks to retry")
+		return
+	}
+
+	logs.FEEDBACK.Printf("Retrying %v track(s):\n", len(tracks))
+	downloadAll(downloader.NewConfiguredDownloader(), tracks)
+}
@@ -24,7 +24,15 @@ var (
 
 func init() {
 	addDlFolderFlag(searchCommand)
+	addDryRunFlag(searchCommand)
+	addEditFlag(searchCommand)
+	addFailFastFlag(searchCommand)
+	addForceFlag(searchCommand)
+	addParallelFlag(searchCommand)
+	addQualityFlag(searchCommand)
 	addItunesPlaylistFlag(searchCommand)
+	addLabelFlag(searchCommand)
+	addPlaylistFileFlag(searchCommand)
 	addLimitFlag(searchCommand)
 }
 
@@ -36,5 +44,5 @@ func searchAndShowTracks(cmd *cobra.Command, args []string) {
 	tm := menu.NewTracksMenu(api.FormSearchURL(limit, query))
 	downloadTracks := tm.Show()
 
-	downloader.NewConfiguredDownloader().DownloadAll(downloadTracks)
+	downloadAll(downloader.NewConfiguredDownloader(), downloadTracks)
 }
@@ -0,0 +1,42 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"path/filepath"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/site"
+	"github.com/bogem/nehm/util"
+	"github.com/spf13/cobra"
+)
+
+var (
+	siteCommand = &cobra.Command{
+		Use:   "site <outdir>",
+		Short: "Generate static HTML gallery of downloaded tracks.",
+		Long: "This command writes index.html with the grid of artworks, search box and links to files " +
+			"and SoundCloud pages of downloaded tracks to outdir. Links to files are relative, so serve " +
+			"a folder, which contains both outdir and dlFolder, to browse the library from other devices.",
+		Run: generateSite,
+	}
+)
+
+func generateSite(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	if len(args) != 1 {
+		logs.FATAL.Fatalln("you didn't give a folder for site. Use 'nehm site <outdir>'.")
+	}
+	dir := util.SanitizePath(args[0])
+
+	n, err := site.Generate(dir, index.All(), config.Get("coverFile"))
+	if err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+	logs.FEEDBACK.Printf("Gallery of %v track(s) is written to %v\n", n, filepath.Join(dir, "index.html"))
+}
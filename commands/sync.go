@@ -6,12 +6,16 @@ package commands
 
 import (
 	"os"
-	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/bogem/nehm/api"
 	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/digest"
 	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/index"
 	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/notify"
 	"github.com/bogem/nehm/track"
 	"github.com/spf13/cobra"
 )
@@ -20,56 +24,330 @@ var (
 	syncCommand = &cobra.Command{
 		Use:   "sync",
 		Short: "Synchronise your favorites with folder",
-		Long:  "This command downloads missing favorites to dlFolder.",
+		Long:  "This command downloads missing favorites to dlFolder. With --playlist it synchronises the playlist instead, with its own settings from playlists section of config and its own state, so several playlists can be synchronised at the same time.",
 		Run:   sync,
 	}
 )
 
+// maxRuntime is the time, after which sync doesn't start
+// downloading new tracks.
+var maxRuntime time.Duration
+
+// renameChanged is the flag, which enables renaming of downloaded tracks,
+// that were renamed on SoundCloud.
+var renameChanged bool
+
+// refreshDescriptions is the flag, which enables refreshing of descriptions
+// in tags of downloaded tracks, whose description was changed on SoundCloud.
+var refreshDescriptions bool
+
 func init() {
 	addDlFolderFlag(syncCommand)
+	addDryRunFlag(syncCommand)
+	addEditFlag(syncCommand)
+	addFailFastFlag(syncCommand)
+	addParallelFlag(syncCommand)
+	addQualityFlag(syncCommand)
 	addItunesPlaylistFlag(syncCommand)
+	addLabelFlag(syncCommand)
+	addPlaylistFileFlag(syncCommand)
 	addPermalinkFlag(syncCommand)
+	addPlaylistFlag(syncCommand)
+	syncCommand.Flags().DurationVar(&maxRuntime, "max-runtime", 0, "stop starting new tracks after this time (e.g. 30m)")
+	syncCommand.Flags().BoolVar(&renameChanged, "rename", false, "rename and retag downloaded tracks, which were renamed on SoundCloud")
+	syncCommand.Flags().BoolVar(&refreshDescriptions, "refresh-descriptions", false, "refresh descriptions in tags of downloaded tracks, which were changed on SoundCloud")
 }
 
 func sync(cmd *cobra.Command, args []string) {
+	if cmd.Flags().Changed("max-runtime") {
+		config.Set("maxRuntime", maxRuntime.String())
+	}
 	initializeConfig(cmd)
-	initializePermalink(cmd)
 
-	// Get favorites from user's profile
-	logs.FEEDBACK.Println("Getting favorites")
-	favs, err := api.AllFavorites(api.UID(config.Get("permalink")))
-	if err != nil {
-		logs.FATAL.Fatalln("can't get tracks from SoundCloud", err)
+	favs := syncedTracks(cmd)
+
+	// Propagate renames of tracks before checking the folder,
+	// otherwise renamed tracks will be downloaded again.
+	initializeBoolFlag(cmd, "rename", "renameChanged")
+	if config.GetBool("renameChanged") && config.GetBool("archive") {
+		logs.WARN.Println("tracks renamed on SoundCloud are not renamed in archive mode")
+	} else if config.GetBool("renameChanged") && config.GetBool("dryRun") {
+		logs.FEEDBACK.Println("Tracks renamed on SoundCloud are not renamed in dry-run mode")
+	} else if config.GetBool("renameChanged") {
+		renameChangedTracks(favs)
+	}
+	if !config.GetBool("dryRun") {
+		checkProtectedTracks(favs)
 	}
+	initializeBoolFlag(cmd, "refresh-descriptions", "refreshDescriptions")
+	checkDescriptions(favs, config.GetBool("refreshDescriptions") && !config.GetBool("archive") && !config.GetBool("dryRun"))
 
 	// Get nonexistent tracks in dlFolder
-	logs.FEEDBACK.Println("Check unsynchronised tracks\n")
-	tracks := nonexistentTracks(config.Get("dlFolder"), favs)
+	logs.FEEDBACK.Print("Check unsynchronised tracks\n\n")
+	dl := downloader.NewConfiguredDownloader()
+	tracks := nonexistentTracks(dl, favs)
+	tracks = skipRecentlyUnavailable(tracks)
+	tracks = continueFromCursor(tracks)
 
 	// Download not yet downloaded tracks
 	if len(tracks) == 0 {
-		logs.FEEDBACK.Println("Folder is already synchronised with favorites")
-		os.Exit(0)
+		logs.FEEDBACK.Println("Folder is already synchronised")
+		if !config.GetBool("dryRun") {
+			sendDigest()
+		}
+		exit(0)
+	}
+	if config.GetBool("dryRun") {
+		logs.FEEDBACK.Printf("%v of %v track(s) are already downloaded\n", len(favs)-len(tracks), len(favs))
+		downloadAll(dl, tracks)
+		return
 	}
 	logs.FEEDBACK.Printf("Downloading %v track(s):\n", len(tracks))
-	downloader.NewConfiguredDownloader().DownloadAll(tracks)
+	downloadAll(dl, tracks)
+	sendDigest()
+
+}
+
+// syncedTracks returns tracks of selected playlist or, if playlist
+// is not selected, favorites of user.
+func syncedTracks(cmd *cobra.Command) []track.Track {
+	if name := config.PlaylistName(); name != "" {
+		logs.FEEDBACK.Printf("Getting tracks of playlist %q\n", name)
+		_, tracks, err := api.ResolvePlaylist(config.Get("url"))
+		if err != nil {
+			logs.FATAL.Fatalln("can't get tracks of playlist from SoundCloud:", err)
+		}
+		return tracks
+	}
+
+	initializePermalink(cmd)
+	// Get favorites from user's profile
+	logs.FEEDBACK.Println("Getting favorites")
+	favs, err := api.AllFavorites(userID())
+	if err != nil {
+		logs.FATAL.Fatalln("can't get tracks from SoundCloud", err)
+	}
+	return favs
+}
+
+// sendDigest sends email digest of synchronised tracks, if it's due.
+func sendDigest() {
+	if err := digest.SendIfDue(); err != nil {
+		logs.ERROR.Println(err)
+	}
+}
+
+// renameChangedTracks renames and retags downloaded tracks,
+// whose artist or title was changed on SoundCloud.
+func renameChangedTracks(favs []track.Track) {
+	for _, t := range favs {
+		e, exists := index.Get(t.ID())
+		if !exists || entryChange(e, t) == "" {
+			continue
+		}
+
+		logs.FEEDBACK.Printf("Renaming %q to %q ... ", e.Fullname(), t.Fullname())
+		renamed, err := downloader.Rename(e, t)
+		if err != nil {
+			logs.FEEDBACK.Println("✘")
+			logs.ERROR.Printf("couldn't rename %q: %v\n", e.Fullname(), err)
+			continue
+		}
+		index.Add(renamed)
+		logs.FEEDBACK.Println("✔︎")
+	}
+
+	if err := index.Save(); err != nil {
+		logs.ERROR.Println("couldn't save the index of downloaded tracks:", err)
+	}
+}
+
+// checkDescriptions reports downloaded tracks, whose description was
+// changed on SoundCloud, e.g. tracklist was added later. If refresh
+// is true, descriptions in their tags are refreshed. Tracks downloaded
+// before descriptions were tracked only get the current hash.
+func checkDescriptions(favs []track.Track, refresh bool) {
+	var changed, updated int
+	for _, t := range favs {
+		e, exists := index.Get(t.ID())
+		if !exists || e.DescriptionHash == t.DescriptionHash() {
+			continue
+		}
+		if e.DescriptionHash == "" {
+			e.DescriptionHash = t.DescriptionHash()
+			index.Add(e)
+			updated++
+			continue
+		}
+
+		changed++
+		if !refresh {
+			logs.FEEDBACK.Printf("Description of %q was changed on SoundCloud\n", t.Fullname())
+			continue
+		}
+		logs.FEEDBACK.Printf("Refreshing description of %q ... ", t.Fullname())
+		refreshed, err := downloader.RefreshDescription(e, t)
+		if err != nil {
+			logs.FEEDBACK.Println("✘")
+			logs.ERROR.Printf("couldn't refresh description of %q: %v\n", t.Fullname(), err)
+			continue
+		}
+		index.Add(refreshed)
+		updated++
+		logs.FEEDBACK.Println("✔︎")
+	}
+	if changed > 0 && !refresh {
+		logs.FEEDBACK.Println("Use --refresh-descriptions to refresh them in tags")
+	}
+
+	if updated > 0 && !config.GetBool("dryRun") {
+		if err := index.Save(); err != nil {
+			logs.ERROR.Println("couldn't save the index of downloaded tracks:", err)
+		}
+	}
+}
+
+// checkProtectedTracks checks protected tracks, which are not in tracks,
+// on SoundCloud. If protected track disappeared, it sends the notification
+// with high priority. Each disappearance is notified only once.
+func checkProtectedTracks(tracks []track.Track) {
+	synced := make(map[int]bool, len(tracks))
+	for _, t := range tracks {
+		synced[t.ID()] = true
+	}
+
+	var changed bool
+	for _, e := range index.Protected() {
+		if synced[e.ID] {
+			continue
+		}
+		// Rate limits and outages of SoundCloud don't abort sync.
+		_, err := api.LookupTrack(e.ID)
+		if err != nil && err != api.ErrNotFound && err != api.ErrForbidden {
+			logs.WARN.Printf("couldn't check protected track %q: %v\n", e.Fullname(), err)
+			continue
+		}
+		_, wasMissing := index.GetUnavailable(e.ID)
+		if err == nil {
+			if wasMissing {
+				index.MarkAvailable(e.ID)
+				changed = true
+			}
+			continue
+		}
 
+		index.MarkUnavailable(e.ID, e.Fullname(), "removed from SoundCloud ("+err.Error()+")")
+		changed = true
+		if wasMissing {
+			continue
+		}
+		logs.WARN.Printf("protected track %q disappeared from SoundCloud, local copy is at %q\n", e.Fullname(), e.Path)
+		n := notify.Event{
+			Type:     "protected_unavailable",
+			Title:    "Protected track disappeared from SoundCloud",
+			Message:  e.Fullname() + " is only in your library now",
+			TrackID:  e.ID,
+			Path:     e.Path,
+			Priority: notify.PriorityHigh,
+		}
+		if err := notify.Send(n); err != nil {
+			logs.WARN.Println(err)
+		}
+	}
+
+	if changed {
+		if err := index.Save(); err != nil {
+			logs.ERROR.Println("couldn't save the index of downloaded tracks:", err)
+		}
+	}
 }
 
 // nonexistentTracks returns tracks
-// that don't exist in `dir` but are in `tracks`.
-func nonexistentTracks(dir string, tracks []track.Track) []track.Track {
+// that aren't downloaded by dl but are in `tracks`.
+// Tracks, which are in index and whose files exist, are considered
+// as downloaded, even if they were moved (e.g. to iTunes library).
+func nonexistentTracks(dl *downloader.Downloader, tracks []track.Track) []track.Track {
 	nonexistent := make([]track.Track, 0, len(tracks))
 
 	for _, t := range tracks {
-		path := t.Filename()
-		if dir != "." {
-			path = filepath.Join(dir, t.Filename())
+		if e, exists := index.Get(t.ID()); exists {
+			if _, err := os.Stat(e.Path); err == nil {
+				continue
+			}
 		}
-		if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(dl.TrackPath(t)); os.IsNotExist(err) {
 			nonexistent = append(nonexistent, t)
 		}
 	}
 
 	return nonexistent
 }
+
+// continueFromCursor moves tracks, which remained after previous sync
+// was stopped by max runtime, so they're downloaded first.
+func continueFromCursor(tracks []track.Track) []track.Track {
+	cursor, exists, err := downloader.LoadCursor()
+	if err != nil {
+		logs.WARN.Println(err)
+	}
+	if !exists {
+		return tracks
+	}
+
+	isRemaining := make(map[int]bool, len(cursor.Remaining))
+	for _, id := range cursor.Remaining {
+		isRemaining[id] = true
+	}
+
+	// Tracks are downloaded from the last one.
+	ordered := make([]track.Track, 0, len(tracks))
+	var continued []track.Track
+	for _, t := range tracks {
+		if isRemaining[t.ID()] {
+			continued = append(continued, t)
+		} else {
+			ordered = append(ordered, t)
+		}
+	}
+	if len(continued) > 0 {
+		logs.FEEDBACK.Printf("Continuing sync stopped at %v\n", cursor.StoppedAt.Format("2006-01-02 15:04"))
+	}
+	return append(ordered, continued...)
+}
+
+// defaultRecheckUnavailableDays is the count of days, after which
+// unavailable tracks are checked again, if recheckUnavailableDays
+// isn't set in config.
+const defaultRecheckUnavailableDays = 7
+
+// skipRecentlyUnavailable removes from tracks those, which were unavailable
+// (private, geo-blocked or not streamable) when they were checked
+// less than recheckUnavailableDays days ago. Other unavailable tracks
+// are rechecked by downloading them again.
+func skipRecentlyUnavailable(tracks []track.Track) []track.Track {
+	days := defaultRecheckUnavailableDays
+	if value := config.Get("recheckUnavailableDays"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 0 {
+			logs.FATAL.Fatalf("recheckUnavailableDays must be a non-negative integer, got %q\n", value)
+		}
+	}
+
+	threshold := time.Now().AddDate(0, 0, -days)
+	available := make([]track.Track, 0, len(tracks))
+	var skipped int
+	for _, t := range tracks {
+		if u, exists := index.GetUnavailable(t.ID()); exists && u.CheckedAt.After(threshold) {
+			logs.INFO.Printf("skipping %q: %v since %v\n", t.Fullname(), u.Reason, u.Since.Format("2006-01-02"))
+			skipped++
+			continue
+		}
+		available = append(available, t)
+	}
+
+	if skipped > 0 {
+		logs.FEEDBACK.Printf("Skipping %v unavailable track(s), they'll be rechecked after %v day(s)\n", skipped, days)
+	}
+	return available
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogem/nehm/color"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/manifest"
+	"github.com/bogem/nehm/mp3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyCommand = &cobra.Command{
+		Use:   "verify",
+		Short: "Verify downloaded tracks against manifest.",
+		Long:  "This command checks SHA-256 hashes of tracks listed in manifest, which is written after downloading, if writeManifest is enabled. With --deep MPEG frames of MP3 files are checked too, so corrupt files are found also without manifest.",
+		Run:   verify,
+	}
+
+	manifestPath string
+	deepVerify   bool
+)
+
+// corrupt is the status of files with broken MPEG frames.
+const corrupt = "CORRUPT"
+
+func init() {
+	verifyCommand.Flags().StringVarP(&manifestPath, "manifest", "m", "", "path to manifest")
+	verifyCommand.Flags().BoolVar(&deepVerify, "deep", false, "check MPEG frames of MP3 files (all downloaded tracks, if manifest isn't set)")
+}
+
+func verify(cmd *cobra.Command, args []string) {
+	if manifestPath == "" && !deepVerify {
+		logs.FATAL.Fatalln("you didn't set a manifest. Use flag '--manifest' or '--deep'.")
+	}
+
+	var results []manifest.Result
+	if manifestPath != "" {
+		var err error
+		results, err = manifest.Verify(manifestPath)
+		if err != nil {
+			logs.FATAL.Fatalln("couldn't verify manifest:", err)
+		}
+	} else {
+		initializeConfig(cmd)
+		for _, e := range index.All() {
+			status := manifest.OK
+			if _, err := os.Stat(e.Path); os.IsNotExist(err) {
+				status = manifest.Missing
+			}
+			results = append(results, manifest.Result{Path: e.Path, Status: status})
+		}
+	}
+
+	var bad int
+	for _, r := range results {
+		var reason string
+		if deepVerify && r.Status != manifest.Missing && strings.EqualFold(filepath.Ext(r.Path), ".mp3") {
+			if err := mp3.Validate(r.Path); err != nil {
+				r.Status = corrupt
+				reason = ": " + err.Error()
+			}
+		}
+
+		switch r.Status {
+		case manifest.OK:
+			logs.FEEDBACK.Println(color.GreenString(r.Status), r.Path)
+		default:
+			bad++
+			logs.FEEDBACK.Println(color.RedString(r.Status), r.Path+reason)
+		}
+	}
+
+	logs.FEEDBACK.Printf("\n%v file(s) verified, %v with problems\n", len(results), bad)
+	if bad > 0 {
+		exit(1)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/bogem/nehm/api"
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/coord"
+	"github.com/bogem/nehm/downloader"
+	"github.com/bogem/nehm/feed"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/notify"
+	"github.com/bogem/nehm/track"
+	"github.com/bogem/nehm/util"
+	"github.com/bogem/nehm/watch"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchCommand = &cobra.Command{
+		Use:   "watch",
+		Short: "Download new uploads of watched artists and episodes of feeds as soon as they appear.",
+		Long: "This command checks uploads of artists from watchArtists and RSS feeds from feeds and feedsOPML " +
+			"every watchInterval (5m by default), downloads new ones and sends notifications to desktop (notifyDesktop) " +
+			"and webhook (notifyWebhook). Uploads and episodes, which existed when artist or feed was checked " +
+			"the first time, are not downloaded. Episodes are downloaded to the folder of feed in dlFolder.",
+		Run: watchArtists,
+	}
+)
+
+const (
+	defaultWatchInterval = 5 * time.Minute
+
+	// watchUploadsLimit is the count of last uploads checked for each artist.
+	watchUploadsLimit = 20
+)
+
+var watchOnce, watchHealth bool
+
+// healthGrace is the time, which is added to watchInterval, while
+// healthcheck waits for the next check of daemon. Checks of many
+// artists and feeds take time.
+const healthGrace = 10 * time.Minute
+
+func init() {
+	addDlFolderFlag(watchCommand)
+	addItunesPlaylistFlag(watchCommand)
+	watchCommand.Flags().BoolVar(&watchOnce, "once", false, "check artists once and exit (e.g. to run from cron)")
+	watchCommand.Flags().BoolVar(&watchHealth, "health", false, "check, that running daemon is healthy, and exit with code 1, if it isn't (e.g. for HEALTHCHECK of Docker)")
+}
+
+func watchArtists(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	interval := defaultWatchInterval
+	if value := config.Get("watchInterval"); value != "" {
+		var err error
+		if interval, err = time.ParseDuration(value); err != nil || interval <= 0 {
+			logs.FATAL.Fatalf("invalid watchInterval %q: should be positive duration (e.g. 5m)\n", value)
+		}
+	}
+	if watchHealth {
+		checkDaemonHealth(interval)
+		return
+	}
+
+	artists := config.GetStringSlice("watchArtists")
+	feeds := feedsFromConfig()
+	if len(artists) == 0 && len(feeds) == 0 {
+		logs.FATAL.Fatalln("you didn't set artists or feeds to watch. Set watchArtists, feeds or feedsOPML in config file.")
+	}
+
+	// Other nehm processes share rate limit and downloads with daemon.
+	// Runs with --once (e.g. from cron) use daemon, if it's running.
+	if !watchOnce {
+		if err := coord.Serve(); err != nil {
+			logs.FATAL.Fatalln(err)
+		}
+	}
+
+	uids := make(map[string]string, len(artists))
+	for _, artist := range artists {
+		uids[artist] = api.UID(artist)
+	}
+
+	for {
+		if len(artists) > 0 {
+			checkWatchedArtists(artists, uids)
+		}
+		if len(feeds) > 0 {
+			checkFeeds(feeds)
+		}
+		sendDigest()
+		if watchOnce {
+			return
+		}
+		coord.Beat()
+		time.Sleep(interval)
+	}
+}
+
+// checkDaemonHealth checks, that watch daemon is running and finishes
+// its checks every interval. The program is terminating with code 1,
+// if it isn't so.
+func checkDaemonHealth(interval time.Duration) {
+	h, err := coord.CheckHealth()
+	if err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+	last := h.LastBeat
+	if last.IsZero() {
+		last = h.Started
+	}
+	if since := time.Since(last); since > interval+healthGrace {
+		logs.FATAL.Fatalf("nehm daemon (pid %v) hasn't finished a check for %v\n", h.PID, since.Round(time.Second))
+	}
+	logs.FEEDBACK.Printf("nehm daemon (pid %v) is healthy: running since %v, %v track(s) downloading\n",
+		h.PID, h.Started.Format(time.RFC3339), h.Claims)
+}
+
+// checkWatchedArtists downloads new uploads of artists
+// and sends notifications about them.
+func checkWatchedArtists(artists []string, uids map[string]string) {
+	state, err := watch.Load()
+	if err != nil {
+		logs.ERROR.Println(err)
+		return
+	}
+
+	var fresh []track.Track
+	for _, artist := range artists {
+		uploads, err := api.UserTracks(watchUploadsLimit, uids[artist])
+		if err != nil {
+			logs.WARN.Printf("couldn't get uploads of %q: %v\n", artist, err)
+			continue
+		}
+		fresh = append(fresh, state.NewUploads(artist, uploads)...)
+	}
+	if err := state.Save(); err != nil {
+		logs.ERROR.Println("couldn't save the state of watched artists:", err)
+	}
+	if len(fresh) == 0 {
+		logs.INFO.Println("there are no new uploads of watched artists")
+		return
+	}
+
+	logs.FEEDBACK.Printf("%v new upload(s) of watched artists\n", len(fresh))
+	// Aborted batch doesn't stop the daemon, uploads are checked
+	// again in the next interval.
+	if err := downloader.NewConfiguredDownloader().DownloadAll(fresh); err != nil {
+		logs.ERROR.Println(err)
+	}
+
+	for _, t := range fresh {
+		e := notify.Event{
+			Type:    "watch_download",
+			Title:   "New track by " + t.Uploader(),
+			Message: t.Fullname(),
+			TrackID: t.ID(),
+			URL:     t.PermalinkURL(),
+		}
+		if entry, exists := index.Get(t.ID()); exists {
+			e.Path = entry.Path
+		} else {
+			e.Type = "watch_error"
+			e.Message += " (couldn't download)"
+		}
+		if err := notify.Send(e); err != nil {
+			logs.WARN.Println(err)
+		}
+	}
+}
+
+// feedsFromConfig returns URLs of feeds from feeds and OPML file
+// at feedsOPML in config. The program is terminating, if OPML file
+// can't be read.
+func feedsFromConfig() []string {
+	feeds := config.GetStringSlice("feeds")
+	path := config.Get("feedsOPML")
+	if path == "" {
+		return feeds
+	}
+	data, err := ioutil.ReadFile(util.SanitizePath(path))
+	if err != nil {
+		logs.FATAL.Fatalln("couldn't read feedsOPML:", err)
+	}
+	urls, err := feed.ParseOPML(data)
+	if err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+	return append(feeds, urls...)
+}
+
+// checkFeeds downloads new episodes of feeds
+// and sends notifications about them.
+func checkFeeds(urls []string) {
+	state, err := watch.Load()
+	if err != nil {
+		logs.ERROR.Println(err)
+		return
+	}
+
+	type episode struct {
+		feed feed.Feed
+		feed.Episode
+	}
+	var fresh []episode
+	for _, url := range urls {
+		f, err := feed.Fetch(url)
+		if err != nil {
+			logs.WARN.Printf("couldn't check feed %q: %v\n", url, err)
+			continue
+		}
+		for _, e := range state.NewEpisodes(url, f.Episodes) {
+			fresh = append(fresh, episode{f, e})
+		}
+	}
+	if err := state.Save(); err != nil {
+		logs.ERROR.Println("couldn't save the state of watched feeds:", err)
+	}
+	if len(fresh) == 0 {
+		logs.INFO.Println("there are no new episodes of feeds")
+		return
+	}
+
+	logs.FEEDBACK.Printf("%v new episode(s) of feeds\n", len(fresh))
+	d := downloader.NewConfiguredDownloader()
+	for _, e := range fresh {
+		n := notify.Event{
+			Type:    "feed_download",
+			Title:   "New episode of " + e.feed.Title,
+			Message: e.Title,
+			URL:     e.URL,
+		}
+		path, err := d.DownloadEpisode(e.feed, e.Episode)
+		if err != nil {
+			logs.ERROR.Printf("couldn't download %q: %v\n", e.Title, err)
+			n.Type = "feed_error"
+			n.Message += " (couldn't download)"
+		}
+		n.Path = path
+		if err := notify.Send(n); err != nil {
+			logs.WARN.Println(err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/bogem/nehm/api"
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	whoamiCommand = &cobra.Command{
+		Use:   "whoami",
+		Short: "Show the account authenticated with oauthToken.",
+		Run:   whoami,
+	}
+)
+
+func whoami(cmd *cobra.Command, args []string) {
+	initializeConfig(cmd)
+
+	token := config.Get("oauthToken")
+	if token == "" {
+		logs.FATAL.Fatalln("you didn't set oauthToken in config file")
+	}
+
+	me, err := api.Me(token)
+	if err != nil {
+		logs.FATAL.Fatalln("couldn't get authenticated user:", err)
+	}
+
+	logs.FEEDBACK.Println("Username: ", me.Username)
+	logs.FEEDBACK.Println("Permalink:", me.Permalink)
+	logs.FEEDBACK.Println("ID:       ", me.ID)
+	if me.Plan != "" {
+		logs.FEEDBACK.Println("Plan:     ", me.Plan)
+	}
+}
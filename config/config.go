@@ -12,33 +12,174 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/bogem/nehm/util"
 	"gopkg.in/yaml.v2"
 )
 
 var (
 	override = make(map[string]string)
-	config   = make(map[string]string)
+	account  = make(map[string]interface{})
+	config   = make(map[string]interface{})
 	defaults = make(map[string]string)
 
-	configPath = filepath.Join(os.Getenv("HOME"), ".nehmconfig")
+	// accountName is the name of account selected with UseAccount.
+	accountName string
+
+	// playlist is the section of playlist selected with UsePlaylist.
+	playlist     = make(map[string]interface{})
+	playlistName string
+
+	configPath = defaultConfigPath()
 
 	ErrNotExist = errors.New("config file doesn't exist")
 )
 
+// envPrefix is the prefix of environment variables, which can be used
+// instead of the config file, e.g. NEHM_DLFOLDER for dlFolder.
+const envPrefix = "NEHM_"
+
+// defaultConfigPath returns the path of config file. It can be changed with
+// NEHM_CONFIG environment variable.
+func defaultConfigPath() string {
+	if path := os.Getenv(envPrefix + "CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".nehmconfig")
+}
+
 // Get has the behavior of returning the value associated with the first
 // place from where it is set. Get will check value in the following order:
-// override, config file, defaults. Get is case-sensitive.
+// override, environment variables, section of selected playlist,
+// section of selected account, config file, defaults.
+// Get is case-sensitive, but environment variables are looked up
+// in upper case.
 func Get(key string) string {
 	if value, exists := override[key]; exists {
 		return value
 	}
-	if value, exists := config[key]; exists {
+	if value, exists := os.LookupEnv(envKey(key)); exists {
 		return value
 	}
+	if value, exists := lookupFile(key); exists {
+		return toString(value)
+	}
 	return defaults[key]
 }
 
+// lookupFile returns the value of key from sections of selected playlist
+// and account or, if it's not set there, from config file.
+func lookupFile(key string) (interface{}, bool) {
+	if value, exists := playlist[key]; exists {
+		return value, true
+	}
+	if value, exists := account[key]; exists {
+		return value, true
+	}
+	value, exists := config[key]
+	return value, exists
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// GetStringMap returns the map associated with the key in config file.
+// Maps can be set only in config file, so override and environment
+// variables are not checked. If there is no such map, it returns nil.
+func GetStringMap(key string) map[string]string {
+	value, _ := lookupFile(key)
+	m, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+
+	stringMap := make(map[string]string, len(m))
+	for k, v := range m {
+		stringMap[toString(k)] = toString(v)
+	}
+	return stringMap
+}
+
+// GetStringSlice returns the list associated with the key in config file.
+// If value is a string (e.g. from environment variable),
+// it's split by commas.
+func GetStringSlice(key string) []string {
+	if value, exists := override[key]; exists {
+		return splitList(value)
+	}
+	if value, exists := os.LookupEnv(envKey(key)); exists {
+		return splitList(value)
+	}
+
+	value, _ := lookupFile(key)
+	list, ok := value.([]interface{})
+	if !ok {
+		return splitList(toString(value))
+	}
+	slice := make([]string, 0, len(list))
+	for _, v := range list {
+		slice = append(slice, toString(v))
+	}
+	return slice
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// GetNestedStringMap returns the map of maps associated with the key
+// in config file, e.g. section with settings for each uploader.
+// Values, which are not maps, are skipped.
+func GetNestedStringMap(key string) map[string]map[string]string {
+	value, _ := lookupFile(key)
+	m, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+
+	nested := make(map[string]map[string]string, len(m))
+	for k, v := range m {
+		inner, ok := v.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		stringMap := make(map[string]string, len(inner))
+		for ik, iv := range inner {
+			stringMap[toString(ik)] = toString(iv)
+		}
+		nested[toString(k)] = stringMap
+	}
+	return nested
+}
+
+// GetBool returns the value associated with the key as a boolean.
+// If value is not set or invalid, it returns false.
+func GetBool(key string) bool {
+	b, _ := strconv.ParseBool(Get(key))
+	return b
+}
+
+// envKey returns the name of environment variable for the key.
+func envKey(key string) string {
+	return envPrefix + strings.ToUpper(key)
+}
+
 // ReadInConfig will discover and load the config file from disk, searching
 // in the defined path.
 func ReadInConfig() error {
@@ -63,6 +204,84 @@ func ReadInConfig() error {
 	return nil
 }
 
+// UseAccount selects the account with name from accounts section
+// of config file. Values set in section of account override values
+// from the rest of config file.
+func UseAccount(name string) error {
+	accounts, _ := config["accounts"].(map[interface{}]interface{})
+	section, ok := accounts[name].(map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("there is no account %q in config file", name)
+	}
+
+	account = make(map[string]interface{}, len(section))
+	for k, v := range section {
+		account[toString(k)] = v
+	}
+	accountName = name
+	return nil
+}
+
+// UsePlaylist selects the playlist with name from playlists section
+// of config file. Values set in section of playlist (e.g. url, dlFolder)
+// override other values. If there is no such section, but name is
+// the URL of playlist on SoundCloud, it's used as url.
+func UsePlaylist(name string) error {
+	playlists, _ := lookupFile("playlists")
+	sections, _ := playlists.(map[interface{}]interface{})
+	section, ok := sections[name].(map[interface{}]interface{})
+	if !ok && strings.Contains(name, "soundcloud.com/") {
+		section, ok = map[interface{}]interface{}{"url": name}, true
+		name = strings.SplitN(name, "soundcloud.com/", 2)[1]
+	}
+	if !ok {
+		return fmt.Errorf("there is no playlist %q in config file", name)
+	}
+
+	p := make(map[string]interface{}, len(section))
+	for k, v := range section {
+		p[toString(k)] = v
+	}
+	if toString(p["url"]) == "" {
+		return fmt.Errorf("url of playlist %q is not set", name)
+	}
+	playlist = p
+	playlistName = name
+	return nil
+}
+
+// PlaylistName returns the name of playlist selected with UsePlaylist.
+func PlaylistName() string {
+	return playlistName
+}
+
+// SyncStateDir returns the folder with the state of synchronisation,
+// like the cursor of stopped sync. Each playlist selected with
+// UsePlaylist has its own state, so playlists are synchronised
+// independently.
+func SyncStateDir() string {
+	if playlistName == "" {
+		return StateDir()
+	}
+	name := strings.Replace(playlistName, "/", "_", -1)
+	return filepath.Join(StateDir(), "playlists", util.SanitizeFilename(name))
+}
+
+// StateDir returns the folder, where nehm keeps its state like
+// the index of downloaded tracks. It can be changed with stateDir key.
+// Every account has its own state in accounts subfolder, unless
+// stateDir is set in section of account.
+func StateDir() string {
+	dir := filepath.Join(os.Getenv("HOME"), ".nehm")
+	if d := Get("stateDir"); d != "" {
+		dir = util.SanitizePath(d)
+	}
+	if _, exists := account["stateDir"]; accountName != "" && !exists {
+		dir = filepath.Join(dir, "accounts", accountName)
+	}
+	return dir
+}
+
 // Set sets the value for the key in the override regiser.
 // Set is case-sensitive.
 func Set(key, value string) {
@@ -0,0 +1,53 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package coord
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is the token bucket, which allows rate requests per second
+// with bursts up to rate requests (but at least one).
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64) *bucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take waits for token. If rate is zero, it returns immediately.
+func (b *bucket) take() {
+	if b.rate <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens < 0 {
+		// Waiting under lock keeps the order of requests.
+		wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+		time.Sleep(wait)
+		b.last = b.last.Add(wait)
+		b.tokens = 0
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package coord coordinates nehm processes, e.g. watch daemon and
+// 'nehm get' run at the same time. Daemon serves the local socket,
+// through which other processes share its rate limit (rateLimit in config)
+// and claim tracks before downloading, so the same track isn't downloaded
+// twice and SoundCloud isn't hammered by concurrent processes.
+// Without daemon every process has its own rate limit.
+package coord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+)
+
+// Rate is the count of requests per second (rateLimit in config).
+// Zero means no limit. It should be set before the first request.
+var Rate float64
+
+// dialTimeout is the time, during which daemon should answer.
+// Socket is local, so it's short.
+const dialTimeout = 200 * time.Millisecond
+
+// healthTimeout is the time, during which daemon should answer
+// to healthcheck. Daemon answers at once, if it's not stuck.
+const healthTimeout = 5 * time.Second
+
+func socketPath() string {
+	return filepath.Join(config.StateDir(), "nehm.sock")
+}
+
+// request is the message sent by client to daemon.
+type request struct {
+	Op    string       `json:"op"`
+	ID    int          `json:"id,omitempty"`
+	Force bool         `json:"force,omitempty"`
+	Entry *index.Entry `json:"entry,omitempty"`
+}
+
+// response is the answer of daemon to request.
+type response struct {
+	OK     bool    `json:"ok"`
+	Health *Health `json:"health,omitempty"`
+}
+
+// Health is the state of daemon returned to healthcheck.
+type Health struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	// LastBeat is the time, when daemon finished its last check
+	// (see Beat). It's zero, if the first check isn't finished yet.
+	LastBeat time.Time `json:"last_beat,omitempty"`
+	// Claims is the count of tracks, which are downloading now.
+	Claims int `json:"claims"`
+}
+
+// Operations of requests.
+const (
+	opTake    = "take"
+	opClaim   = "claim"
+	opRelease = "release"
+	opHealth  = "health"
+)
+
+var (
+	// serving is set in daemon, which handles requests itself.
+	serving bool
+
+	connectOnce sync.Once
+	clientMu    sync.Mutex
+	conn        net.Conn
+	enc         *json.Encoder
+	dec         *json.Decoder
+
+	localOnce   sync.Once
+	localBucket *bucket
+)
+
+// Take waits, until request can be sent according to the shared rate limit.
+// It's called by httpclient before each request.
+func Take() {
+	if resp, ok := call(request{Op: opTake}); ok && resp.OK {
+		return
+	}
+	localOnce.Do(func() { localBucket = newBucket(Rate) })
+	localBucket.take()
+}
+
+// Claim claims track with id for downloading by this process. It returns
+// false, if track is being downloaded by another process or, unless force
+// is set, it was already downloaded by daemon or reported to it.
+func Claim(id int, force bool) bool {
+	resp, ok := call(request{Op: opClaim, ID: id, Force: force})
+	return !ok || resp.OK
+}
+
+// Release releases the claim of track with id. If track was downloaded,
+// e is its entry in index, which is added to index of daemon.
+func Release(id int, e *index.Entry) {
+	call(request{Op: opRelease, ID: id, Entry: e})
+}
+
+// Beat records, that daemon has finished its check. Time of the last
+// beat is reported in Health, so daemon, which got stuck, can be detected.
+func Beat() {
+	if serving {
+		srv.beat()
+	}
+}
+
+// CheckHealth asks the running daemon about its state. It returns error,
+// if there is no daemon or it doesn't answer.
+func CheckHealth() (Health, error) {
+	c, err := net.DialTimeout("unix", socketPath(), dialTimeout)
+	if err != nil {
+		return Health{}, fmt.Errorf("nehm daemon isn't running: %v", err)
+	}
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(healthTimeout))
+	var resp response
+	if err := json.NewEncoder(c).Encode(request{Op: opHealth}); err != nil {
+		return Health{}, fmt.Errorf("couldn't send request to nehm daemon: %v", err)
+	}
+	if err := json.NewDecoder(c).Decode(&resp); err != nil {
+		return Health{}, fmt.Errorf("nehm daemon didn't answer: %v", err)
+	}
+	if !resp.OK || resp.Health == nil {
+		return Health{}, errors.New("nehm daemon doesn't support healthcheck")
+	}
+	return *resp.Health, nil
+}
+
+// call sends req to daemon and returns its response. ok is false, if there
+// is no daemon or it didn't answer, so process should act on its own.
+func call(req request) (resp response, ok bool) {
+	if serving {
+		return srv.handle(0, req), true
+	}
+
+	connectOnce.Do(connect)
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if conn == nil {
+		return response{}, false
+	}
+	if err := enc.Encode(req); err == nil {
+		if err = dec.Decode(&resp); err == nil {
+			return resp, true
+		}
+	}
+	logs.WARN.Println("lost connection to nehm daemon, continuing without it")
+	conn.Close()
+	conn = nil
+	return response{}, false
+}
+
+func connect() {
+	c, err := net.DialTimeout("unix", socketPath(), dialTimeout)
+	if err != nil {
+		return
+	}
+	logs.INFO.Println("connected to nehm daemon, its rate limit and downloads are shared")
+	conn = c
+	enc = json.NewEncoder(c)
+	dec = json.NewDecoder(c)
+}
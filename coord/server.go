@@ -0,0 +1,143 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package coord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+)
+
+// server handles requests of clients and of daemon itself.
+type server struct {
+	bucket *bucket
+
+	mu sync.Mutex
+	// claims are owners of claimed tracks by IDs of tracks.
+	// Owner 0 is daemon, clients are numbered from 1.
+	claims map[int]int
+
+	started  time.Time
+	lastBeat time.Time
+}
+
+var srv *server
+
+// Serve starts serving the socket for other processes, so they share
+// the rate limit and downloads of this process. It returns error,
+// if another daemon is already running.
+func Serve() error {
+	path := socketPath()
+	if c, err := net.DialTimeout("unix", path, dialTimeout); err == nil {
+		c.Close()
+		return errors.New("another nehm daemon is already running")
+	}
+	// Socket is left, if daemon was killed.
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("couldn't listen on %q: %v", path, err)
+	}
+
+	srv = &server{bucket: newBucket(Rate), claims: make(map[int]int), started: time.Now()}
+	serving = true
+	go func() {
+		for owner := 1; ; owner++ {
+			c, err := l.Accept()
+			if err != nil {
+				logs.ERROR.Println("nehm daemon stopped accepting connections:", err)
+				return
+			}
+			go srv.serveConn(owner, c)
+		}
+	}()
+	return nil
+}
+
+// serveConn handles requests of client owner from c. Claims of client
+// are released, when it disconnects.
+func (s *server) serveConn(owner int, c net.Conn) {
+	defer c.Close()
+	defer s.releaseAll(owner)
+
+	enc := json.NewEncoder(c)
+	dec := json.NewDecoder(c)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if err := enc.Encode(s.handle(owner, req)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *server) handle(owner int, req request) response {
+	switch req.Op {
+	case opTake:
+		s.bucket.take()
+		return response{OK: true}
+	case opClaim:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if o, claimed := s.claims[req.ID]; claimed && o != owner {
+			return response{OK: false}
+		}
+		if _, downloaded := index.Get(req.ID); downloaded && !req.Force {
+			return response{OK: false}
+		}
+		s.claims[req.ID] = owner
+		return response{OK: true}
+	case opRelease:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.claims[req.ID] == owner {
+			delete(s.claims, req.ID)
+		}
+		if req.Entry != nil && owner != 0 {
+			index.Add(*req.Entry)
+			if err := index.Save(); err != nil {
+				logs.WARN.Println("couldn't save the index of downloaded tracks:", err)
+			}
+		}
+		return response{OK: true}
+	case opHealth:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return response{OK: true, Health: &Health{
+			PID:      os.Getpid(),
+			Started:  s.started,
+			LastBeat: s.lastBeat,
+			Claims:   len(s.claims),
+		}}
+	}
+	return response{OK: false}
+}
+
+func (s *server) beat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastBeat = time.Now()
+}
+
+func (s *server) releaseAll(owner int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, o := range s.claims {
+		if o == owner {
+			delete(s.claims, id)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package digest collects downloaded and failed tracks between runs
+// and sends them by email as one digest, when it's due.
+// It's enabled, if smtpHost and digestRecipients are set in config.
+package digest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bogem/nehm/config"
+)
+
+// defaultInterval is the minimal interval between digests,
+// if digestInterval isn't set in config.
+const defaultInterval = 24 * time.Hour
+
+// state is the unsent digest.
+type state struct {
+	LastSent   time.Time `json:"last_sent"`
+	Downloaded []string  `json:"downloaded,omitempty"`
+	Failed     []string  `json:"failed,omitempty"`
+}
+
+func statePath() string {
+	return filepath.Join(config.StateDir(), "digest.json")
+}
+
+// Enabled reports whether digests are configured.
+func Enabled() bool {
+	return config.Get("smtpHost") != "" && len(recipients()) > 0
+}
+
+func recipients() []string {
+	var list []string
+	for _, r := range strings.Split(config.Get("digestRecipients"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// Add adds names of downloaded tracks and errors of failed ones
+// to the next digest. It does nothing, if digests are not enabled.
+func Add(downloaded, failed []string) error {
+	if !Enabled() || len(downloaded)+len(failed) == 0 {
+		return nil
+	}
+
+	s, err := load()
+	if err != nil {
+		return err
+	}
+	if s.LastSent.IsZero() {
+		// The first digest is sent after interval too.
+		s.LastSent = time.Now()
+	}
+	s.Downloaded = append(s.Downloaded, downloaded...)
+	s.Failed = append(s.Failed, failed...)
+	return save(s)
+}
+
+// SendIfDue sends the digest, if digestInterval has passed
+// since the last one and there is something to report.
+// It does nothing, if digests are not enabled.
+func SendIfDue() error {
+	if !Enabled() {
+		return nil
+	}
+
+	interval := defaultInterval
+	if value := config.Get("digestInterval"); value != "" {
+		var err error
+		interval, err = time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid digestInterval %q: %v", value, err)
+		}
+	}
+
+	s, err := load()
+	if err != nil {
+		return err
+	}
+	if time.Since(s.LastSent) < interval || len(s.Downloaded)+len(s.Failed) == 0 {
+		return nil
+	}
+
+	if err := send(s); err != nil {
+		return fmt.Errorf("couldn't send digest: %v", err)
+	}
+	return save(state{LastSent: time.Now()})
+}
+
+func send(s state) error {
+	host := config.Get("smtpHost")
+	port := config.Get("smtpPort")
+	if port == "" {
+		port = "587"
+	}
+	from := config.Get("smtpFrom")
+	if from == "" {
+		from = config.Get("smtpUsername")
+	}
+	to := recipients()
+
+	var auth smtp.Auth
+	if username := config.Get("smtpUsername"); username != "" {
+		auth = smtp.PlainAuth("", username, config.Get("smtpPassword"), host)
+	}
+	return smtp.SendMail(net.JoinHostPort(host, port), auth, from, to, message(s, from, to))
+}
+
+func message(s state, from string, to []string) []byte {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %v\r\n", from)
+	fmt.Fprintf(&body, "To: %v\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&body, "Subject: nehm: %v downloaded, %v failed\r\n", len(s.Downloaded), len(s.Failed))
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&body, "Since %v:\r\n\r\n", s.LastSent.Format("2006-01-02 15:04"))
+	if len(s.Downloaded) > 0 {
+		fmt.Fprintf(&body, "Downloaded tracks:\r\n")
+		for _, name := range s.Downloaded {
+			fmt.Fprintf(&body, "  %v\r\n", name)
+		}
+		body.WriteString("\r\n")
+	}
+	if len(s.Failed) > 0 {
+		fmt.Fprintf(&body, "Failed tracks:\r\n")
+		for _, e := range s.Failed {
+			fmt.Fprintf(&body, "  %v\r\n", e)
+		}
+	}
+	return body.Bytes()
+}
+
+func load() (state, error) {
+	var s state
+	data, err := ioutil.ReadFile(statePath())
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("couldn't read digest: %v", err)
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("couldn't unmarshal digest: %v", err)
+	}
+	return s, nil
+}
+
+func save(s state) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.StateDir(), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(statePath(), data, 0600)
+}
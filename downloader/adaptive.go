@@ -0,0 +1,105 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/logs"
+)
+
+// limiter limits the count of tracks downloaded at the same time and
+// tunes the limit in AIMD style: the limit is increased by one, while
+// throughput grows, and halved on network errors.
+type limiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+
+	// Throughput is measured in windows of limit finished tracks.
+	windowStart    time.Time
+	windowBytes    int64
+	windowTracks   int
+	lastThroughput float64
+}
+
+func newLimiter(initial int) *limiter {
+	l := &limiter{limit: initial, windowStart: time.Now()}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits, until track can be downloaded.
+func (l *limiter) acquire() {
+	l.mu.Lock()
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+	l.mu.Unlock()
+}
+
+// cancel releases the place acquired without downloading a track.
+func (l *limiter) cancel() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// release marks the download of track as finished and adjusts the limit
+// by its result. bytes is the size of downloaded track.
+func (l *limiter) release(bytes int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.cond.Broadcast()
+
+	l.active--
+	if err != nil {
+		if classify(err) == categoryNetwork {
+			l.setLimit(l.limit / 2)
+			l.resetWindow(0)
+		}
+		return
+	}
+
+	l.windowBytes += bytes
+	l.windowTracks++
+	if l.windowTracks < l.limit {
+		return
+	}
+
+	throughput := float64(l.windowBytes) / time.Since(l.windowStart).Seconds()
+	switch {
+	case throughput >= l.lastThroughput*0.9:
+		l.setLimit(l.limit + 1)
+	default:
+		// The last increase didn't help, so it's reverted.
+		l.setLimit(l.limit - 1)
+	}
+	l.resetWindow(throughput)
+}
+
+func (l *limiter) setLimit(n int) {
+	if n < 1 {
+		n = 1
+	}
+	if n > maxDownloadWorkers {
+		n = maxDownloadWorkers
+	}
+	if n != l.limit {
+		logs.INFO.Printf("downloading %v track(s) at the same time\n", n)
+	}
+	l.limit = n
+}
+
+func (l *limiter) resetWindow(throughput float64) {
+	l.windowStart = time.Now()
+	l.windowBytes = 0
+	l.windowTracks = 0
+	l.lastThroughput = throughput
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Some avatars are GIF.
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogem/id3v2"
+	"github.com/bogem/nehm/filelock"
+	"github.com/bogem/nehm/format"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/tags"
+	"github.com/bogem/nehm/track"
+	_ "golang.org/x/image/webp" // New artworks are often WebP.
+)
+
+// artworkFor returns the artwork of t and URLs of its sizes. It's the
+// first available of: artwork of track, avatar of uploader and
+// placeholderImage from config. If none of them is available,
+// artwork is empty, so track is tagged without it.
+func (downloader Downloader) artworkFor(dst []byte, t track.Track) ([]byte, []string) {
+	if urls := t.ArtworkURLs(); len(urls) > 0 {
+		artwork, err := fetchArtwork(dst, urls)
+		if err == nil {
+			return artwork, urls
+		}
+		logs.INFO.Printf("couldn't download artwork of %q: %v\n", t.Fullname(), err)
+	} else {
+		logs.INFO.Printf("%q has no artwork\n", t.Fullname())
+	}
+
+	if urls := t.AvatarURLs(); len(urls) > 0 {
+		avatar, err := fetchArtwork(dst, urls)
+		if err == nil {
+			logs.INFO.Printf("avatar of %v is used as artwork of %q\n", t.Uploader(), t.Fullname())
+			return avatar, urls
+		}
+		logs.INFO.Printf("couldn't download avatar of %v: %v\n", t.Uploader(), err)
+	} else {
+		logs.INFO.Printf("%v has no avatar\n", t.Uploader())
+	}
+
+	if downloader.placeholderImage != "" {
+		placeholder, err := ioutil.ReadFile(downloader.placeholderImage)
+		if err == nil {
+			logs.INFO.Printf("placeholderImage is used as artwork of %q\n", t.Fullname())
+			return placeholder, nil
+		}
+		logs.WARN.Println("couldn't read placeholderImage:", err)
+	}
+
+	logs.INFO.Printf("%q is tagged without artwork\n", t.Fullname())
+	return dst[:0], nil
+}
+
+// ReplaceArtwork fetches the artwork of t in the same way as on downloading
+// and embeds it to file at path instead of existing one. Audio and other
+// fields of tag are not changed.
+func (downloader Downloader) ReplaceArtwork(path string, t track.Track) error {
+	if downloader.archive {
+		return errors.New("artworks can't be replaced in archive mode")
+	}
+
+	artwork, urls := downloader.artworkFor(nil, t)
+	if downloader.generateArtwork && !t.HasArtwork() {
+		if placeholder, err := placeholderArtwork(artwork, t.Uploader(), t.Title()); err == nil {
+			artwork = placeholder
+		}
+	}
+	if len(artwork) > 0 {
+		var err error
+		if artwork, err = normalizeArtwork(artwork); err != nil {
+			return err
+		}
+	}
+	artwork = downloader.embeddedArtwork(t, artwork, urls)
+	if len(artwork) == 0 {
+		return errors.New("there is no artwork, which can be embedded")
+	}
+
+	f, err := format.SniffFile(path)
+	if err != nil {
+		return fmt.Errorf("couldn't detect format: %v", err)
+	}
+	if f != format.MP3 {
+		tagger := tags.For(f)
+		if tagger == nil {
+			return fmt.Errorf("artworks can't be embedded to %v files", f.Name)
+		}
+		return filelock.Do(path, func() error {
+			return tagger.WriteTags(path, tags.Metadata{Artwork: artwork})
+		})
+	}
+
+	return editTag(path, func(tag *id3v2.Tag) {
+		// Other pictures, e.g. waveform, are kept.
+		pictures := tag.GetFrames("APIC")
+		tag.DeleteFrames("APIC")
+		for _, f := range pictures {
+			if pf, ok := f.(id3v2.PictureFrame); ok && pf.PictureType != id3v2.PTFrontCover {
+				tag.AddAttachedPicture(pf)
+			}
+		}
+		tag.AddAttachedPicture(id3v2.PictureFrame{
+			Encoding:    downloader.tagEncoding,
+			MimeType:    artworkMIME(artwork),
+			PictureType: id3v2.PTFrontCover,
+			Picture:     artwork,
+		})
+	})
+}
+
+// SourceID returns the ID of track, which file at path was downloaded
+// from. It's looked up in index and, if there is no such file in index,
+// in tag of file.
+func SourceID(path string) (int, bool) {
+	if e, exists := index.GetByPath(path); exists {
+		return e.ID, true
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".mp3") {
+		return 0, false
+	}
+	e, ok := entryFromTag(path)
+	return e.ID, ok
+}
+
+// normalizeArtwork returns artwork in format, which can be embedded
+// to tags. JPEG and PNG are returned as is, other formats are converted
+// to JPEG, because players don't show them.
+func normalizeArtwork(artwork []byte) ([]byte, error) {
+	switch artworkMIME(artwork) {
+	case "image/jpeg", "image/png":
+		return artwork, nil
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(artwork))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported format of artwork (%v)", http.DetectContentType(artwork))
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("couldn't convert artwork from %v to JPEG: %v", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// artworkMIME returns the MIME type of artwork detected by its content.
+func artworkMIME(artwork []byte) string {
+	return http.DetectContentType(artwork)
+}
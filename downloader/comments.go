@@ -0,0 +1,91 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bogem/nehm/api"
+	"github.com/bogem/nehm/util"
+)
+
+// Formats of comments file.
+const (
+	commentsFormatJSON = "json"
+	commentsFormatText = "txt"
+)
+
+// timedComment is the comment left at the position of track.
+type timedComment struct {
+	Position string `json:"position"`
+	// Milliseconds is the position in milliseconds.
+	Milliseconds int    `json:"milliseconds"`
+	User         string `json:"user"`
+	Body         string `json:"body"`
+}
+
+// commentsPath returns the path of comments file for track at trackPath.
+func commentsPath(trackPath, format string) string {
+	return strings.TrimSuffix(trackPath, filepath.Ext(trackPath)) + ".comments." + format
+}
+
+// writeComments writes timed comments on track with id to the file next
+// to track at trackPath. Comments without position are skipped.
+func (downloader Downloader) writeComments(id int, trackPath string) error {
+	format := downloader.commentsFile
+	path := commentsPath(trackPath, format)
+	if _, err := os.Stat(path); err == nil && downloader.archive {
+		return nil
+	}
+
+	jComments, err := api.Comments(id)
+	if err != nil {
+		return err
+	}
+	var comments []timedComment
+	for _, c := range jComments {
+		if c.Timestamp == nil {
+			continue
+		}
+		comments = append(comments, timedComment{
+			Position:     util.DurationString(util.ParseDuration(*c.Timestamp)),
+			Milliseconds: *c.Timestamp,
+			User:         c.User.Username,
+			Body:         c.Body,
+		})
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].Milliseconds < comments[j].Milliseconds
+	})
+
+	var data []byte
+	if format == commentsFormatJSON {
+		data, err = json.MarshalIndent(comments, "", "\t")
+		if err != nil {
+			return err
+		}
+	} else {
+		var buf bytes.Buffer
+		for _, c := range comments {
+			fmt.Fprintf(&buf, "%v  %v: %v\n", c.Position, c.User, c.Body)
+		}
+		data = buf.Bytes()
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	return chown(path)
+}
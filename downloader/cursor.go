@@ -0,0 +1,105 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/track"
+)
+
+// startedAt is the time, when nehm was started. maxRuntime is counted
+// from it, so the time of fetching tracks is included.
+var startedAt = time.Now()
+
+// Cursor is the state of downloading stopped after maxRuntime.
+type Cursor struct {
+	StoppedAt time.Time `json:"stopped_at"`
+	// Remaining are IDs of tracks, which weren't downloaded yet.
+	Remaining []int `json:"remaining"`
+}
+
+func cursorPath() string {
+	return filepath.Join(config.SyncStateDir(), "cursor.json")
+}
+
+// runDeadline returns the time, after which new tracks are not started.
+// If maxRuntime is not set, it returns zero time.
+func runDeadline() (time.Time, error) {
+	value := config.Get("maxRuntime")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid maxRuntime %q: should be positive duration (e.g. 30m)", value)
+	}
+	return startedAt.Add(d), nil
+}
+
+// LoadCursor returns the cursor of previous stopped run
+// and whether it exists.
+func LoadCursor() (Cursor, bool, error) {
+	var c Cursor
+	data, err := ioutil.ReadFile(cursorPath())
+	if os.IsNotExist(err) {
+		return c, false, nil
+	}
+	if err != nil {
+		return c, false, fmt.Errorf("couldn't read cursor: %v", err)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, false, fmt.Errorf("couldn't unmarshal cursor: %v", err)
+	}
+	return c, true, nil
+}
+
+// saveCursor saves IDs of remaining tracks of this run. Remaining tracks
+// of previous cursor, which were not in tracks of this run (e.g. it was
+// get command, not sync), are kept. If there are no remaining tracks,
+// cursor is removed.
+func saveCursor(tracks, remaining []track.Track) error {
+	inRun := make(map[int]bool, len(tracks))
+	for _, t := range tracks {
+		inRun[t.ID()] = true
+	}
+
+	c := Cursor{StoppedAt: time.Now()}
+	for _, t := range remaining {
+		c.Remaining = append(c.Remaining, t.ID())
+	}
+	if previous, exists, _ := LoadCursor(); exists {
+		if len(remaining) == 0 {
+			c.StoppedAt = previous.StoppedAt
+		}
+		for _, id := range previous.Remaining {
+			if !inRun[id] {
+				c.Remaining = append(c.Remaining, id)
+			}
+		}
+	}
+
+	if len(c.Remaining) == 0 {
+		if err := os.Remove(cursorPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.SyncStateDir(), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cursorPath(), data, 0644)
+}
@@ -8,17 +8,37 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/bogem/id3v2"
-	"github.com/bogem/nehm/applescript"
-	"github.com/bogem/nehm/color"
+	"github.com/bogem/nehm/api"
+	"github.com/bogem/nehm/audit"
 	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/coord"
+	"github.com/bogem/nehm/digest"
+	"github.com/bogem/nehm/format"
+	"github.com/bogem/nehm/hls"
+	"github.com/bogem/nehm/hooks"
+	"github.com/bogem/nehm/httpclient"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/library"
 	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/manifest"
+	"github.com/bogem/nehm/mediaserver"
+	"github.com/bogem/nehm/mp3"
+	"github.com/bogem/nehm/postprocess"
+	"github.com/bogem/nehm/progress"
+	"github.com/bogem/nehm/tags"
+	"github.com/bogem/nehm/tempdir"
 	"github.com/bogem/nehm/track"
-	"github.com/valyala/fasthttp"
+	"github.com/bogem/nehm/util"
 )
 
 type Downloader struct {
@@ -27,135 +47,1457 @@ type Downloader struct {
 
 	// itunesPlaylist is the iTunes playlist, where tracks will be added.
 	itunesPlaylist string
+	// itunesPlaylistFolder is the folder, where itunesPlaylist
+	// is created, if it doesn't exist.
+	itunesPlaylistFolder string
+	// itunesBatch collects tracks, which are added to iTunes at once
+	// after all tracks are downloaded. It's nil, if itunesBatch is disabled.
+	itunesBatch *importBatch
+
+	// mpd adds downloaded tracks to MPD. It's nil, if mpdAddress isn't set.
+	mpd *mpdTarget
+	// mediaServer is the Plex or Jellyfin server, which library
+	// is scanned after downloading. It's nil, if it isn't set.
+	mediaServer *mediaserver.Server
+
+	// coverFile is the name of file (e.g. cover.jpg), where artwork will be
+	// saved in the folder of track. If it's blank, artwork is only embedded.
+	coverFile string
+
+	// organizeBy is the way to organize tracks in subfolders of dist.
+	// If it's blank, all tracks are downloaded directly to dist.
+	organizeBy string
+
+	// saveArtistImage is used to save uploader's avatar as artist.jpg
+	// in the folder of uploader.
+	saveArtistImage bool
+
+	// failFast is used to abort downloading on first error.
+	failFast bool
+
+	// importOnly is used to download tracks to temporary folder and
+	// delete them after adding to iTunes, which copies them to its library.
+	importOnly bool
+
+	// uploadTo is the folder (e.g. mounted NAS or cloud drive), where
+	// downloaded tracks are copied to. If it's blank, tracks aren't copied.
+	uploadTo string
+
+	// moveAfterUpload is used to remove local copy of track
+	// after successful upload, so dist is only a staging area.
+	moveAfterUpload bool
+
+	// fileTime is the time set as modification time of track file.
+	// If it's blank, the time of downloading is kept.
+	fileTime string
+
+	// linkMode is the way to reuse already downloaded copy of track,
+	// if it should be downloaded to another path: "hardlink" or "reflink".
+	// If it's blank, track is downloaded again.
+	linkMode string
+
+	// writeManifest is used to write manifest with SHA-256 hashes
+	// of downloaded tracks to dist after downloading.
+	writeManifest bool
+
+	// tagEncoding is the encoding of text frames in ID3 tag.
+	tagEncoding id3v2.Encoding
+
+	// id3Version is the version of written ID3v2 tags: 3 or 4.
+	id3Version byte
+
+	// tagLanguage is the language of tracks (ISO-639-2 code),
+	// which is written to TLAN frame. If it's blank, TLAN is not written.
+	tagLanguage string
+
+	// generateArtwork is used to generate placeholder artwork with title
+	// for tracks without artwork.
+	generateArtwork bool
+
+	// detectLanguage is used to detect the language of track by its title
+	// and description. It's written to TLAN frame, if tagLanguage is blank,
+	// and to grouping (TIT1 frame), so tracks can be split in smart playlists.
+	detectLanguage bool
+
+	// commentsFile is the format ("json" or "txt") of file with timed
+	// comments, which is written next to track. If it's blank,
+	// comments are not exported.
+	commentsFile string
+
+	// waveform is the way to save waveform of track: "png" or "json"
+	// file next to track or "embed" to tag. If it's blank,
+	// waveform is not saved.
+	waveform string
+
+	// archive is used to never modify or delete existing files.
+	// Tracks are only tagged, when they're written first time.
+	archive bool
+
+	// postProcessors change artist, title and path of tracks
+	// before they're written.
+	postProcessors []postprocess.Processor
+
+	// album is the name of album, which is written to tags of tracks
+	// with continuous track numbers. If it's blank, tracks are
+	// not tagged as a part of album.
+	album string
+
+	// trims are the rules to trim intros and outros of tracks
+	// by uploader's permalink or username.
+	trims map[string]trim
+
+	// music are the properties set to tracks added to iTunes.
+	music musicSettings
+
+	// redownload is used to download tracks, which are
+	// already in index, again.
+	redownload bool
+
+	// dryRun is used to only print, which tracks would be downloaded.
+	dryRun bool
+
+	// folderTemplate is the template of subfolders of download folder,
+	// where tracks are downloaded. It overrides organizeBy.
+	folderTemplate *template.Template
+
+	// hooks are shell commands run after downloading.
+	hooks hooks.Hooks
+
+	// playlistFile is the M3U8 playlist, to which downloaded tracks
+	// are appended after batch. Relative path is relative to dist.
+	playlistFile string
+
+	// playlistBuilder is the target of playlist, which can be built
+	// from downloaded tracks after batch: m3u, itunes or both.
+	playlistBuilder string
+
+	// disabledFrames are the optional frames, which
+	// aren't written to tags.
+	disabledFrames map[string]bool
+
+	// tagComment is the text of COMM frame. By default
+	// it's the URL of track on SoundCloud.
+	tagComment string
+
+	// positions are the numbers of tracks in playlist, which is
+	// downloaded as album. They're used as track numbers.
+	positions map[int]int
+
+	// embedDescription is used to write the description of track
+	// as unsynchronised lyrics (USLT frame).
+	embedDescription bool
+
+	// quality is the quality of streams: low, standard or high.
+	// If it's blank, default stream is downloaded.
+	quality string
+	// embedArtwork is false, if artwork shouldn't be embedded to tags.
+	// coverFile is written anyway. Default is true.
+	embedArtwork bool
+	// artworkMaxBytes is the maximal size of embedded artwork.
+	// If it's 0, size is not limited.
+	artworkMaxBytes int64
+
+	// label is the label of downloaded tracks in index, audit log
+	// and digest.
+	label string
+
+	// placeholderImage is the path to image, which is used as artwork,
+	// if track has no artwork and uploader has no avatar.
+	placeholderImage string
+
+	// skipFrameCheck disables the check of MPEG frames
+	// of downloaded tracks.
+	skipFrameCheck bool
+
+	// encoding is the preset of transcoding of downloading track,
+	// if it was selected by quality.
+	encoding string
+
+	// preferOriginal is used to download the original file of track
+	// (e.g. WAV or FLAC), if uploader allowed it, instead of stream.
+	preferOriginal bool
+
+	// mergeTags is used to keep frames of tag of already downloaded
+	// file, so manual edits aren't lost on downloading track again.
+	mergeTags bool
+
+	// batch is the date, when DownloadAll was started.
+	// It's written to tags as the provenance of tracks.
+	batch string
+
+	// paths are the paths by IDs, where tracks were written in batch.
+	paths map[int]string
 }
 
+const (
+	fileTimeUploaded = "uploaded"
+
+	organizeByDate     = "date"
+	organizeByUploader = "uploader"
+
+	artistImageFile = "artist.jpg"
+)
+
 func NewConfiguredDownloader() *Downloader {
 	return &Downloader{
-		dist:           config.Get("dlFolder"),
-		itunesPlaylist: config.Get("itunesPlaylist"),
+		dist:                 config.Get("dlFolder"),
+		itunesPlaylist:       config.Get("itunesPlaylist"),
+		itunesPlaylistFolder: config.Get("itunesPlaylistFolder"),
+		itunesBatch:          importBatchFromConfig(),
+		mpd:                  mpdTargetFromConfig(),
+		mediaServer:          mediaserver.FromConfig(),
+		coverFile:            config.Get("coverFile"),
+		organizeBy:           config.Get("organizeBy"),
+		saveArtistImage:      config.GetBool("saveArtistImage"),
+		failFast:             config.GetBool("failFast"),
+		importOnly:           config.GetBool("importOnly"),
+		uploadTo:             config.Get("uploadTo"),
+		moveAfterUpload:      config.GetBool("moveAfterUpload"),
+		fileTime:             config.Get("fileTime"),
+		linkMode:             config.Get("linkMode"),
+		writeManifest:        config.GetBool("writeManifest"),
+		tagEncoding:          configuredTagEncoding(),
+		id3Version:           configuredID3Version(),
+		tagLanguage:          config.Get("tagLanguage"),
+		detectLanguage:       config.GetBool("detectLanguage"),
+		generateArtwork:      config.GetBool("generateArtwork"),
+		commentsFile:         config.Get("commentsFile"),
+		waveform:             config.Get("waveform"),
+		archive:              config.GetBool("archive"),
+		album:                config.Get("album"),
+		postProcessors:       postprocess.FromConfig(),
+		trims:                trimsFromConfig(),
+		music:                musicSettingsFromConfig(),
+		redownload:           config.GetBool("redownload"),
+		dryRun:               config.GetBool("dryRun"),
+		folderTemplate:       folderTemplateFromConfig(),
+		hooks:                hooks.FromConfig(),
+		playlistBuilder:      config.Get("playlistBuilder"),
+		playlistFile:         config.Get("playlistFile"),
+		disabledFrames:       disabledFramesFromConfig(),
+		tagComment:           config.Get("tagComment"),
+		mergeTags:            config.GetBool("mergeTags"),
+		embedDescription:     config.GetBool("embedDescription"),
+		preferOriginal:       config.GetBool("preferOriginal"),
+		quality:              qualityFromConfig(),
+		embedArtwork:         config.Get("embedArtwork") == "" || config.GetBool("embedArtwork"),
+		artworkMaxBytes:      artworkMaxBytesFromConfig(),
+		skipFrameCheck:       config.GetBool("skipFrameCheck"),
+		label:                config.Get("label"),
+		placeholderImage:     util.SanitizePath(config.Get("placeholderImage")),
+	}
+}
+
+// TrackPath returns the path, where t will be downloaded. If t was
+// downloaded before, the same filename is used. If other track
+// was already downloaded to this path (e.g. fileNameTemplate has only
+// title), ID of t is added to the filename.
+func (downloader Downloader) TrackPath(t track.Track) string {
+	dir := downloader.trackDir(t)
+	if e, exists := index.Get(t.ID()); exists {
+		if e.Filename != "" {
+			return filepath.Join(dir, e.Filename)
+		}
+		// Entries of older versions have no filename.
+		if filepath.Dir(e.Path) == dir {
+			return e.Path
+		}
+	}
+
+	path := filepath.Join(dir, t.Filename())
+	if e, exists := index.GetByPath(path); exists && e.ID != t.ID() {
+		path = withID(path, t.ID())
+	}
+	return path
+}
+
+// reservedPaths are the IDs of tracks by paths, where they're downloading
+// now. Tracks aren't in index until they're downloaded, so tracks
+// of parallel batch with the same name would share temporary files.
+var (
+	pathsMu       sync.Mutex
+	reservedPaths = make(map[string]int)
+)
+
+// reservePath reserves path for track with id. If path is reserved
+// by other track, ID is added to it. It returns the reserved path
+// and the function, which releases it.
+func reservePath(path string, id int) (string, func()) {
+	pathsMu.Lock()
+	defer pathsMu.Unlock()
+
+	if other, reserved := reservedPaths[path]; reserved && other != id {
+		path = withID(path, id)
+	}
+	reservedPaths[path] = id
+	return path, func() {
+		pathsMu.Lock()
+		defer pathsMu.Unlock()
+		if reservedPaths[path] == id {
+			delete(reservedPaths, path)
+		}
 	}
 }
 
-func (downloader Downloader) DownloadAll(tracks []track.Track) {
+// withID returns path with id before extension, e.g. "Title [123].mp3".
+func withID(path string, id int) string {
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	return filepath.Join(filepath.Dir(path), util.LimitFilename(name+" ["+strconv.Itoa(id)+"]", ext))
+}
+
+// trackDir returns the folder, where t will be downloaded.
+func (downloader Downloader) trackDir(t track.Track) string {
+	if downloader.folderTemplate != nil {
+		return downloader.templateDir(t)
+	}
+
+	switch downloader.organizeBy {
+	case organizeByDate:
+		createdAt := t.CreatedAt()
+		if createdAt.IsZero() {
+			return downloader.dist
+		}
+		return filepath.Join(downloader.dist, createdAt.Format("2006"), createdAt.Format("01"))
+	case organizeByUploader:
+		return filepath.Join(downloader.dist, util.LimitFilename(util.SanitizeFilename(t.Uploader()), ""))
+	default:
+		return downloader.dist
+	}
+}
+
+// DownloadAll downloads tracks. It returns ErrAuthAborted or ErrFailFast,
+// if downloading was aborted, so command can exit with the right code
+// after cleanup. Other errors are reported and don't stop downloading.
+func (downloader Downloader) DownloadAll(tracks []track.Track) error {
 	if len(tracks) == 0 {
 		logs.FATAL.Println("there are no tracks to download")
 	}
 
+	var skipped []track.Track
+	if !downloader.redownload {
+		var kept []track.Track
+		kept, skipped = downloader.skipDownloaded(tracks)
+		if len(skipped) > 0 && !downloader.dryRun {
+			logs.FEEDBACK.Printf("Skipping %v already downloaded track(s). Use --force to download them again.\n", len(skipped))
+		}
+		tracks = kept
+	}
+
+	if downloader.dryRun {
+		downloader.printPlan(tracks, skipped)
+		return nil
+	}
+	if len(tracks) == 0 {
+		return nil
+	}
+
+	if downloader.uploadTo != "" {
+		downloader.uploadTo = util.SanitizePath(downloader.uploadTo)
+	}
+
+	if downloader.fileTime != "" && downloader.fileTime != fileTimeUploaded {
+		logs.FATAL.Fatalf("invalid fileTime %q. Only %q is supported.\n", downloader.fileTime, fileTimeUploaded)
+	}
+
+	if f := downloader.commentsFile; f != "" && f != commentsFormatJSON && f != commentsFormatText {
+		logs.FATAL.Fatalf("invalid commentsFile %q. Use %q or %q.\n", f, commentsFormatJSON, commentsFormatText)
+	}
+
+	switch downloader.waveform {
+	case "", waveformPNG, waveformJSON, waveformEmbed:
+	default:
+		logs.FATAL.Fatalf("invalid waveform %q. Use %q, %q or %q.\n", downloader.waveform, waveformPNG, waveformJSON, waveformEmbed)
+	}
+
+	switch downloader.playlistBuilder {
+	case "", playlistM3U, playlistItunes, playlistBoth:
+	default:
+		logs.FATAL.Fatalf("invalid playlistBuilder %q. Use %q, %q or %q.\n", downloader.playlistBuilder, playlistM3U, playlistItunes, playlistBoth)
+	}
+	if downloader.playlistBuilder != "" && downloader.playlistBuilder != playlistM3U && !library.Supported() {
+		logs.FATAL.Fatalln("iTunes playlists can't be built on this OS. Set playlistBuilder to m3u.")
+	}
+
+	if downloader.archive && downloader.moveAfterUpload {
+		logs.WARN.Println("moveAfterUpload is ignored in archive mode")
+		downloader.moveAfterUpload = false
+	}
+
+	downloader.batch = time.Now().Format(batchLayout)
+	downloader.paths = make(map[int]string, len(tracks))
+
+	deadline, err := runDeadline()
+	if err != nil {
+		logs.FATAL.Fatalln(err)
+	}
+
+	workers, adaptive := downloadWorkersFromConfig()
+	if workers > 1 && config.GetBool("editMetadata") {
+		logs.WARN.Println("tracks are downloaded one by one, because metadata is edited interactively")
+		workers, adaptive = 1, false
+	}
+	var lim *limiter
+	if adaptive {
+		lim = newLimiter(initialAdaptiveWorkers)
+	}
+
+	if downloader.importOnly && downloader.itunesBatch != nil {
+		logs.WARN.Println("itunesBatch is ignored in importOnly mode, tracks are added to iTunes one by one")
+		downloader.itunesBatch = nil
+	}
+	if downloader.importOnly && downloader.mpd != nil {
+		logs.WARN.Println("mpdAddress is ignored in importOnly mode, tracks are only added to iTunes")
+		downloader.mpd = nil
+	}
+	if downloader.importOnly {
+		if downloader.itunesPlaylist == "" {
+			logs.FATAL.Fatalln("importOnly mode needs an iTunes playlist. Use flag '-i' or set itunesPlaylist in config file.")
+		}
+		tmpDir, err := tempdir.TempDir("import")
+		if err != nil {
+			logs.FATAL.Fatalln("couldn't create temporary folder:", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		downloader.dist = tmpDir
+	}
+
+	if downloader.itunesPlaylist != "" {
+		err := library.Default.CheckAndCreatePlaylist(downloader.itunesPlaylist, downloader.itunesPlaylistFolder)
+		if library.Default.IsBusy(err) {
+			logs.WARN.Println("iTunes is busy, so playlist couldn't be checked:", err)
+		} else if err != nil {
+			logs.FATAL.Fatalf("couldn't create iTunes playlist %q: %v\n", downloader.itunesPlaylist, err)
+		}
+	}
+
 	var errors []string
-	// Start with last track.
-	for i := len(tracks) - 1; i >= 0; i-- {
-		track := tracks[i]
-		err := downloader.download(track)
+	var failures []failure
+	var failed []track.Track
+	total := newTimings()
+	var downloaded []string
+	succeeded := make(map[int]bool, len(tracks))
+	progress.Emit(progress.Event{Type: progress.BatchStart, Total: len(tracks)})
+	var remaining []track.Track
+	jobs := make(chan job)
+	abort := make(chan struct{})
+	var aborted bool
+	stop := func() {
+		if !aborted {
+			close(abort)
+			aborted = true
+		}
+	}
+	maxAuth := maxAuthFailuresFromConfig()
+	// authFailed are the tracks failed with 401 or 403 in a row.
+	var authFailed []int
+	var authAborted bool
+	go func() {
+		defer close(jobs)
+		// Start with last track.
+		for i := len(tracks) - 1; i >= 0; i-- {
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				remaining = tracks[:i+1]
+				logs.FEEDBACK.Printf("Max runtime is reached. %v track(s) will be downloaded in the next run.\n", len(remaining))
+				return
+			}
+
+			// Track can be downloaded by another nehm process right now.
+			if !coord.Claim(tracks[i].ID(), downloader.redownload) {
+				logs.FEEDBACK.Printf("Skipping %q: it's downloaded by another nehm process\n", tracks[i].Fullname())
+				continue
+			}
+			j := job{track: tracks[i], event: progress.Event{
+				ID:    tracks[i].ID(),
+				Track: tracks[i].Fullname(),
+				Index: len(tracks) - i,
+				Total: len(tracks),
+			}}
+			select {
+			case jobs <- j:
+			case <-abort:
+				coord.Release(j.track.ID(), nil)
+				// Tracks, which weren't started, stay in cursor.
+				remaining = tracks[:i+1]
+				return
+			}
+		}
+	}()
+
+	// Results are processed in this goroutine only.
+	for r := range downloader.startWorkers(workers, lim, jobs) {
+		track, event, err := r.track, r.event, r.err
+		total.merge(r.tm)
+		event.Stages = r.tm.seconds()
+		logs.INFO.Printf("timings of %q: %v\n", track.Fullname(), r.tm)
 		if err != nil {
+			event.Type = progress.TrackError
+			event.Error = err.Error()
+			progress.Emit(event)
+
+			if _, ok := err.(unavailableError); ok {
+				index.MarkUnavailable(track.ID(), track.Fullname(), err.Error())
+			}
+			if isAuthError(err) {
+				authFailed = append(authFailed, track.ID())
+			} else {
+				authFailed = authFailed[:0]
+			}
+			if maxAuth > 0 && len(authFailed) >= maxAuth && !authAborted {
+				authAborted = true
+				// Tracks aren't unavailable, nehm isn't authorized.
+				for _, id := range authFailed {
+					index.MarkAvailable(id)
+				}
+				stop()
+			}
+
 			errors = append(errors, track.Fullname()+": "+err.Error())
-			logs.FEEDBACK.Println("✘")
+			failures = append(failures, failure{track.Fullname(), err})
+			failed = append(failed, track)
+			r.status.done(false)
 			logs.ERROR.Printf("error while downloading %q: %v", track.Fullname(), err)
+			// Tracks, which are already downloading, are finished.
+			if downloader.failFast && len(failed) == 1 {
+				stop()
+			}
+		} else {
+			authFailed = authFailed[:0]
+			succeeded[track.ID()] = true
+			index.MarkAvailable(track.ID())
+			event.Type = progress.TrackDone
+			if e, exists := index.Get(track.ID()); exists {
+				// Track, which was only linked, is in index with path
+				// of its first copy.
+				e.Path = r.path
+				downloader.paths[track.ID()] = r.path
+				downloaded = append(downloaded, e.Path)
+				if fi, err := os.Stat(e.Path); err == nil {
+					event.Bytes = fi.Size()
+					event.BytesPerSecond = float64(fi.Size()) / time.Since(r.start).Seconds()
+				}
+				downloader.hooks.Run(hooks.AfterTrack, trackHookEnv(e, track))
+			}
+			progress.Emit(event)
+			r.status.done(true)
+		}
+	}
+
+	progress.Emit(progress.Event{Type: progress.BatchDone, Total: len(tracks), Failed: len(failed), Stages: total.seconds()})
+
+	if err := index.Save(); err != nil {
+		logs.ERROR.Println("couldn't save the index of downloaded tracks:", err)
+	}
+	if err := saveCursor(tracks, remaining); err != nil {
+		logs.ERROR.Println("couldn't save cursor:", err)
+	}
+	if err := updateFailedTracks(failed, succeeded); err != nil {
+		logs.ERROR.Println("couldn't save the list of failed tracks:", err)
+	}
+	var names []string
+	var succeededTracks []track.Track
+	for _, t := range tracks {
+		if succeeded[t.ID()] {
+			name := t.Fullname()
+			if downloader.label != "" {
+				name += " [" + downloader.label + "]"
+			}
+			names = append(names, name)
+			succeededTracks = append(succeededTracks, t)
+		}
+	}
+	if err := digest.Add(names, errors); err != nil {
+		logs.ERROR.Println("couldn't add tracks to digest:", err)
+	}
+	if downloader.writeManifest && len(downloaded) > 0 && !downloader.importOnly {
+		path := filepath.Join(downloader.dist, "nehm-"+time.Now().Format("20060102-150405")+".sha256")
+		if err := manifest.Write(path, downloaded); err != nil {
+			logs.ERROR.Println("couldn't write manifest:", err)
+		} else {
+			logs.FEEDBACK.Println("Manifest is written to", path)
+		}
+	}
+
+	if downloader.itunesBatch != nil {
+		failures = append(failures, downloader.addBatchToItunes()...)
+	}
+	if downloader.itunesPlaylist != "" {
+		if pending, err := loadPendingImports(); err == nil && len(pending) > 0 {
+			logs.WARN.Printf("%v track(s) weren't added to iTunes, because it was busy. Run 'nehm import-pending' later.\n", len(pending))
+		}
+	}
+
+	downloader.hooks.Run(hooks.AfterAll, map[string]string{
+		"folder":     downloader.dist,
+		"downloaded": strconv.Itoa(len(downloaded)),
+		"failed":     strconv.Itoa(len(failed)),
+	})
+
+	if downloader.mediaServer != nil && len(downloaded) > 0 && !downloader.importOnly {
+		if err := downloader.mediaServer.Refresh(); err != nil {
+			logs.ERROR.Printf("couldn't ask %v to scan library: %v\n", downloader.mediaServer.Name(), err)
 		} else {
-			logs.FEEDBACK.Println("✔︎")
+			logs.INFO.Printf("%v is scanning library section %v\n", downloader.mediaServer.Name(), downloader.mediaServer.Section)
 		}
 	}
 
-	if len(errors) > 0 && len(tracks) > 1 {
-		logs.FEEDBACK.Println("\n" + color.RedString("There were errors while downloading tracks:"))
-		for _, err := range errors {
-			logs.FEEDBACK.Println(err)
+	if downloader.playlistFile != "" && len(succeededTracks) > 0 {
+		if downloader.importOnly {
+			logs.WARN.Println("playlist file isn't written in importOnly mode")
+		} else {
+			downloader.appendPlaylistFile(succeededTracks)
 		}
-		logs.FEEDBACK.Println()
 	}
+
+	if downloader.playlistBuilder != "" && len(succeededTracks) > 0 {
+		downloader.buildPlaylist(succeededTracks)
+	}
+
+	if authAborted {
+		logs.ERROR.Printf("downloading was aborted, because %v tracks in a row were forbidden (HTTP 401/403).\n", maxAuth)
+		logs.ERROR.Println("client_id of nehm has probably expired or login is required. Check oauthToken in config or update nehm.")
+	}
+
+	if config.GetBool("showTimings") {
+		logs.FEEDBACK.Println("\nTime spent in stages:", total)
+	}
+
+	if len(failures) > 0 && len(tracks) > 1 {
+		printFailures(failures)
+	}
+
+	if authAborted {
+		return ErrAuthAborted
+	}
+	if downloader.failFast && len(errors) > 0 {
+		logs.ERROR.Println("downloading was aborted because of the error (fail fast mode)")
+		return ErrFailFast
+	}
+	return nil
 }
 
-// artworks and trackBuf are used for reusing memory while downloading artworks and tracks.
-var (
-	artworkBuf []byte
-	trackBuf   []byte
-)
+// trackHookEnv returns the environment of afterTrack hook
+// for downloaded track t with index entry e.
+func trackHookEnv(e index.Entry, t track.Track) map[string]string {
+	return map[string]string{
+		"path":          e.Path,
+		"artist":        e.Artist,
+		"title":         e.Title,
+		"album":         e.Album,
+		"genre":         e.Genre,
+		"id":            strconv.Itoa(t.ID()),
+		"uploader":      t.Uploader(),
+		"permalink_url": t.PermalinkURL(),
+	}
+}
 
-func (downloader Downloader) download(t track.Track) error {
+// download downloads t, measures its stages with tm and prints
+// them to st. bufs are reused between tracks of one worker.
+// It returns the path, where t was written. It differs from the path
+// in index, if t was only linked to another folder.
+func (downloader Downloader) download(t track.Track, tm *timings, st *status, bufs *buffers) (string, error) {
 	artworkURL := t.ArtworkURL()
+	if artworkURL == "" {
+		artworkURL = t.AvatarURL()
+	}
 	url := t.URL()
+	var originalURL string
+	if downloader.preferOriginal {
+		originalURL = t.FreeDownloadURL()
+	}
+	// Originals are better than any stream, so quality is used
+	// only, if stream is downloaded. downloader is a copy,
+	// so encoding is only changed for t.
+	var isHLS bool
+	if originalURL == "" {
+		downloader.encoding, isHLS = downloader.streamPreset(t, &url)
+	}
 
 	logs.INFO.Printf("Downloading track from %q\n", url)
 	logs.INFO.Printf("Downloading artwork from %q\n", artworkURL)
-	logs.FEEDBACK.Printf("Downloading %q ... ", t.Fullname())
+	st.start(t.Fullname())
 
-	if url == "" {
-		return errors.New("track is not downloadable")
+	if url == "" && originalURL == "" {
+		return "", unavailableError{reason: "track is not downloadable"}
 	}
 
 	// Create track file.
-	trackPath := filepath.Join(downloader.dist, t.Filename())
-	trackFile, e := os.Create(trackPath)
+	trackPath := downloader.TrackPath(t)
+	if len(downloader.postProcessors) > 0 {
+		var e error
+		// downloader is a copy, so album is only changed for t.
+		if trackPath, downloader.album, e = downloader.postProcess(&t, trackPath); e != nil {
+			return "", classified(categoryPostProcess, fmt.Errorf("couldn't post-process track: %v", e))
+		}
+	}
+	if !util.IsWithin(downloader.dist, trackPath) {
+		return "", classified(categoryFilesystem, fmt.Errorf("refusing to write %q outside of download folder", trackPath))
+	}
+	trackPath, release := reservePath(trackPath, t.ID())
+	defer release()
+	if e := mkdirAll(filepath.Dir(trackPath)); os.IsPermission(e) {
+		return "", classified(categoryFilesystem, fmt.Errorf("there is no permission to create folder %q. Check permissions of download folder", filepath.Dir(trackPath)))
+	} else if e != nil {
+		return "", classified(categoryFilesystem, fmt.Errorf("couldn't create folder for track: %v", e))
+	}
+
+	if _, e := os.Stat(trackPath); e == nil && downloader.archive {
+		return "", classified(categoryFilesystem, fmt.Errorf("%q already exists and can't be overwritten in archive mode", trackPath))
+	}
+
+	// Reuse already downloaded copy of track. Links are recorded
+	// in the entry of track, so they aren't made again in next runs.
+	if downloader.linkMode != "" {
+		if entry, exists := index.Get(t.ID()); exists && entry.Path != trackPath {
+			if _, e := os.Stat(entry.Path); e == nil {
+				st.print("linking to downloaded copy ... ")
+				if e := linkFile(downloader.linkMode, entry.Path, trackPath); e != nil {
+					return "", classified(categoryFilesystem, e)
+				}
+				if e := chown(trackPath); e != nil {
+					logs.WARN.Printf("couldn't change owner of %q: %v\n", trackPath, e)
+				}
+				if !entry.HasLink(trackPath) {
+					entry.Links = append(entry.Links, trackPath)
+					index.Add(entry)
+				}
+				return trackPath, nil
+			}
+		}
+	}
+	var existingFrames map[string][]id3v2.Framer
+	if _, e := os.Stat(trackPath); e == nil {
+		audit.Log(audit.Overwrite, trackPath, "track was downloaded again")
+		if downloader.mergeTags {
+			existingFrames = readFrames(trackPath)
+		}
+	}
+	// Track is written to temporary file, which is renamed to trackPath
+	// only after it's completely downloaded and tagged.
+	tmpPath := trackPath + tempdir.TmpSuffix
+	trackFile, e := os.Create(tmpPath)
 	if e != nil {
-		return fmt.Errorf("couldn't create track file: %v", e)
+		return "", classified(categoryFilesystem, fmt.Errorf("couldn't create track file: %v", e))
+	}
+	var finished bool
+	defer func() {
+		trackFile.Close()
+		if !finished {
+			os.Remove(tmpPath)
+		}
+	}()
+	if e := chown(tmpPath); e != nil {
+		logs.WARN.Printf("couldn't change owner of %q: %v\n", trackPath, e)
 	}
 
 	// err lets us to not prevent the processing of track further.
 	// err will only be returned at the end of this function.
 	var err error
 
+	trackNumber := downloader.trackNumber(t)
+
 	// Parallelize downloading of track and artwork.
+	// artworkErr and tagged are set only by goroutine of artwork and they're
+	// read after wg.Wait(). Goroutine must be finished before return,
+	// because it uses trackFile and bufs.
+	var artworkErr error
+	var tagged bool
+	// embedded is the artwork embedded to tag.
+	var embedded []byte
 	var wg sync.WaitGroup
 	wg.Add(1)
+	defer wg.Wait()
 
 	go func() {
 		defer wg.Done()
 
 		// Download artwork.
-		artworkBuf = artworkBuf[:0]
-		_, artworkBuf, e = fasthttp.Get(artworkBuf, artworkURL)
-		if e != nil {
-			err = fmt.Errorf("couldn't download artwork file: %v", e)
-			return
+		start := time.Now()
+		artworkBuf, artworkURLs := downloader.artworkFor(bufs.artwork[:0], t)
+		bufs.artwork = artworkBuf
+		tm.measure(stageArtwork, start)
+		if downloader.generateArtwork && !t.HasArtwork() {
+			placeholder, e := placeholderArtwork(artworkBuf, t.Uploader(), t.Title())
+			if e == nil {
+				artworkBuf = placeholder
+			} else {
+				logs.WARN.Println("couldn't generate artwork:", e)
+			}
+		}
+		if len(artworkBuf) > 0 {
+			// SoundCloud serves some artworks in PNG, GIF or WebP.
+			if normalized, e := normalizeArtwork(artworkBuf); e == nil {
+				artworkBuf = normalized
+			} else {
+				logs.WARN.Printf("artwork of %q is skipped: %v\n", t.Fullname(), e)
+				artworkBuf = nil
+			}
+		}
+
+		var waveform []byte
+		if downloader.waveform == waveformEmbed {
+			if url := waveformURL(t, waveformPNG); url != "" {
+				var we error
+				if waveform, we = fetchWaveform(url); we != nil {
+					logs.WARN.Printf("couldn't download waveform of %q: %v\n", t.Fullname(), we)
+				}
+			}
 		}
 
 		// Write ID3 tag to trackFile.
-		if e := writeTagToWriter(t, trackFile, artworkBuf); e != nil {
-			err = fmt.Errorf("there was an error while tagging track: %v", e)
+		start = time.Now()
+		embedded = downloader.embeddedArtwork(t, artworkBuf, artworkURLs)
+		if e := downloader.writeTag(t, trackNumber, trackFile, embedded, waveform, existingFrames); e != nil {
+			artworkErr = classified(categoryTag, fmt.Errorf("there was an error while tagging track: %v", e))
+		} else {
+			tagged = true
+		}
+		tm.measure(stageTag, start)
+
+		start = time.Now()
+		defer tm.measure(stageArtwork, start)
+
+		// Save artwork in the folder of track.
+		if downloader.coverFile != "" {
+			if e := writeCoverFile(filepath.Dir(trackPath), downloader.coverFile, artworkBuf); e != nil && artworkErr == nil {
+				artworkErr = classified(categoryFilesystem, fmt.Errorf("couldn't save artwork to file: %v", e))
+			}
+		}
+
+		// Save uploader's avatar in the folder of uploader.
+		if downloader.saveArtistImage && downloader.organizeBy == organizeByUploader {
+			if e := writeArtistImage(filepath.Dir(trackPath), t.AvatarURLs()); e != nil && artworkErr == nil {
+				artworkErr = classified(categoryNetwork, fmt.Errorf("couldn't save artist image: %v", e))
+			}
 		}
 	}()
 
 	// Download track.
-	trackBuf = trackBuf[:0]
-	_, trackBuf, e = fasthttp.Get(trackBuf, url)
+	start := time.Now()
+	// Stream is kept in part file in tmpDir, so interrupted download
+	// can be resumed in the next run. Part files are named by ID,
+	// because path of track can change between runs.
+	partName := strconv.Itoa(t.ID())
+	if downloader.encoding != "" {
+		// Streams in other encodings can't be resumed from each other.
+		partName += "." + downloader.encoding
+	}
+	partPath, e := tempdir.PartPath(partName)
+	if e != nil {
+		return "", classified(categoryFilesystem, fmt.Errorf("couldn't create folder of partial downloads: %v", e))
+	}
+	var statusCode int
+	if originalURL != "" {
+		// Original has its own part file, because it differs from stream.
+		var originalPartPath string
+		if originalPartPath, e = tempdir.PartPath(partName + originalSuffix); e != nil {
+			return "", classified(categoryFilesystem, fmt.Errorf("couldn't create folder of partial downloads: %v", e))
+		}
+		logs.INFO.Printf("Downloading original (%v) from %q\n", t.OriginalFormat(), originalURL)
+		statusCode, e = httpclient.DownloadFile(originalPartPath, originalURL, progressFunc(t))
+		if e == nil && checkStatusCode(statusCode) == nil {
+			partPath = originalPartPath
+		} else if url != "" {
+			logs.INFO.Printf("couldn't download original of %q (HTTP %v, %v), downloading stream\n", t.Fullname(), statusCode, e)
+			// Part of original is kept after network errors,
+			// so it can be resumed in the next run.
+			if e == nil {
+				os.Remove(originalPartPath)
+			}
+			originalURL = ""
+		}
+	}
+	if originalURL == "" && isHLS {
+		// Segments are joined into one file, which can't be resumed.
+		workers := hls.DefaultWorkers
+		if httpclient.Polite() {
+			workers = 1
+		}
+		statusCode, e = hls.Download(partPath, url, workers, progressFunc(t))
+	} else if originalURL == "" {
+		statusCode, e = httpclient.DownloadFile(partPath, url, progressFunc(t))
+	}
+	tm.measure(stageDownload, start)
 	if e != nil {
-		return fmt.Errorf("couldn't download track: %v", e)
+		return "", classified(categoryNetwork, fmt.Errorf("couldn't download track: %v", e))
+	}
+	if e := checkStatusCode(statusCode); e != nil {
+		return "", e
+	}
+
+	// Originals are sometimes not MP3, though their names are.
+	f, e := format.SniffFile(partPath)
+	if e != nil {
+		logs.WARN.Printf("couldn't detect format of %q: %v\n", t.Fullname(), e)
+	}
+	isMP3 := f == format.MP3 || f == format.Unknown
+	if isMP3 && !downloader.skipFrameCheck {
+		if e := mp3.Validate(partPath); e != nil {
+			// Corrupt part can't be resumed.
+			os.Remove(partPath)
+			return "", classified(categoryNetwork, fmt.Errorf("downloaded track is corrupt: %v", e))
+		}
 	}
 
 	wg.Wait()
+	err = artworkErr
+	if !tagged && isMP3 {
+		return "", err
+	}
+
+	start = time.Now()
+	if !isMP3 {
+		// ID3 tags can't be written to other formats, so stream is saved
+		// as is with the right extension and tagged by its format.
+		// ID3 tag is removed from track file.
+		if !tagged {
+			err = nil
+		}
+		if e := truncate(trackFile); e != nil {
+			return "", classified(categoryFilesystem, fmt.Errorf("couldn't truncate track file: %v", e))
+		}
+		// Path with the right extension is limited, reserved and checked
+		// like trackPath before downloading, because extension can be
+		// longer and other track can have the same name.
+		name := strings.TrimSuffix(filepath.Base(trackPath), filepath.Ext(trackPath))
+		if newPath := filepath.Join(filepath.Dir(trackPath), util.LimitFilename(name, f.Ext)); newPath != trackPath {
+			var releaseNew func()
+			newPath, releaseNew = reservePath(newPath, t.ID())
+			defer releaseNew()
+			if _, e := os.Stat(newPath); e == nil {
+				if downloader.archive {
+					return "", classified(categoryFilesystem, fmt.Errorf("%q already exists and can't be overwritten in archive mode", newPath))
+				}
+				audit.Log(audit.Overwrite, newPath, "track was downloaded again")
+			}
+			trackPath = newPath
+		}
+	}
+
+	// Write track to track file. Part file is copied, because tmpDir
+	// can be on other filesystem.
+	if e := appendFile(trackFile, partPath); e != nil {
+		return "", classified(categoryFilesystem, fmt.Errorf("couldn't write track to file: %v", e))
+	}
+	if e := trackFile.Close(); e != nil {
+		return "", classified(categoryFilesystem, fmt.Errorf("couldn't close track file: %v", e))
+	}
+	if e := os.Rename(tmpPath, trackPath); e != nil {
+		return "", classified(categoryFilesystem, fmt.Errorf("couldn't rename track file: %v", e))
+	}
+	finished = true
+	if e := os.Remove(partPath); e != nil {
+		logs.WARN.Printf("couldn't remove %q: %v\n", partPath, e)
+	}
+
+	if !isMP3 {
+		if tagger := tags.For(f); tagger == nil {
+			logs.INFO.Printf("%q is %v, it's saved without tags\n", t.Fullname(), f.Name)
+		} else if e := tagger.WriteTags(trackPath, downloader.tagMetadata(t, trackNumber, embedded)); e != nil && err == nil {
+			err = classified(categoryTag, fmt.Errorf("couldn't write tags to %v file: %v", f.Name, e))
+		}
+	}
+	tm.measure(stageWrite, start)
+
+	// Trim intro and outro of track.
+	if tr, exists := downloader.trimFor(t); exists && !isMP3 {
+		logs.WARN.Printf("%q isn't trimmed, because it's %v\n", t.Fullname(), f.Name)
+	} else if exists {
+		start := time.Now()
+		if e := tr.apply(trackPath, t.JDuration); e != nil && err == nil {
+			err = classified(categoryPostProcess, fmt.Errorf("couldn't trim track: %v", e))
+		}
+		tm.measure(stageTrim, start)
+	}
+
+	// Set modification time of track file.
+	if downloader.fileTime == fileTimeUploaded {
+		if createdAt := t.CreatedAt(); !createdAt.IsZero() {
+			if e := os.Chtimes(trackPath, time.Now(), createdAt); e != nil && err == nil {
+				err = classified(categoryFilesystem, fmt.Errorf("couldn't set modification time of track file: %v", e))
+			}
+		}
+	}
 
-	// Write track to track file.
-	if _, e := trackFile.Write(trackBuf); e != nil {
-		return fmt.Errorf("couldn't write track to file: %v", e)
+	// Fields set by user or other commands (e.g. protected, purchased,
+	// ID in iTunes) are kept from the previous entry of track.
+	entry, _ := index.Get(t.ID())
+	entry.ID = t.ID()
+	entry.Path = trackPath
+	entry.Filename = filepath.Base(trackPath)
+	entry.Artist = t.Artist()
+	entry.Title = t.Title()
+	entry.Genre = t.Genre()
+	entry.BuyURL = t.PurchaseURL()
+	entry.FreeDownloadURL = t.FreeDownloadURL()
+	entry.DescriptionHash = t.DescriptionHash()
+	if downloader.label != "" {
+		entry.Label = downloader.label
+	}
+	if downloader.album != "" {
+		entry.Album = downloader.album
+		entry.TrackNumber = trackNumber
 	}
 
 	// Add to iTunes.
-	if downloader.itunesPlaylist != "" {
-		logs.FEEDBACK.Print("adding to iTunes ... ")
-		if e := applescript.AddTrackToPlaylist(trackPath, downloader.itunesPlaylist); e != nil && err == nil {
-			err = fmt.Errorf("couldn't add track to playlist: %v", e)
+	if downloader.itunesPlaylist != "" && downloader.itunesBatch != nil {
+		downloader.itunesBatch.add(t.ID(), downloader.music.properties(t))
+	} else if downloader.itunesPlaylist != "" {
+		st.print("adding to iTunes ... ")
+		importMu.Lock()
+		start := time.Now()
+		props := downloader.music.properties(t)
+		added, e := AddToItunes(trackPath, downloader.itunesPlaylist, props)
+		tm.measure(stageImport, start)
+		if library.Default.IsBusy(e) {
+			queuedPath, qe := downloader.queueImport(t.ID(), trackPath, props)
+			if qe == nil {
+				st.print("iTunes is busy, import is queued ... ")
+				entry.Path = queuedPath
+			} else if err == nil {
+				err = classified(categoryImport, fmt.Errorf("iTunes is busy and import couldn't be queued: %v", qe))
+			}
+		} else if e != nil && err == nil {
+			err = classified(categoryImport, fmt.Errorf("couldn't add track to playlist: %v", e))
+		} else if e == nil {
+			entry.ItunesID = added.PersistentID
+		}
+		if e == nil && downloader.importOnly {
+			e = removeImported(trackPath, added.Location)
+			if e == nil {
+				entry.Path = added.Location
+			} else if err == nil {
+				err = classified(categoryImport, e)
+			}
+		}
+		importMu.Unlock()
+	}
+
+	// Upload to secondary storage.
+	if downloader.uploadTo != "" && !downloader.importOnly {
+		st.print("uploading ... ")
+		start := time.Now()
+		uploadedPath, e := downloader.upload(trackPath)
+		tm.measure(stageUpload, start)
+		if e != nil && err == nil {
+			err = classified(categoryUpload, e)
+		}
+		if e == nil && downloader.moveAfterUpload {
+			entry.Path = uploadedPath
+		}
+	}
+
+	// Add to MPD.
+	if downloader.mpd != nil {
+		st.print("adding to MPD ... ")
+		start := time.Now()
+		e := downloader.mpd.add(entry.Path, downloader.dist)
+		tm.measure(stageImport, start)
+		if e != nil && err == nil {
+			err = classified(categoryMPD, fmt.Errorf("couldn't add track to MPD: %v", e))
+		}
+	}
+
+	// Save waveform next to track.
+	if (downloader.waveform == waveformPNG || downloader.waveform == waveformJSON) && !downloader.importOnly {
+		if e := downloader.writeWaveform(t, entry.Path); e != nil && err == nil {
+			err = classified(categoryNetwork, fmt.Errorf("couldn't save waveform: %v", e))
+		}
+	}
+
+	// Export timed comments next to track.
+	if downloader.commentsFile != "" && !downloader.importOnly {
+		if e := downloader.writeComments(t.ID(), entry.Path); e != nil && err == nil {
+			err = classified(categoryNetwork, fmt.Errorf("couldn't export comments: %v", e))
 		}
 	}
 
+	index.Add(entry)
+
+	return entry.Path, err
+}
+
+// postProcess runs post-processors on t, which will be written
+// to trackPath. It sets changed artist and title to t and returns
+// changed path and album.
+func (downloader Downloader) postProcess(t *track.Track, trackPath string) (string, string, error) {
+	m := postprocess.Metadata{
+		Artist:      t.Artist(),
+		Title:       t.Title(),
+		Album:       downloader.album,
+		Path:        trackPath,
+		ID:          t.ID(),
+		Uploader:    t.Uploader(),
+		Permalink:   t.UploaderPermalink(),
+		RawTitle:    t.JTitle,
+		Description: t.Description(),
+		CreatedAt:   t.JCreatedAt,
+		Duration:    t.JDuration,
+	}
+
+	m, err := postprocess.Run(downloader.postProcessors, m)
+	if err != nil {
+		return "", "", err
+	}
+
+	t.SetName(m.Artist, m.Title)
+	if !filepath.IsAbs(m.Path) {
+		m.Path = filepath.Join(downloader.dist, m.Path)
+	}
+	return filepath.Clean(m.Path), m.Album, nil
+}
+
+// reservedNumbers are the last track numbers given to tracks of albums,
+// which may be not in index yet, because they're still downloading.
+var (
+	numbersMu       sync.Mutex
+	reservedNumbers = make(map[string]int)
+)
+
+// UsePlaylistPositions makes positions of tracks in playlist
+// their numbers in album.
+func (downloader *Downloader) UsePlaylistPositions(tracks []track.Track) {
+	downloader.positions = make(map[int]int, len(tracks))
+	for i, t := range tracks {
+		downloader.positions[t.ID()] = i + 1
+	}
+}
+
+// trackNumber returns the number of t in album. Tracks, which were
+// already downloaded to album, keep their numbers. It returns 0,
+// if album is not set.
+func (downloader Downloader) trackNumber(t track.Track) int {
+	if downloader.album == "" {
+		return 0
+	}
+	if e, exists := index.Get(t.ID()); exists && e.Album == downloader.album && e.TrackNumber > 0 {
+		return e.TrackNumber
+	}
+	if n := downloader.positions[t.ID()]; n > 0 {
+		return n
+	}
+
+	numbersMu.Lock()
+	defer numbersMu.Unlock()
+
+	n := index.NextTrackNumber(downloader.album)
+	if reserved := reservedNumbers[downloader.album]; reserved >= n {
+		n = reserved + 1
+	}
+	reservedNumbers[downloader.album] = n
+	return n
+}
+
+// unavailableError is returned, if track can't be downloaded, because
+// it's private, geo-blocked or not streamable.
+type unavailableError struct {
+	reason string
+	// statusCode is the HTTP status of stream, if it was unavailable.
+	statusCode int
+}
+
+// streamPreset replaces url with the URL of stream in configured
+// quality and returns the preset of its transcoding and whether it's
+// HLS stream. If quality is not set or there is no such transcoding,
+// url is not changed. Tracks without stream_url are HLS-only,
+// so their stream is selected in standard quality by default.
+func (downloader Downloader) streamPreset(t track.Track, url *string) (preset string, hls bool) {
+	quality := downloader.quality
+	if quality == "" && *url != "" {
+		return "", false
+	}
+	if quality == "" {
+		quality = track.QualityStandard
+	}
+	tr, ok := t.Transcoding(quality)
+	if !ok {
+		logs.INFO.Printf("there is no %v transcoding of %q, default stream is downloaded\n", quality, t.Fullname())
+		return "", false
+	}
+	streamURL, err := api.StreamURL(tr)
+	if err != nil {
+		logs.WARN.Printf("couldn't get %v stream of %q: %v\n", tr.Preset, t.Fullname(), err)
+		return "", false
+	}
+	*url = streamURL
+	return tr.Preset, !tr.Progressive()
+}
+
+// artworkMaxBytesFromConfig returns artworkMaxBytes from config, e.g. "200KB".
+// The program is terminating, if it's invalid.
+func artworkMaxBytesFromConfig() int64 {
+	value := config.Get("artworkMaxBytes")
+	if value == "" {
+		return 0
+	}
+	limit, err := util.ParseSize(value)
+	if err != nil {
+		logs.FATAL.Fatalf("invalid artworkMaxBytes %q: %v\n", value, err)
+	}
+	return limit
+}
+
+// qualityFromConfig returns quality of streams from config.
+// The program is terminating, if quality is invalid.
+func qualityFromConfig() string {
+	switch q := config.Get("quality"); q {
+	case "", track.QualityLow, track.QualityStandard, track.QualityHigh:
+		return q
+	default:
+		logs.FATAL.Fatalf("invalid quality %q. Use %q, %q or %q.\n", q, track.QualityLow, track.QualityStandard, track.QualityHigh)
+		return ""
+	}
+}
+
+// originalSuffix is added to part files of originals
+// before tempdir.PartSuffix.
+const originalSuffix = ".original"
+
+// ExitAuth is the exit code of nehm, when downloading was aborted
+// because of repeated 401 and 403 errors.
+const ExitAuth = 3
+
+// Errors of DownloadAll, when downloading was aborted.
+var (
+	ErrAuthAborted = errors.New("downloading was aborted because of authorization errors")
+	ErrFailFast    = errors.New("downloading was aborted because of the error (fail fast mode)")
+)
+
+// defaultMaxAuthFailures is the count of tracks failed with 401 or 403
+// in a row, after which downloading is aborted.
+const defaultMaxAuthFailures = 5
+
+// maxAuthFailuresFromConfig returns maxAuthFailures from config.
+// 0 means, that downloading is never aborted. The program is terminating,
+// if value is invalid.
+func maxAuthFailuresFromConfig() int {
+	value := config.Get("maxAuthFailures")
+	if value == "" {
+		return defaultMaxAuthFailures
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		logs.FATAL.Fatalf("maxAuthFailures must be a non-negative integer, got %q\n", value)
+	}
+	return n
+}
+
+// isAuthError reports whether err is 401 or 403 status of stream.
+func isAuthError(err error) bool {
+	e, ok := err.(unavailableError)
+	return ok && (e.statusCode == 401 || e.statusCode == 403)
+}
+
+func (e unavailableError) Error() string {
+	return e.reason
+}
+
+// checkStatusCode returns error, if statusCode of track stream
+// is not successful.
+func checkStatusCode(statusCode int) error {
+	switch {
+	case statusCode == 401 || statusCode == 403 || statusCode == 404:
+		return unavailableError{fmt.Sprintf("track is unavailable (HTTP %v)", statusCode), statusCode}
+	case statusCode >= 400:
+		return classified(categoryNetwork, fmt.Errorf("couldn't download track: HTTP %v", statusCode))
+	}
+	return nil
+}
+
+// removeImported removes track file at trackPath after it was
+// added to iTunes, if iTunes copied it to location in its library.
+func removeImported(trackPath, location string) error {
+	absPath, err := filepath.Abs(trackPath)
+	if err != nil {
+		return err
+	}
+	if location == "" || filepath.Clean(location) == absPath {
+		return errors.New("iTunes didn't copy track to its library. " +
+			"Enable \"Copy files to iTunes Media folder\" in iTunes preferences to use importOnly mode")
+	}
+	return os.Remove(trackPath)
+}
+
+// truncate removes the contents of f, so it's written from start.
+func truncate(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// appendFile appends the contents of file at path to f.
+func appendFile(f *os.File, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(f, src)
 	return err
 }
 
-func writeTagToWriter(t track.Track, w io.Writer, artwork []byte) error {
-	tag := id3v2.NewEmptyTag()
+// writeCoverFile writes artwork to the file with name in dir.
+// There is only one cover file per folder, so if it already exists,
+// writeCoverFile keeps it as is.
+func writeCoverFile(dir, name string, artwork []byte) error {
+	if len(artwork) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(dir, filepath.Base(name))
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := ioutil.WriteFile(path, artwork, 0644); err != nil {
+		return err
+	}
+	return chown(path)
+}
+
+// writeArtistImage downloads the first available avatar of avatarURLs
+// and writes it to artist.jpg in dir, if there is no such file yet.
+func writeArtistImage(dir string, avatarURLs []string) error {
+	path := filepath.Join(dir, artistImageFile)
+	if len(avatarURLs) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	logs.INFO.Printf("Downloading artist image from %q\n", avatarURLs[0])
+	avatar, err := fetchArtwork(nil, avatarURLs)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, avatar, 0644); err != nil {
+		return err
+	}
+	return chown(path)
+}
+
+// fetchArtwork appends the first available artwork from urls to dst.
+// If artwork isn't available in some size, the next URL is tried.
+func fetchArtwork(dst []byte, urls []string) ([]byte, error) {
+	for i, url := range urls {
+		statusCode, body, err := httpclient.Get(dst, url)
+		if err != nil {
+			return dst, err
+		}
+		if statusCode == 200 {
+			return body, nil
+		}
+		if i < len(urls)-1 {
+			logs.INFO.Printf("artwork isn't available at %q (HTTP %v), trying smaller size\n", url, statusCode)
+		} else {
+			return dst, fmt.Errorf("artwork isn't available (HTTP %v)", statusCode)
+		}
+	}
+	return dst, nil
+}
 
-	tag.SetArtist(t.Artist())
-	tag.SetTitle(t.Title())
-	tag.SetYear(t.Year())
+// embeddedArtwork returns the artwork, which should be embedded to tag
+// of t. It's nil, if embedArtwork is disabled. If artwork is bigger than
+// artworkMaxBytes, smaller sizes from urls are tried and nil is returned,
+// if none of them fits.
+func (downloader Downloader) embeddedArtwork(t track.Track, artwork []byte, urls []string) []byte {
+	if !downloader.embedArtwork || len(artwork) == 0 {
+		return nil
+	}
+	limit := downloader.artworkMaxBytes
+	if limit <= 0 || int64(len(artwork)) <= limit {
+		return artwork
+	}
 
-	if len(artwork) > 0 {
-		pic := id3v2.PictureFrame{
-			Encoding:    id3v2.EncodingUTF8,
-			MimeType:    "image/jpeg",
-			PictureType: id3v2.PTFrontCover,
-			Picture:     artwork,
+	// Placeholders have no URL, so they can't be downloaded smaller.
+	if t.HasArtwork() || !downloader.generateArtwork {
+		for i := 1; i < len(urls); i++ {
+			smaller, err := fetchArtwork(nil, urls[i:i+1])
+			if err != nil {
+				break
+			}
+			if int64(len(smaller)) <= limit {
+				return smaller
+			}
 		}
-		tag.AddAttachedPicture(pic)
 	}
+	logs.INFO.Printf("artwork of %q is bigger than artworkMaxBytes, it's not embedded\n", t.Fullname())
+	return nil
+}
 
-	_, err := tag.WriteTo(w)
-	return err
+// chown changes the owner of file to PUID and PGID environment variables,
+// if they are set. It's used in containers, where nehm runs as root,
+// but files should belong to the user of host.
+func chown(path string) error {
+	puid, pgid := os.Getenv("PUID"), os.Getenv("PGID")
+	if puid == "" && pgid == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1 // -1 means to not change the id.
+	var err error
+	if puid != "" {
+		if uid, err = strconv.Atoi(puid); err != nil {
+			return fmt.Errorf("invalid PUID %q", puid)
+		}
+	}
+	if pgid != "" {
+		if gid, err = strconv.Atoi(pgid); err != nil {
+			return fmt.Errorf("invalid PGID %q", pgid)
+		}
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// mkdirAll creates dir with all missing parents like os.MkdirAll
+// and changes the owner of created folders with chown.
+func mkdirAll(dir string) error {
+	var missing []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := chown(missing[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// progressInterval is the minimal interval between TrackProgress events.
+const progressInterval = 500 * time.Millisecond
+
+// progressFunc returns the function, which emits TrackProgress events
+// of t not often than once in progressInterval.
+func progressFunc(t track.Track) httpclient.ProgressFunc {
+	var last time.Time
+	return func(written, size int64) {
+		if written != size && time.Since(last) < progressInterval {
+			return
+		}
+		last = time.Now()
+		if size < 0 {
+			size = 0
+		}
+		progress.Emit(progress.Event{
+			Type:  progress.TrackProgress,
+			ID:    t.ID(),
+			Track: t.Fullname(),
+			Bytes: written,
+			Size:  size,
+		})
+	}
 }
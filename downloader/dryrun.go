@@ -0,0 +1,70 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/track"
+	"github.com/bogem/nehm/util"
+)
+
+// streamBitrate is the bitrate of SoundCloud streams in kbit/s.
+// It's used to estimate the size of tracks.
+const streamBitrate = 128
+
+// estimatedSize returns the approximate size of t in bytes,
+// if it's downloaded in quality.
+func estimatedSize(t track.Track, quality string) int64 {
+	bitrate := streamBitrate
+	if quality != "" {
+		if tr, ok := t.Transcoding(quality); ok {
+			bitrate = tr.Bitrate()
+		}
+	}
+	// Duration is in milliseconds.
+	return int64(t.JDuration) * int64(bitrate) / 8
+}
+
+// printPlan prints, which tracks would be downloaded and where,
+// and which would be skipped. It's used instead of downloading
+// in dry-run mode.
+func (downloader Downloader) printPlan(tracks, skipped []track.Track) {
+	var planned []track.Track
+	var total int64
+	for i := len(tracks) - 1; i >= 0; i-- {
+		t := tracks[i]
+		if t.URL() == "" && len(t.Transcodings()) == 0 {
+			skipped = append(skipped, t)
+			continue
+		}
+		planned = append(planned, t)
+		total += estimatedSize(t, downloader.quality)
+	}
+
+	logs.FEEDBACK.Printf("Would download %v track(s):\n", len(planned))
+	for _, t := range planned {
+		logs.FEEDBACK.Printf("  %v\n    → %v (~%v)\n", t.Fullname(), downloader.TrackPath(t), util.SizeString(estimatedSize(t, downloader.quality)))
+	}
+
+	if len(skipped) > 0 {
+		logs.FEEDBACK.Printf("Would skip %v track(s):\n", len(skipped))
+		for _, t := range skipped {
+			reason := "not downloadable"
+			if e, exists := index.Get(t.ID()); exists {
+				reason = "already downloaded to " + e.Path
+			}
+			logs.FEEDBACK.Printf("  %v: %v\n", t.Fullname(), reason)
+		}
+	}
+
+	logs.FEEDBACK.Printf("Total: ~%v\n", util.SizeString(total))
+	if downloader.itunesPlaylist != "" {
+		logs.FEEDBACK.Printf("Tracks would be added to iTunes playlist %q\n", downloader.itunesPlaylist)
+	}
+	if len(downloader.postProcessors) > 0 {
+		logs.FEEDBACK.Println("Paths are shown before post-processing")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2"
+	"github.com/bogem/nehm/feed"
+	"github.com/bogem/nehm/format"
+	"github.com/bogem/nehm/httpclient"
+	"github.com/bogem/nehm/library"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/tags"
+	"github.com/bogem/nehm/tempdir"
+	"github.com/bogem/nehm/util"
+)
+
+// episodeGenre is the genre written to tags of episodes.
+const episodeGenre = "Podcast"
+
+// mimeExts are extensions of audio files of episodes by their MIME types.
+// They're used, if format of file can't be detected.
+var mimeExts = map[string]string{
+	"audio/mpeg":  ".mp3",
+	"audio/mp3":   ".mp3",
+	"audio/mp4":   ".m4a",
+	"audio/x-m4a": ".m4a",
+	"audio/aac":   ".aac",
+	"audio/ogg":   ".ogg",
+	"audio/opus":  ".opus",
+	"audio/flac":  ".flac",
+}
+
+// DownloadEpisode downloads episode e of feed f to the folder of feed
+// in dlFolder and tags it with metadata of feed. It returns the path of
+// downloaded file. If file already exists, it's not downloaded again.
+func (downloader Downloader) DownloadEpisode(f feed.Feed, e feed.Episode) (string, error) {
+	dir := filepath.Join(downloader.dist, util.LimitFilename(util.SanitizeFilename(f.Title), ""))
+	name := util.SanitizeFilename(e.Title)
+	episodePath := filepath.Join(dir, util.LimitFilename(name, episodeExt(e)))
+	if downloader.dryRun {
+		logs.FEEDBACK.Printf("Would download %q to %q\n", e.Title, episodePath)
+		return episodePath, nil
+	}
+	if _, err := os.Stat(episodePath); err == nil {
+		logs.INFO.Printf("%q is already downloaded\n", episodePath)
+		return episodePath, nil
+	}
+	if err := mkdirAll(dir); err != nil {
+		return "", fmt.Errorf("couldn't create folder of feed: %v", err)
+	}
+
+	logs.FEEDBACK.Printf("Downloading %q ... ", e.Title)
+	// Part file in tmpDir is named by URL of episode, so interrupted
+	// download is resumed, even if title of episode is changed.
+	sum := sha1.Sum([]byte(e.URL))
+	partPath, err := tempdir.PartPath("episode-" + hex.EncodeToString(sum[:8]))
+	if err != nil {
+		logs.FEEDBACK.Println("✘")
+		return "", fmt.Errorf("couldn't create folder of partial downloads: %v", err)
+	}
+	statusCode, err := httpclient.DownloadFile(partPath, e.URL, nil)
+	if err == nil && statusCode >= 400 {
+		err = fmt.Errorf("HTTP %v", statusCode)
+	}
+	if err != nil {
+		logs.FEEDBACK.Println("✘")
+		return "", fmt.Errorf("couldn't download episode: %v", err)
+	}
+
+	ff, err := format.SniffFile(partPath)
+	if err != nil {
+		logs.FEEDBACK.Println("✘")
+		return "", fmt.Errorf("couldn't detect format: %v", err)
+	}
+	if ff != format.Unknown && ff.Ext != filepath.Ext(episodePath) {
+		episodePath = filepath.Join(dir, util.LimitFilename(name, ff.Ext))
+	}
+
+	if err := downloader.tagEpisode(partPath, ff, f, e); err != nil {
+		// Untagged episode is still better than nothing.
+		logs.WARN.Printf("couldn't tag %q: %v\n", e.Title, err)
+	}
+	if err := moveFile(partPath, episodePath); err != nil {
+		logs.FEEDBACK.Println("✘")
+		return "", fmt.Errorf("couldn't move episode file: %v", err)
+	}
+	if err := chown(episodePath); err != nil {
+		logs.WARN.Printf("couldn't change owner of %q: %v\n", episodePath, err)
+	}
+	logs.FEEDBACK.Println("✔︎")
+
+	if downloader.itunesPlaylist != "" && !downloader.archive {
+		if _, err := AddToItunes(episodePath, downloader.itunesPlaylist, library.TrackProperties{}); err != nil {
+			logs.ERROR.Printf("couldn't add %q to iTunes: %v\n", e.Title, err)
+		}
+	}
+	return episodePath, nil
+}
+
+// episodeExt returns the extension of episode file by its URL or MIME type.
+func episodeExt(e feed.Episode) string {
+	if u, err := url.Parse(e.URL); err == nil {
+		ext := strings.ToLower(path.Ext(u.Path))
+		for _, known := range mimeExts {
+			if ext == known {
+				return ext
+			}
+		}
+	}
+	if ext, ok := mimeExts[e.Type]; ok {
+		return ext
+	}
+	return ".mp3"
+}
+
+// tagEpisode writes metadata of episode e of feed f to file at path with format ff.
+// Author of feed is the artist and title of feed is the album.
+func (downloader Downloader) tagEpisode(path string, ff format.Format, f feed.Feed, e feed.Episode) error {
+	artist := f.Author
+	if artist == "" {
+		artist = f.Title
+	}
+	var year string
+	if !e.Published.IsZero() {
+		year = strconv.Itoa(e.Published.Year())
+	}
+	artwork := downloader.episodeArtwork(f, e)
+
+	if ff != format.MP3 && ff != format.Unknown {
+		tagger := tags.For(ff)
+		if tagger == nil {
+			return fmt.Errorf("%v files can't be tagged", ff.Name)
+		}
+		return tagger.WriteTags(path, tags.Metadata{
+			Artist:  artist,
+			Title:   e.Title,
+			Album:   f.Title,
+			Genre:   episodeGenre,
+			Year:    year,
+			Comment: e.Description,
+			Artwork: artwork,
+		})
+	}
+
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		return err
+	}
+	defer tag.Close()
+
+	tag.SetVersion(downloader.id3Version)
+	tag.SetDefaultEncoding(downloader.tagEncoding)
+	tag.SetArtist(artist)
+	tag.SetTitle(e.Title)
+	tag.SetAlbum(f.Title)
+	tag.SetGenre(episodeGenre)
+	if year != "" {
+		tag.SetYear(year)
+	}
+	if e.Description != "" {
+		tag.DeleteFrames(tag.CommonID("Comments"))
+		tag.AddCommentFrame(id3v2.CommentFrame{
+			Encoding: downloader.tagEncoding,
+			Language: commentLanguage(downloader.tagLanguage),
+			Text:     e.Description,
+		})
+	}
+	if f.URL != "" {
+		// Podcast URL frame is ISO-8859-1 without encoding byte, as other URL frames.
+		tag.DeleteFrames("WFED")
+		tag.AddFrame("WFED", id3v2.UnknownFrame{Body: []byte(f.URL)})
+	}
+	if len(artwork) > 0 {
+		tag.DeleteFrames("APIC")
+		tag.AddAttachedPicture(id3v2.PictureFrame{
+			Encoding:    downloader.tagEncoding,
+			MimeType:    artworkMIME(artwork),
+			PictureType: id3v2.PTFrontCover,
+			Picture:     artwork,
+		})
+	}
+	return tag.Save()
+}
+
+// episodeArtwork returns the cover of episode or, if it has none,
+// the cover of feed. It's nil, if embedArtwork is disabled
+// or cover couldn't be downloaded.
+func (downloader Downloader) episodeArtwork(f feed.Feed, e feed.Episode) []byte {
+	if !downloader.embedArtwork {
+		return nil
+	}
+	var urls []string
+	for _, u := range []string{e.ImageURL, f.ImageURL} {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	artwork, err := fetchArtwork(nil, urls)
+	if err == nil {
+		artwork, err = normalizeArtwork(artwork)
+	}
+	if err != nil {
+		logs.WARN.Printf("couldn't get cover of %q: %v\n", e.Title, err)
+		return nil
+	}
+	return artwork
+}
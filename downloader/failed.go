@@ -0,0 +1,75 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/track"
+)
+
+func failedPath() string {
+	return filepath.Join(config.SyncStateDir(), "failed.json")
+}
+
+// FailedTracks returns tracks, which failed to download in previous runs
+// and weren't downloaded successfully since then.
+func FailedTracks() ([]track.Track, error) {
+	data, err := ioutil.ReadFile(failedPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the list of failed tracks: %v", err)
+	}
+
+	var tracks []track.Track
+	if err := json.Unmarshal(data, &tracks); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal the list of failed tracks: %v", err)
+	}
+	return tracks, nil
+}
+
+// updateFailedTracks adds failed tracks to the list of failed tracks
+// and removes succeeded ones from it.
+func updateFailedTracks(failed []track.Track, succeeded map[int]bool) error {
+	previous, err := FailedTracks()
+	if err != nil {
+		return err
+	}
+
+	isFailed := make(map[int]bool, len(failed))
+	for _, t := range failed {
+		isFailed[t.ID()] = true
+	}
+
+	tracks := failed
+	for _, t := range previous {
+		if !isFailed[t.ID()] && !succeeded[t.ID()] {
+			tracks = append(tracks, t)
+		}
+	}
+
+	if len(tracks) == 0 {
+		if err := os.Remove(failedPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(tracks)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.SyncStateDir(), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(failedPath(), data, 0644)
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"github.com/bogem/nehm/color"
+	"github.com/bogem/nehm/logs"
+)
+
+// Categories of errors while downloading.
+const (
+	categoryNetwork     = "network"
+	categoryUnavailable = "unavailable"
+	categoryTag         = "tag"
+	categoryFilesystem  = "filesystem"
+	categoryPostProcess = "post-processing"
+	categoryImport      = "import"
+	categoryUpload      = "upload"
+	categoryMPD         = "mpd"
+	categoryOther       = "other"
+)
+
+// categories is the order, in which categories are reported.
+var categories = [...]string{
+	categoryNetwork, categoryUnavailable, categoryTag, categoryFilesystem,
+	categoryPostProcess, categoryImport, categoryUpload, categoryMPD, categoryOther,
+}
+
+var remediations = map[string]string{
+	categoryNetwork:     "Check your connection or increase timeout and run 'nehm retry'.",
+	categoryUnavailable: "Tracks are private, geo-blocked or not streamable. sync checks them again after recheckUnavailableDays.",
+	categoryTag:         "Tracks were downloaded, but not tagged correctly. Check tagEncoding and run 'nehm retry'.",
+	categoryFilesystem:  "Check permissions and free space in download folder.",
+	categoryPostProcess: "Check your postProcessors, trims and ffmpegPath.",
+	categoryImport:      "Check, that iTunes is running and the playlist exists, and run 'nehm retry'.",
+	categoryUpload:      "Check, that uploadTo is mounted and writable, and run 'nehm retry'.",
+	categoryMPD:         "Check, that MPD is running at mpdAddress and tracks are in its music directory, and run 'nehm retry'.",
+	categoryOther:       "Run nehm with flag '--verbose' to see more details.",
+}
+
+// classifiedError is the error with its category.
+type classifiedError struct {
+	category string
+	err      error
+}
+
+func (e classifiedError) Error() string {
+	return e.err.Error()
+}
+
+// classified returns err with category. If err is nil, it returns nil.
+func classified(category string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return classifiedError{category, err}
+}
+
+// classify returns the category of err.
+func classify(err error) string {
+	switch e := err.(type) {
+	case classifiedError:
+		return e.category
+	case unavailableError:
+		return categoryUnavailable
+	}
+	return categoryOther
+}
+
+// failure is the error of downloading track.
+type failure struct {
+	track string
+	err   error
+}
+
+// printFailures prints failures grouped by category with suggestions,
+// how to fix them.
+func printFailures(failures []failure) {
+	grouped := make(map[string][]failure)
+	for _, f := range failures {
+		category := classify(f.err)
+		grouped[category] = append(grouped[category], f)
+	}
+
+	logs.FEEDBACK.Println("\n" + color.RedString("There were errors while downloading tracks:"))
+	for _, category := range categories {
+		list := grouped[category]
+		if len(list) == 0 {
+			continue
+		}
+		logs.FEEDBACK.Printf("\n%v (%v):\n", category, len(list))
+		for _, f := range list {
+			logs.FEEDBACK.Printf("  %v: %v\n", f.track, f.err)
+		}
+		logs.FEEDBACK.Println(" ", remediations[category])
+	}
+	logs.FEEDBACK.Println()
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/track"
+	"github.com/bogem/nehm/util"
+)
+
+// folderTemplateFromConfig returns the template of folders of tracks
+// (folderTemplate in config), e.g. "{{.Artist}}/{{.Year}}". Fields are
+// the same as in fileNameTemplate and Album. It returns nil, if
+// folderTemplate is not set. The program is terminating,
+// if template is invalid.
+func folderTemplateFromConfig() *template.Template {
+	text := config.Get("folderTemplate")
+	if text == "" {
+		return nil
+	}
+
+	tmpl, err := template.New("folderTemplate").Option("missingkey=error").Parse(text)
+	if err == nil {
+		// Unknown fields are only found on execution.
+		err = tmpl.Execute(new(bytes.Buffer), track.TemplateData{})
+	}
+	if err != nil {
+		logs.FATAL.Fatalln("invalid folderTemplate:", err)
+	}
+	if config.Get("organizeBy") != "" {
+		logs.WARN.Println("organizeBy is ignored, because folderTemplate is set")
+	}
+	return tmpl
+}
+
+// templateDir returns the folder of t made with folderTemplate. Each part
+// of path is sanitized, so template can't lead out of download folder.
+func (downloader Downloader) templateDir(t track.Track) string {
+	data := t.TemplateData()
+	data.Album = downloader.album
+
+	var buf bytes.Buffer
+	if err := downloader.folderTemplate.Execute(&buf, data); err != nil {
+		logs.WARN.Printf("couldn't make folder of %q with folderTemplate: %v\n", t.Fullname(), err)
+		return downloader.dist
+	}
+
+	parts := []string{downloader.dist}
+	for _, part := range strings.FieldsFunc(buf.String(), func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, util.LimitFilename(util.SanitizeFilename(part), ""))
+		}
+	}
+	return filepath.Join(parts...)
+}
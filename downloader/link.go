@@ -0,0 +1,55 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bogem/nehm/audit"
+	"github.com/bogem/nehm/logs"
+)
+
+// Modes of linking already downloaded tracks.
+const (
+	linkModeHardlink = "hardlink"
+	linkModeReflink  = "reflink"
+)
+
+var errReflinkUnsupported = errors.New("reflinks are not supported on this OS")
+
+// linkFile makes dst the same file as src without downloading it again.
+// In reflink mode it tries to clone src and falls back to hardlink.
+// If hardlink is not possible too (e.g. src and dst are on different
+// filesystems), linkFile copies src to dst.
+func linkFile(mode, src, dst string) error {
+	if mode != linkModeHardlink && mode != linkModeReflink {
+		return fmt.Errorf("invalid linkMode %q: should be %q or %q", mode, linkModeHardlink, linkModeReflink)
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		audit.Log(audit.Overwrite, dst, "track was linked to already downloaded copy")
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+
+	if mode == linkModeReflink {
+		err := reflink(src, dst)
+		if err == nil {
+			return nil
+		}
+		logs.INFO.Printf("couldn't make reflink of %q, falling back to hardlink: %v\n", src, err)
+	}
+
+	err := os.Link(src, dst)
+	if err == nil {
+		return nil
+	}
+	logs.INFO.Printf("couldn't make hardlink of %q, falling back to copy: %v\n", src, err)
+
+	return copyFile(src, dst)
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/mpd"
+)
+
+// mpdTarget adds downloaded tracks to MPD database and
+// playlist (mpdAddress and mpdPlaylist in config).
+type mpdTarget struct {
+	address  string
+	playlist string
+
+	// mu serializes adding of tracks, if they're downloaded in parallel.
+	mu sync.Mutex
+	// root is the music directory of MPD. Paths of tracks are sent
+	// relative to it.
+	root string
+}
+
+// mpdTargetFromConfig returns the MPD target or nil, if mpdAddress isn't set.
+// The program is terminating, if mpdPlaylist is set without mpdAddress.
+func mpdTargetFromConfig() *mpdTarget {
+	address := config.Get("mpdAddress")
+	playlist := config.Get("mpdPlaylist")
+	if address == "" {
+		if playlist != "" {
+			logs.FATAL.Fatalln("mpdPlaylist is set, but mpdAddress isn't. Set mpdAddress in config file.")
+		}
+		return nil
+	}
+	return &mpdTarget{address: address, playlist: playlist}
+}
+
+// add updates MPD database for track at path and appends it to playlist.
+// If MPD doesn't give its music directory (it does only through unix socket),
+// dist is supposed to be the music directory.
+func (m *mpdTarget) add(path, dist string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := mpd.Dial(m.address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if m.root == "" {
+		m.root, err = c.MusicDirectory()
+		if err != nil {
+			logs.INFO.Printf("couldn't get music directory of MPD (%v), download folder is used instead\n", err)
+			m.root = dist
+		}
+	}
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("track isn't in music directory of MPD %q", m.root)
+	}
+	uri := filepath.ToSlash(rel)
+
+	if err := c.Update(uri); err != nil {
+		return err
+	}
+	if m.playlist != "" {
+		if err := c.PlaylistAdd(m.playlist, uri); err != nil {
+			return fmt.Errorf("couldn't add track to MPD playlist %q: %v", m.playlist, err)
+		}
+	}
+	return nil
+}
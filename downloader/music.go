@@ -0,0 +1,80 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/library"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/track"
+)
+
+// musicSettings are the properties set to tracks added to iTunes.
+// They are set in music section of config, e.g.:
+//
+//	music:
+//	  loved: true
+//	  ratings:
+//	    1000: 3
+//	    10000: 4
+//	    100000: 5
+//
+// ratings maps the minimal playback count on SoundCloud
+// to the rating in stars.
+type musicSettings struct {
+	loved   bool
+	ratings []ratingThreshold
+}
+
+type ratingThreshold struct {
+	playbacks int
+	stars     int
+}
+
+// musicSettingsFromConfig returns the settings from music section of config.
+// The program is terminating, if settings are invalid.
+func musicSettingsFromConfig() musicSettings {
+	var s musicSettings
+
+	if loved := config.GetStringMap("music")["loved"]; loved != "" {
+		var err error
+		if s.loved, err = strconv.ParseBool(loved); err != nil {
+			logs.FATAL.Fatalf("invalid loved in music: %q. Use true or false\n", loved)
+		}
+	}
+
+	for playbacks, stars := range config.GetNestedStringMap("music")["ratings"] {
+		var th ratingThreshold
+		var err error
+		if th.playbacks, err = strconv.Atoi(playbacks); err != nil || th.playbacks < 0 {
+			logs.FATAL.Fatalf("invalid playback count in music ratings: %q\n", playbacks)
+		}
+		if th.stars, err = strconv.Atoi(stars); err != nil || th.stars < 0 || th.stars > 5 {
+			logs.FATAL.Fatalf("invalid rating of %v playbacks in music ratings: %q. Use number from 0 to 5\n", th.playbacks, stars)
+		}
+		s.ratings = append(s.ratings, th)
+	}
+	// The highest threshold is checked first.
+	sort.Slice(s.ratings, func(i, j int) bool {
+		return s.ratings[i].playbacks > s.ratings[j].playbacks
+	})
+
+	return s
+}
+
+// properties returns the properties of t in iTunes.
+func (s musicSettings) properties(t track.Track) library.TrackProperties {
+	props := library.TrackProperties{Loved: s.loved}
+	for _, th := range s.ratings {
+		if t.PlaybackCount() >= th.playbacks {
+			props.Rating = th.stars
+			break
+		}
+	}
+	return props
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/tempdir"
+	"github.com/bogem/nehm/track"
+	"github.com/bogem/nehm/util"
+)
+
+var hostileNames = []string{
+	"..", ".", " . ", "../../../etc/passwd", "/etc/passwd", `..\..\Windows\System32`,
+	"a/../../b", "\x00..", "CON", "~/.ssh/id_rsa", "....//....//",
+}
+
+func TestTrackPathStaysInDist(t *testing.T) {
+	dist := filepath.FromSlash("/music/nehm")
+	filenameTemplates := []string{"", "{{.Title}}", "{{.Artist}}/{{.Title}}", "{{.Uploader}}/../{{.Title}}", "../{{.Title}}"}
+	folderTemplates := []string{"", "{{.Artist}}", "{{.Uploader}}/{{.Title}}", "../{{.Artist}}/../..", "/{{.Title}}"}
+	defer track.SetFilenameTemplate("{{.Artist}} — {{.Title}}")
+
+	for _, ft := range filenameTemplates {
+		if ft == "" {
+			track.SetFilenameTemplate("{{.Artist}} — {{.Title}}")
+		} else if err := track.SetFilenameTemplate(ft); err != nil {
+			t.Fatalf("invalid fileNameTemplate %q: %v", ft, err)
+		}
+		for _, dt := range folderTemplates {
+			downloader := Downloader{dist: dist}
+			if dt != "" {
+				downloader.folderTemplate = template.Must(template.New("folderTemplate").Parse(dt))
+			}
+			for _, organizeBy := range []string{"", organizeByUploader} {
+				downloader.organizeBy = organizeBy
+				for i, name := range hostileNames {
+					var tr track.Track
+					tr.JID = i + 1
+					tr.JTitle = name + " - " + name
+					tr.JAuthor.Username = name
+					path := downloader.TrackPath(tr)
+					if !util.IsWithin(dist, path) || path == dist {
+						t.Errorf("fileNameTemplate %q, folderTemplate %q, organizeBy %q: %q leads out of download folder: %q",
+							ft, dt, organizeBy, name, path)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReservePath(t *testing.T) {
+	path := filepath.FromSlash("/music/nehm/Artist — Title.mp3")
+	first, releaseFirst := reservePath(path, 1)
+	second, releaseSecond := reservePath(path, 2)
+	again, releaseAgain := reservePath(path, 1)
+	if first != path || again != path {
+		t.Errorf("path of the first track is changed: %q, %q", first, again)
+	}
+	if second == first {
+		t.Fatalf("tracks 1 and 2 share path %q", first)
+	}
+	releaseAgain()
+	releaseSecond()
+	releaseFirst()
+	if len(reservedPaths) != 0 {
+		t.Errorf("paths aren't released: %v", reservedPaths)
+	}
+}
+
+func TestLongTitleFitsWorkingFiles(t *testing.T) {
+	dist, err := ioutil.TempDir("", "nehm-downloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dist)
+	config.Set("tmpDir", dist)
+	defer config.Set("tmpDir", "")
+
+	var tr track.Track
+	tr.JID = 123456789
+	tr.JTitle = strings.Repeat("x", 300)
+	trackPath := Downloader{dist: dist}.TrackPath(tr)
+
+	partPath, err := tempdir.PartPath(strconv.Itoa(tr.ID()) + originalSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{trackPath, trackPath + tempdir.TmpSuffix, partPath} {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Errorf("couldn't create working file: %v", err)
+			continue
+		}
+		f.Close()
+	}
+}
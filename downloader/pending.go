@@ -0,0 +1,266 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/library"
+	"github.com/bogem/nehm/logs"
+)
+
+// importMu serializes adding of tracks to iTunes, if they're
+// downloaded in parallel.
+var importMu sync.Mutex
+
+// busyRetryDelays are the delays between attempts to add track to iTunes,
+// while it's busy.
+var busyRetryDelays = [...]time.Duration{5 * time.Second, 15 * time.Second, 45 * time.Second}
+
+// pendingImport is the track, which wasn't added to iTunes,
+// because it was busy.
+type pendingImport struct {
+	ID       int    `json:"id"`
+	Path     string `json:"path"`
+	Playlist string `json:"playlist"`
+
+	Loved  bool `json:"loved,omitempty"`
+	Rating int  `json:"rating,omitempty"`
+
+	// RemoveAfterImport is set in importOnly mode. Track was copied
+	// to pendingDir and it's removed after adding to iTunes.
+	RemoveAfterImport bool `json:"remove_after_import,omitempty"`
+}
+
+func pendingImportsPath() string {
+	return filepath.Join(config.StateDir(), "pending_imports.json")
+}
+
+// pendingDir is the folder, where tracks downloaded in importOnly mode
+// are kept until they're added to iTunes.
+func pendingDir() string {
+	return filepath.Join(config.StateDir(), "pending")
+}
+
+// AddToItunes adds track at path to iTunes playlist with props
+// and returns the added track. If iTunes is busy, it retries after busyRetryDelays.
+func AddToItunes(path, playlist string, props library.TrackProperties) (library.AddedTrack, error) {
+	for i := 0; ; i++ {
+		added, err := library.Default.AddTrackToPlaylist(path, playlist, props)
+		if !library.Default.IsBusy(err) || i == len(busyRetryDelays) {
+			if err == nil {
+				logs.INFO.Printf("%q is added to iTunes with persistent ID %v\n", path, added.PersistentID)
+			}
+			return added, err
+		}
+		logs.INFO.Printf("iTunes is busy, retrying in %v\n", busyRetryDelays[i])
+		time.Sleep(busyRetryDelays[i])
+	}
+}
+
+// importBatch is the list of tracks, which are added to iTunes at once
+// after downloading (itunesBatch in config). It's shared by workers.
+type importBatch struct {
+	mu      sync.Mutex
+	imports []pendingImport
+}
+
+func importBatchFromConfig() *importBatch {
+	if !config.GetBool("itunesBatch") {
+		return nil
+	}
+	return new(importBatch)
+}
+
+// add adds track with id and props to batch.
+// Its path is taken from index, when batch is added.
+func (b *importBatch) add(id int, props library.TrackProperties) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.imports = append(b.imports, pendingImport{ID: id, Loved: props.Loved, Rating: props.Rating})
+}
+
+// addBatchToItunes adds tracks of itunesBatch to iTunes with one call.
+// Paths are taken from index, so moved and uploaded tracks are found.
+// If iTunes is busy, tracks are queued to pending imports.
+// It returns failures of tracks, which iTunes didn't add.
+func (downloader Downloader) addBatchToItunes() []failure {
+	var paths []string
+	var props []library.TrackProperties
+	var entries []index.Entry
+	imports := downloader.itunesBatch.imports
+	// Batch is shared by copies of downloader, so it's emptied
+	// for the next call of DownloadAll.
+	downloader.itunesBatch.imports = nil
+	for _, p := range imports {
+		e, exists := index.Get(p.ID)
+		if !exists {
+			continue
+		}
+		paths = append(paths, e.Path)
+		props = append(props, library.TrackProperties{Loved: p.Loved, Rating: p.Rating})
+		entries = append(entries, e)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	logs.FEEDBACK.Printf("Adding %v track(s) to iTunes ... ", len(paths))
+	var results []library.AddResult
+	var err error
+	for i := 0; ; i++ {
+		results, err = library.Default.AddTracksToPlaylist(paths, downloader.itunesPlaylist, props)
+		if !library.Default.IsBusy(err) || i == len(busyRetryDelays) {
+			break
+		}
+		logs.INFO.Printf("iTunes is busy, retrying in %v\n", busyRetryDelays[i])
+		time.Sleep(busyRetryDelays[i])
+	}
+	if err != nil {
+		// None of tracks was added.
+		results = make([]library.AddResult, len(paths))
+		for i := range results {
+			results[i].Err = err
+		}
+	}
+
+	var failures []failure
+	for i, r := range results {
+		e := entries[i]
+		switch {
+		case r.Err == nil:
+			e.ItunesID = r.PersistentID
+			index.Add(e)
+		case library.Default.IsBusy(r.Err):
+			if _, qe := downloader.queueImport(e.ID, e.Path, props[i]); qe != nil {
+				failures = append(failures, failure{e.Fullname(), classified(categoryImport, fmt.Errorf("iTunes is busy and import couldn't be queued: %v", qe))})
+			}
+		default:
+			failures = append(failures, failure{e.Fullname(), classified(categoryImport, fmt.Errorf("couldn't add track to playlist: %v", r.Err))})
+		}
+	}
+	if len(failures) == 0 {
+		logs.FEEDBACK.Println("✔︎")
+	} else {
+		logs.FEEDBACK.Println("✘")
+		for _, f := range failures {
+			logs.ERROR.Printf("couldn't add %q to iTunes: %v\n", f.track, f.err)
+		}
+	}
+	return failures
+}
+
+// queueImport adds track with id at trackPath to pending imports.
+// It returns the path, where track is kept until import.
+func (downloader Downloader) queueImport(id int, trackPath string, props library.TrackProperties) (string, error) {
+	p := pendingImport{
+		ID:       id,
+		Path:     trackPath,
+		Playlist: downloader.itunesPlaylist,
+		Loved:    props.Loved,
+		Rating:   props.Rating,
+	}
+
+	// Temporary folder of importOnly mode is removed after downloading.
+	if downloader.importOnly {
+		if err := os.MkdirAll(pendingDir(), 0755); err != nil {
+			return "", err
+		}
+		p.Path = filepath.Join(pendingDir(), filepath.Base(trackPath))
+		p.RemoveAfterImport = true
+		if err := copyFile(trackPath, p.Path); err != nil {
+			return "", err
+		}
+	}
+
+	pending, err := loadPendingImports()
+	if err != nil {
+		return "", err
+	}
+	return p.Path, savePendingImports(append(pending, p))
+}
+
+// ImportPending adds tracks, which weren't added to iTunes because it was
+// busy, to their playlists. It returns the counts of imported tracks
+// and tracks, which are still pending.
+func ImportPending() (imported, remaining int, err error) {
+	pending, err := loadPendingImports()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var left []pendingImport
+	for _, p := range pending {
+		logs.FEEDBACK.Printf("Adding %q to iTunes ... ", filepath.Base(p.Path))
+		added, err := AddToItunes(p.Path, p.Playlist, library.TrackProperties{Loved: p.Loved, Rating: p.Rating})
+		if err == nil && p.RemoveAfterImport {
+			err = removeImported(p.Path, added.Location)
+		}
+		if err != nil {
+			logs.FEEDBACK.Println("✘")
+			logs.ERROR.Printf("couldn't add %q to playlist: %v\n", p.Path, err)
+			left = append(left, p)
+			continue
+		}
+		logs.FEEDBACK.Println("✔︎")
+
+		if e, exists := index.Get(p.ID); exists {
+			if p.RemoveAfterImport {
+				e.Path = added.Location
+			}
+			e.ItunesID = added.PersistentID
+			index.Add(e)
+		}
+		imported++
+	}
+
+	if err := index.Save(); err != nil {
+		logs.ERROR.Println("couldn't save the index of downloaded tracks:", err)
+	}
+	return imported, len(left), savePendingImports(left)
+}
+
+func loadPendingImports() ([]pendingImport, error) {
+	data, err := ioutil.ReadFile(pendingImportsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the list of pending imports: %v", err)
+	}
+
+	var pending []pendingImport
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal the list of pending imports: %v", err)
+	}
+	return pending, nil
+}
+
+func savePendingImports(pending []pendingImport) error {
+	if len(pending) == 0 {
+		if err := os.Remove(pendingImportsPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.StateDir(), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pendingImportsPath(), data, 0644)
+}
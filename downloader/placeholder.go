@@ -0,0 +1,137 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"bytes"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // Avatars can be in PNG.
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	placeholderSize = 500
+
+	// placeholderTextScale is the scale of 7x13 font,
+	// so text is readable on the cover.
+	placeholderTextScale = 3
+	placeholderMaxLines  = 4
+)
+
+// placeholderArtwork generates the cover for track without artwork:
+// avatar of uploader (or, if there is no avatar, color generated
+// from uploader) with title on the dark band at the bottom.
+func placeholderArtwork(avatar []byte, uploader, title string) ([]byte, error) {
+	cover := image.NewRGBA(image.Rect(0, 0, placeholderSize, placeholderSize))
+
+	if img, _, err := image.Decode(bytes.NewReader(avatar)); err == nil {
+		scaleInto(cover, img)
+	} else {
+		draw.Draw(cover, cover.Bounds(), image.NewUniform(uploaderColor(uploader)), image.ZP, draw.Src)
+	}
+
+	lines := wrapText(title, placeholderSize/(basicfont.Face7x13.Advance*placeholderTextScale)-2)
+	if len(lines) > placeholderMaxLines {
+		lines = lines[:placeholderMaxLines]
+		lines[placeholderMaxLines-1] += "..."
+	}
+	lineHeight := basicfont.Face7x13.Height * placeholderTextScale
+	bandHeight := lineHeight*len(lines) + 2*lineHeight/2
+
+	// Darken the band for text.
+	band := image.Rect(0, placeholderSize-bandHeight, placeholderSize, placeholderSize)
+	draw.Draw(cover, band, image.NewUniform(color.RGBA{0, 0, 0, 0xb0}), image.ZP, draw.Over)
+
+	// Text is drawn in original size and then scaled to the band.
+	textWidth := placeholderSize / placeholderTextScale
+	text := image.NewRGBA(image.Rect(0, 0, textWidth, bandHeight/placeholderTextScale))
+	d := font.Drawer{Dst: text, Src: image.White, Face: basicfont.Face7x13}
+	for i, line := range lines {
+		width := d.MeasureString(line).Round()
+		d.Dot = fixed.P((textWidth-width)/2, basicfont.Face7x13.Height/2+basicfont.Face7x13.Ascent+i*basicfont.Face7x13.Height)
+		d.DrawString(line)
+	}
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		for x := band.Min.X; x < band.Max.X; x++ {
+			c := text.RGBAAt(x/placeholderTextScale, (y-band.Min.Y)/placeholderTextScale)
+			if c.A > 0 {
+				cover.Set(x, y, c)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, cover, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleInto draws src to dst scaled to the size of dst
+// with nearest-neighbour interpolation.
+func scaleInto(dst *image.RGBA, src image.Image) {
+	sb, db := src.Bounds(), dst.Bounds()
+	for y := 0; y < db.Dy(); y++ {
+		sy := sb.Min.Y + y*sb.Dy()/db.Dy()
+		for x := 0; x < db.Dx(); x++ {
+			sx := sb.Min.X + x*sb.Dx()/db.Dx()
+			dst.Set(db.Min.X+x, db.Min.Y+y, src.At(sx, sy))
+		}
+	}
+}
+
+// uploaderColor returns the dark color, which is always the same
+// for uploader.
+func uploaderColor(uploader string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(uploader))
+	sum := h.Sum32()
+	return color.RGBA{uint8(sum>>16)/2 + 0x20, uint8(sum>>8)/2 + 0x20, uint8(sum)/2 + 0x20, 0xff}
+}
+
+// wrapText splits text to lines no longer than width runes.
+// Runes, which are not in the font, are replaced with "?".
+func wrapText(text string, width int) []string {
+	text = strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '?'
+		}
+		return r
+	}, text)
+
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		for len(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
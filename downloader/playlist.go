@@ -0,0 +1,231 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogem/id3v2"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/library"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/menu"
+	"github.com/bogem/nehm/track"
+	"github.com/bogem/nehm/util"
+	isatty "github.com/mattn/go-isatty"
+)
+
+// Targets of playlist builder (playlistBuilder in config).
+const (
+	playlistM3U    = "m3u"
+	playlistItunes = "itunes"
+	playlistBoth   = "both"
+)
+
+// buildPlaylist lets user pick some of downloaded tracks and puts them
+// to new named playlist in M3U file and/or iTunes.
+func (downloader Downloader) buildPlaylist(tracks []track.Track) {
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		logs.WARN.Println("playlist builder is skipped, because nehm isn't running in terminal")
+		return
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	logs.FEEDBACK.Print("\nBuild a playlist from downloaded tracks? [y/N]: ")
+	answer, _ := stdin.ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		return
+	}
+
+	selected := menu.NewTracksMenuFromTracks(tracks, 9).Show()
+	if len(selected) == 0 {
+		return
+	}
+
+	var name string
+	for name == "" {
+		logs.FEEDBACK.Print("Name of playlist: ")
+		name, _ = stdin.ReadString('\n')
+		name = strings.TrimSpace(name)
+	}
+
+	target := downloader.playlistBuilder
+	if target == playlistM3U || target == playlistBoth {
+		if downloader.importOnly {
+			logs.WARN.Println("M3U playlist isn't written in importOnly mode")
+		} else {
+			path := filepath.Join(downloader.dist, util.SanitizeFilename(name)+".m3u")
+			if err := writeM3U(path, downloader.dist, selected, downloader.paths); err != nil {
+				logs.ERROR.Println("couldn't write playlist:", err)
+			} else {
+				logs.FEEDBACK.Println("Playlist is written to", path)
+			}
+		}
+	}
+	if target == playlistItunes || target == playlistBoth {
+		if err := addToItunesPlaylist(name, selected, downloader.paths); err != nil {
+			logs.ERROR.Println("couldn't create playlist in iTunes:", err)
+		} else {
+			logs.FEEDBACK.Printf("Playlist %q is created in iTunes\n", name)
+		}
+	}
+}
+
+// writeM3U writes extended M3U playlist with downloaded tracks to path.
+// Paths of tracks in dir are relative.
+func writeM3U(path, dir string, tracks []track.Track, paths map[int]string) error {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	for _, t := range tracks {
+		e, exists := downloadedEntry(t, paths)
+		if !exists {
+			continue
+		}
+		fmt.Fprintf(&buf, "#EXTINF:%v,%v\n%v\n", t.JDuration/1000, e.Fullname(), m3uPath(dir, e.Path))
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// appendPlaylistFile appends downloaded tracks to playlistFile
+// (--playlist-file flag) in order of tracks. Tracks, which are already
+// in playlist, aren't added again.
+func (downloader Downloader) appendPlaylistFile(tracks []track.Track) {
+	path := downloader.playlistFile
+	if filepath.Ext(path) == "" {
+		path += ".m3u8"
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(downloader.dist, path)
+	}
+
+	n, err := appendM3U8(path, tracks, downloader.paths)
+	if err != nil {
+		logs.ERROR.Println("couldn't write playlist file:", err)
+		return
+	}
+	logs.FEEDBACK.Printf("%v track(s) are added to playlist %v\n", n, path)
+}
+
+// appendM3U8 appends extended M3U entries of downloaded tracks to UTF-8
+// playlist at path, which is created, if it doesn't exist. Artists and
+// titles are read from tags. It returns the count of added tracks.
+func appendM3U8(path string, tracks []track.Track, paths map[int]string) (int, error) {
+	dir := filepath.Dir(path)
+	listed := make(map[string]bool)
+	existing, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(existing), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			listed[line] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	if len(existing) == 0 {
+		buf.WriteString("#EXTM3U\n")
+	} else if !bytes.HasSuffix(existing, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	var n int
+	for _, t := range tracks {
+		e, exists := downloadedEntry(t, paths)
+		if !exists {
+			continue
+		}
+		trackPath := m3uPath(dir, e.Path)
+		if listed[trackPath] {
+			continue
+		}
+		listed[trackPath] = true
+		artist, title := tagNames(e)
+		fmt.Fprintf(&buf, "#EXTINF:%v,%v - %v\n%v\n", t.JDuration/1000, artist, title, trackPath)
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	if err := mkdirAll(dir); err != nil {
+		return 0, fmt.Errorf("couldn't create folder of playlist: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return n, f.Close()
+}
+
+// tagNames returns artist and title from tag of MP3 file of e.
+// Names from index are returned, if they're not in tag.
+func tagNames(e index.Entry) (artist, title string) {
+	artist, title = e.Artist, e.Title
+	if !strings.EqualFold(filepath.Ext(e.Path), ".mp3") {
+		return artist, title
+	}
+	tag, err := id3v2.Open(e.Path, id3v2.Options{Parse: true, ParseFrames: []string{"Artist", "Title"}})
+	if err != nil {
+		return artist, title
+	}
+	defer tag.Close()
+	if a := tag.Artist(); a != "" {
+		artist = a
+	}
+	if t := tag.Title(); t != "" {
+		title = t
+	}
+	return artist, title
+}
+
+// m3uPath returns the path of track at trackPath in playlist.
+// Paths of tracks in dir are relative.
+func m3uPath(dir, trackPath string) string {
+	if util.IsWithin(dir, trackPath) {
+		if rel, err := filepath.Rel(dir, trackPath); err == nil {
+			return rel
+		}
+	}
+	return trackPath
+}
+
+// addToItunesPlaylist creates iTunes playlist with name
+// and adds downloaded tracks to it.
+func addToItunesPlaylist(name string, tracks []track.Track, paths map[int]string) error {
+	if err := library.Default.CreatePlaylist(name); err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		e, exists := downloadedEntry(t, paths)
+		if !exists {
+			continue
+		}
+		if _, err := library.Default.AddTrackToPlaylist(e.Path, name, library.TrackProperties{}); err != nil {
+			logs.ERROR.Printf("couldn't add %q to playlist: %v\n", e.Fullname(), err)
+		}
+	}
+	return nil
+}
+
+// downloadedEntry returns the entry of t in index with the path from
+// paths, where t was written in batch. They differ, if t was linked
+// to another folder.
+func downloadedEntry(t track.Track, paths map[int]string) (index.Entry, bool) {
+	e, exists := index.Get(t.ID())
+	if path, ok := paths[t.ID()]; exists && ok {
+		e.Path = path
+	}
+	return e, exists
+}
@@ -0,0 +1,202 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/coord"
+	"github.com/bogem/nehm/httpclient"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/progress"
+	"github.com/bogem/nehm/track"
+)
+
+// maxDownloadWorkers limits downloadWorkers, so SoundCloud doesn't
+// reject requests.
+const maxDownloadWorkers = 16
+
+// adaptiveWorkers is the value of downloadWorkers, with which
+// the count of workers is tuned by throughput and errors.
+const adaptiveWorkers = "auto"
+
+// initialAdaptiveWorkers is the count of workers, with which
+// adaptive downloading starts.
+const initialAdaptiveWorkers = 2
+
+// downloadWorkersFromConfig returns the count of tracks downloaded
+// at the same time (downloadWorkers in config). Default is 1.
+// In polite mode it's always 1.
+// If it's "auto", adaptive is true and n is the maximum count.
+// The program is terminating, if value is invalid.
+func downloadWorkersFromConfig() (n int, adaptive bool) {
+	value := config.Get("downloadWorkers")
+	if value == "" {
+		return 1, false
+	}
+	if httpclient.Polite() {
+		logs.INFO.Println("downloadWorkers is ignored in polite mode, tracks are downloaded one by one")
+		return 1, false
+	}
+	if value == adaptiveWorkers {
+		return maxDownloadWorkers, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxDownloadWorkers {
+		logs.FATAL.Fatalf("invalid downloadWorkers %q: should be %q or number from 1 to %v\n", value, adaptiveWorkers, maxDownloadWorkers)
+	}
+	return n, false
+}
+
+// buffers are used for reusing memory while downloading artworks.
+// Each worker has its own buffers.
+type buffers struct {
+	artwork []byte
+}
+
+// job is the track, which should be downloaded by worker.
+type job struct {
+	track track.Track
+	event progress.Event
+}
+
+// result is the result of downloading of track.
+type result struct {
+	job
+	status *status
+	tm     *timings
+	start  time.Time
+	// path is the path, where track was written.
+	path string
+	err  error
+}
+
+// startWorkers starts n workers, which download tracks from jobs
+// and send results to returned channel. The channel is closed,
+// when jobs is closed and all workers are finished. If lim is not nil,
+// only lim.limit workers download tracks at the same time.
+func (downloader Downloader) startWorkers(n int, lim *limiter, jobs <-chan job) <-chan result {
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+
+			bufs := new(buffers)
+			for {
+				if lim != nil {
+					lim.acquire()
+				}
+				j, ok := <-jobs
+				if !ok {
+					if lim != nil {
+						lim.cancel()
+					}
+					return
+				}
+
+				j.event.Type = progress.TrackStart
+				progress.Emit(j.event)
+
+				r := result{job: j, status: newStatus(n > 1, j.event), tm: newTimings(), start: time.Now()}
+				r.path, r.err = downloader.download(j.track, r.tm, r.status, bufs)
+				releaseClaim(j.track.ID(), r.err)
+				if lim != nil {
+					lim.release(downloadedSize(j.track.ID(), r.err), r.err)
+				}
+				results <- r
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// releaseClaim releases the claim of track with id and reports
+// its entry to other nehm processes, if track was downloaded.
+func releaseClaim(id int, err error) {
+	if err == nil {
+		if e, exists := index.Get(id); exists {
+			coord.Release(id, &e)
+			return
+		}
+	}
+	coord.Release(id, nil)
+}
+
+// downloadedSize returns the size of downloaded track with id.
+func downloadedSize(id int, err error) int64 {
+	if err != nil {
+		return 0
+	}
+	if e, exists := index.Get(id); exists {
+		if fi, err := os.Stat(e.Path); err == nil {
+			return fi.Size()
+		}
+	}
+	return 0
+}
+
+// status prints the progress of processing of one track. If tracks are
+// downloaded in parallel, stages are collected and printed in one line,
+// when track is processed, so lines of different tracks don't interleave.
+type status struct {
+	parallel bool
+	prefix   string
+	stages   []string
+}
+
+func newStatus(parallel bool, e progress.Event) *status {
+	return &status{
+		parallel: parallel,
+		prefix:   fmt.Sprintf("[%v/%v] ", e.Index, e.Total),
+	}
+}
+
+// start prints the first stage of processing of track with name.
+func (s *status) start(name string) {
+	if s.parallel {
+		logs.FEEDBACK.Printf("%vDownloading %q\n", s.prefix, name)
+		s.stages = append(s.stages, fmt.Sprintf("%q ... ", name))
+		return
+	}
+	logs.FEEDBACK.Printf("Downloading %q ... ", name)
+}
+
+// print prints the stage of processing, e.g. "uploading ... ".
+func (s *status) print(stage string) {
+	if s.parallel {
+		s.stages = append(s.stages, stage)
+		return
+	}
+	logs.FEEDBACK.Print(stage)
+}
+
+// done prints the result of processing.
+func (s *status) done(ok bool) {
+	mark := "✔︎"
+	if !ok {
+		mark = "✘"
+	}
+	if s.parallel {
+		logs.FEEDBACK.Println(s.prefix + strings.Join(s.stages, "") + mark)
+		return
+	}
+	logs.FEEDBACK.Println(mark)
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"encoding/json"
+
+	"github.com/bogem/id3v2"
+)
+
+// Version is the version of nehm written to tags of tracks.
+var Version string
+
+// sourceDescription is the description of TXXX frame with provenance
+// of track. Unlike artist and title it doesn't change on renames, so files
+// can be matched with tracks on SoundCloud reliably.
+const sourceDescription = "NEHM_SOURCE"
+
+// batchLayout is the layout of the date of batch, in which track
+// was downloaded.
+const batchLayout = "2006-01-02"
+
+// source is the provenance of downloaded track.
+type source struct {
+	ID           int    `json:"id"`
+	Batch        string `json:"batch"`
+	Version      string `json:"version,omitempty"`
+	PermalinkURL string `json:"permalink_url,omitempty"`
+	// Encoding is the preset of transcoding selected by quality.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// addSourceFrame adds TXXX frame with s as JSON to tag.
+func addSourceFrame(tag *id3v2.Tag, s source, enc id3v2.Encoding) {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+		Encoding:    enc,
+		Description: sourceDescription,
+		Value:       string(value),
+	})
+}
+
+// parseSource parses the value of TXXX frame with provenance.
+func parseSource(value string) (source, bool) {
+	var s source
+	if err := json.Unmarshal([]byte(value), &s); err != nil || s.ID == 0 {
+		return s, false
+	}
+	return s, true
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is FICLONE ioctl request from linux/fs.h.
+const ficlone = 0x40049409
+
+// reflink clones src to dst on filesystems with copy-on-write support
+// (Btrfs, XFS).
+func reflink(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	if errno != 0 {
+		out.Close()
+		os.Remove(dst)
+		return errno
+	}
+	return out.Close()
+}
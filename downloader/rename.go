@@ -0,0 +1,66 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogem/nehm/audit"
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/filelock"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/track"
+	"github.com/bogem/nehm/util"
+)
+
+// Rename sets the current artist and title of t to the tag of downloaded
+// track e and renames its file according to them. Extension of file
+// is kept, so FLAC, M4A and Opus files stay recognizable.
+// It returns e with updated path and names.
+func Rename(e index.Entry, t track.Track) (index.Entry, error) {
+	if config.GetBool("archive") {
+		return e, errors.New("tracks can't be renamed in archive mode")
+	}
+
+	dir := filepath.Dir(e.Path)
+	name := t.Filename()
+	name = strings.TrimSuffix(name, filepath.Ext(name)) + filepath.Ext(e.Path)
+	newPath := filepath.Join(dir, name)
+	if other, exists := index.GetByPath(newPath); exists && other.ID != e.ID {
+		newPath = withID(newPath, e.ID)
+	}
+	if !util.IsWithin(dir, newPath) {
+		return e, fmt.Errorf("refusing to move %q outside of its folder", e.Path)
+	}
+
+	err := writeFields(e.Path, map[string]string{fieldArtist: t.Artist(), fieldTitle: t.Title()})
+	if err != nil {
+		return e, err
+	}
+
+	if newPath != e.Path {
+		if _, err := os.Stat(newPath); err == nil {
+			return e, fmt.Errorf("file %q already exists", newPath)
+		}
+		err := filelock.Do(e.Path, func() error {
+			return os.Rename(e.Path, newPath)
+		})
+		if err != nil {
+			return e, fmt.Errorf("couldn't rename track file: %v", err)
+		}
+		audit.Log(audit.Rename, e.Path+" → "+newPath, "track was renamed on SoundCloud")
+	}
+
+	e.OldNames = append(e.OldNames, e.Fullname())
+	e.Path = newPath
+	e.Filename = filepath.Base(newPath)
+	e.Artist = t.Artist()
+	e.Title = t.Title()
+	return e, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/track"
+)
+
+// RescanResult is the result of Rescan.
+type RescanResult struct {
+	// Found is the count of tracks found in folder.
+	Found int
+	// Untagged is the count of mp3 files without ID of track in tag,
+	// e.g. downloaded by old versions of nehm.
+	Untagged int
+	// Removed is the count of entries removed from index,
+	// because their files don't exist.
+	Removed int
+}
+
+// Rescan rebuilds the index from tags of mp3 files in folder. Entries,
+// whose files don't exist, are removed. Fields, which are not
+// in tags (e.g. purchased, protected), are kept from existing entries.
+func Rescan(folder string) (RescanResult, error) {
+	var r RescanResult
+
+	err := filepath.Walk(folder, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			logs.WARN.Println(err)
+			return nil
+		}
+		if fi.IsDir() || !strings.EqualFold(filepath.Ext(path), ".mp3") {
+			return nil
+		}
+
+		tagged, ok := entryFromTag(path)
+		if !ok {
+			r.Untagged++
+			return nil
+		}
+		e, exists := index.Get(tagged.ID)
+		if !exists {
+			e = tagged
+			if e.AddedAt.IsZero() {
+				e.AddedAt = fi.ModTime()
+			}
+		}
+		e.Path = tagged.Path
+		e.Filename = tagged.Filename
+		e.Artist = tagged.Artist
+		e.Title = tagged.Title
+		e.Album = tagged.Album
+		e.Genre = tagged.Genre
+		e.TrackNumber = tagged.TrackNumber
+		index.Add(e)
+		r.Found++
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+
+	for _, e := range index.All() {
+		if _, err := os.Stat(e.Path); os.IsNotExist(err) {
+			index.Remove(e.ID)
+			r.Removed++
+		}
+	}
+	return r, index.Save()
+}
+
+// entryFromTag returns the index entry made from tag of file at path.
+// It returns false, if there is no ID of track in tag.
+func entryFromTag(path string) (index.Entry, bool) {
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		logs.WARN.Printf("couldn't read tag of %q: %v\n", path, err)
+		return index.Entry{}, false
+	}
+	defer tag.Close()
+
+	var id int
+	var src source
+	var hasSource bool
+	for _, f := range tag.GetFrames(tag.CommonID("User defined text information frame")) {
+		udtf, ok := f.(id3v2.UserDefinedTextFrame)
+		if !ok {
+			continue
+		}
+		switch udtf.Description {
+		case idDescription:
+			id, _ = strconv.Atoi(udtf.Value)
+		case sourceDescription:
+			src, hasSource = parseSource(udtf.Value)
+		}
+	}
+	if id == 0 && hasSource {
+		id = src.ID
+	}
+	if id == 0 {
+		return index.Entry{}, false
+	}
+
+	e := index.Entry{
+		ID:       id,
+		Path:     path,
+		Filename: filepath.Base(path),
+		Artist:   tag.Artist(),
+		Title:    tag.Title(),
+		Album:    tag.Album(),
+		Genre:    tag.Genre(),
+	}
+	if hasSource {
+		e.AddedAt, _ = time.Parse(batchLayout, src.Batch)
+	}
+	if e.Album != "" {
+		// TRCK can be "3/12".
+		trck := tag.GetTextFrame("TRCK").Text
+		e.TrackNumber, _ = strconv.Atoi(strings.SplitN(trck, "/", 2)[0])
+	}
+	return e, true
+}
+
+// skipDownloaded separates tracks, which are in index and whose files exist.
+// In link mode tracks are kept, if they'll be downloaded to other path,
+// which isn't linked yet, because they'll be linked to downloaded copy.
+func (downloader Downloader) skipDownloaded(tracks []track.Track) (kept, skipped []track.Track) {
+	kept = make([]track.Track, 0, len(tracks))
+	for _, t := range tracks {
+		e, exists := index.Get(t.ID())
+		path := e.Path
+		if exists && downloader.linkMode != "" {
+			if p := downloader.TrackPath(t); p != e.Path {
+				if !e.HasLink(p) {
+					kept = append(kept, t)
+					continue
+				}
+				path = p
+			}
+		}
+		if exists {
+			if _, err := os.Stat(path); err == nil {
+				logs.INFO.Printf("skipping %q: it's already downloaded to %q\n", t.Fullname(), path)
+				skipped = append(skipped, t)
+				continue
+			}
+		}
+		kept = append(kept, t)
+	}
+	return kept, skipped
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2"
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/filelock"
+	"github.com/bogem/nehm/format"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/tags"
+	"github.com/bogem/nehm/track"
+)
+
+// Retag sets fields (see TagFields) to the tag of downloaded track e.
+// File of track is not renamed. It returns e with updated fields.
+func Retag(e index.Entry, fields map[string]string) (index.Entry, error) {
+	if config.GetBool("archive") {
+		return e, errors.New("tracks can't be retagged in archive mode")
+	}
+
+	if err := writeFields(e.Path, fields); err != nil {
+		return e, err
+	}
+
+	for field, value := range fields {
+		switch field {
+		case fieldArtist:
+			e.Artist = value
+		case fieldTitle:
+			e.Title = value
+		case fieldAlbum:
+			e.Album = value
+		case fieldGenre:
+			e.Genre = value
+		case fieldTrack:
+			e.TrackNumber, _ = strconv.Atoi(value)
+		}
+	}
+	return e, nil
+}
+
+// writeFields sets fields to the tags of file at path. Format of file is
+// sniffed, so FLAC, M4A and Opus files get their own tags instead of ID3.
+func writeFields(path string, fields map[string]string) error {
+	f, err := format.SniffFile(path)
+	if err != nil {
+		return fmt.Errorf("couldn't detect format: %v", err)
+	}
+	if f != format.MP3 && f != format.Unknown {
+		tagger := tags.For(f)
+		if tagger == nil {
+			return fmt.Errorf("tags can't be written to %v files", f.Name)
+		}
+		m := tags.Metadata{
+			Artist: fields[fieldArtist],
+			Title:  fields[fieldTitle],
+			Album:  fields[fieldAlbum],
+			Genre:  fields[fieldGenre],
+			Year:   fields[fieldYear],
+		}
+		m.TrackNumber, _ = strconv.Atoi(fields[fieldTrack])
+		return filelock.Do(path, func() error {
+			return tagger.WriteTags(path, m)
+		})
+	}
+
+	return editTag(path, func(tag *id3v2.Tag) {
+		enc := configuredTagEncoding()
+		tag.SetVersion(configuredID3Version())
+		tag.SetDefaultEncoding(enc)
+		setFields(tag, fields, enc)
+	})
+}
+
+// RefreshDescription replaces the description of downloaded track e
+// in its tag (USLT frame, see embedDescription) and the comment
+// (COMM frame, see tagComment) with the current ones of t.
+// It returns e with updated hash of description.
+func RefreshDescription(e index.Entry, t track.Track) (index.Entry, error) {
+	if config.GetBool("archive") {
+		return e, errors.New("tracks can't be retagged in archive mode")
+	}
+	if !strings.EqualFold(filepath.Ext(e.Path), ".mp3") {
+		return e, errors.New("description can be refreshed only in MP3 files")
+	}
+
+	err := editTag(e.Path, func(tag *id3v2.Tag) {
+		// Lyrics frames with other descriptors are kept.
+		var embedded bool
+		frames := tag.GetFrames("USLT")
+		tag.DeleteFrames("USLT")
+		for _, f := range frames {
+			if uslt, ok := f.(id3v2.UnsynchronisedLyricsFrame); ok && uslt.ContentDescriptor == descriptionDescriptor {
+				embedded = true
+				continue
+			}
+			tag.AddFrame("USLT", f)
+		}
+
+		enc := configuredTagEncoding()
+		tag.SetVersion(configuredID3Version())
+		lang := commentLanguage(config.Get("tagLanguage"))
+
+		// Only the comment of nehm (without descriptor) is refreshed.
+		if !disabledFramesFromConfig()[frameComment] {
+			comments := tag.GetFrames(tag.CommonID("Comments"))
+			tag.DeleteFrames(tag.CommonID("Comments"))
+			for _, f := range comments {
+				if comm, ok := f.(id3v2.CommentFrame); ok && comm.Description == "" {
+					continue
+				}
+				tag.AddFrame(tag.CommonID("Comments"), f)
+			}
+			if comment := commentText(config.Get("tagComment"), t); comment != "" {
+				tag.AddCommentFrame(id3v2.CommentFrame{
+					Encoding: enc,
+					Language: lang,
+					Text:     comment,
+				})
+			}
+		}
+
+		if desc := strings.TrimSpace(t.Description()); desc != "" && (embedded || config.GetBool("embedDescription")) {
+			tag.AddUnsynchronisedLyricsFrame(id3v2.UnsynchronisedLyricsFrame{
+				Encoding:          enc,
+				Language:          lang,
+				ContentDescriptor: descriptionDescriptor,
+				Lyrics:            desc,
+			})
+		}
+	})
+	if err != nil {
+		return e, err
+	}
+
+	e.DescriptionHash = t.DescriptionHash()
+	return e, nil
+}
@@ -0,0 +1,354 @@
+// Copyright 2016 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2"
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/filelock"
+	"github.com/bogem/nehm/langdetect"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/tags"
+	"github.com/bogem/nehm/track"
+)
+
+// configuredID3Version returns the version of ID3v2 tags set
+// in id3Version key: 3 or 4. By default it's 4.
+func configuredID3Version() byte {
+	switch v := config.Get("id3Version"); v {
+	case "", "4", "2.4", "v2.4":
+		return 4
+	case "3", "2.3", "v2.3":
+		return 3
+	default:
+		logs.FATAL.Fatalf("invalid id3Version %q. Use 2.3 or 2.4.\n", v)
+		return 4
+	}
+}
+
+// configuredTagEncoding returns the encoding set in tagEncoding key.
+// By default it's UTF-8 or UTF-16 for ID3v2.3, which doesn't support UTF-8.
+func configuredTagEncoding() id3v2.Encoding {
+	v3 := configuredID3Version() == 3
+	switch enc := strings.ToLower(config.Get("tagEncoding")); enc {
+	case "":
+		if v3 {
+			return id3v2.EncodingUTF16
+		}
+		return id3v2.EncodingUTF8
+	case "utf-8", "utf8":
+		if v3 {
+			logs.FATAL.Fatalln("UTF-8 can't be used in ID3v2.3 tags. Use utf-16 or iso-8859-1 as tagEncoding.")
+		}
+		return id3v2.EncodingUTF8
+	case "utf-16", "utf16":
+		return id3v2.EncodingUTF16
+	case "iso-8859-1", "latin1":
+		return id3v2.EncodingISO
+	default:
+		logs.FATAL.Fatalf("invalid tag encoding %q. Use utf-8, utf-16 or iso-8859-1.\n", enc)
+		return id3v2.EncodingUTF8
+	}
+}
+
+// Fields of tag, which can be set with setFields.
+const (
+	fieldArtist = "artist"
+	fieldTitle  = "title"
+	fieldAlbum  = "album"
+	fieldGenre  = "genre"
+	fieldYear   = "year"
+	fieldTrack  = "track"
+)
+
+// TagFields are the fields of tag, which can be set with Retag.
+var TagFields = [...]string{fieldArtist, fieldTitle, fieldAlbum, fieldGenre, fieldYear, fieldTrack}
+
+// Frames, which are written only on download.
+const (
+	frameComposer = "composer"
+	frameComment  = "comment"
+	frameURL      = "url"
+)
+
+// optionalFrames are the frames, which can be disabled
+// with disabledTagFrames in config.
+var optionalFrames = [...]string{fieldAlbum, fieldGenre, fieldYear, fieldTrack, frameComposer, frameComment, frameURL}
+
+// disabledFramesFromConfig returns the frames, which shouldn't be
+// written to tags (disabledTagFrames in config). The program is
+// terminating, if there is an unknown frame.
+func disabledFramesFromConfig() map[string]bool {
+	disabled := make(map[string]bool)
+	for _, frame := range config.GetStringSlice("disabledTagFrames") {
+		frame = strings.ToLower(strings.TrimSpace(frame))
+		var known bool
+		for _, f := range optionalFrames {
+			known = known || f == frame
+		}
+		if !known {
+			logs.FATAL.Fatalf("unknown frame %q in disabledTagFrames. Use some of: %v.\n", frame, strings.Join(optionalFrames[:], ", "))
+		}
+		disabled[frame] = true
+	}
+	return disabled
+}
+
+// editTag opens the tag of file at path, edits it by edit and saves it.
+// File is locked while it's edited and editing is retried, if file is busy
+// (e.g. it's being played from shared folder).
+func editTag(path string, edit func(tag *id3v2.Tag)) error {
+	return filelock.Do(path, func() error {
+		tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+		if filelock.IsBusy(err) {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("couldn't open track file: %v", err)
+		}
+		defer tag.Close()
+
+		edit(tag)
+		if err := tag.Save(); filelock.IsBusy(err) {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("couldn't save tag: %v", err)
+		}
+		return nil
+	})
+}
+
+// setFields sets fields to tag. Blank values are not set.
+func setFields(tag *id3v2.Tag, fields map[string]string, enc id3v2.Encoding) {
+	for field, value := range fields {
+		if value == "" {
+			continue
+		}
+		switch field {
+		case fieldArtist:
+			tag.SetArtist(value)
+		case fieldTitle:
+			tag.SetTitle(value)
+		case fieldAlbum:
+			tag.SetAlbum(value)
+		case fieldGenre:
+			tag.SetGenre(value)
+		case fieldYear:
+			tag.SetYear(value)
+		case fieldTrack:
+			tag.AddTextFrame("TRCK", enc, value)
+		}
+	}
+}
+
+// commentLanguage returns ISO-639-2 code of language of comments
+// and lyrics. COMM and USLT frames require a language,
+// so it's "eng" by default.
+func commentLanguage(tagLanguage string) string {
+	if len(tagLanguage) == 3 {
+		return tagLanguage
+	}
+	return "eng"
+}
+
+// descriptionDescriptor is the content descriptor of USLT frame
+// with description of track.
+const descriptionDescriptor = "Description"
+
+// idDescription is the description of TXXX frame with ID of track
+// on SoundCloud. It lets Rescan rebuild the index from tags.
+const idDescription = "SoundCloud ID"
+
+// writeTag writes ID3 tag of t with artwork to w. trackNumber is written
+// only if album is set. If waveform is not empty, it's embedded
+// as the second picture. Frames of existing are kept and only
+// missing frames are added to them.
+func (downloader Downloader) writeTag(t track.Track, trackNumber int, w io.Writer, artwork, waveform []byte, existing map[string][]id3v2.Framer) error {
+	tag := id3v2.NewEmptyTag()
+	tag.SetVersion(downloader.id3Version)
+	tag.SetDefaultEncoding(downloader.tagEncoding)
+
+	fields := map[string]string{
+		fieldArtist: t.Artist(),
+		fieldTitle:  t.Title(),
+		fieldYear:   t.Year(),
+		fieldGenre:  t.Genre(),
+	}
+	if downloader.album != "" {
+		fields[fieldAlbum] = downloader.album
+		fields[fieldTrack] = strconv.Itoa(trackNumber)
+	}
+	for frame := range downloader.disabledFrames {
+		delete(fields, frame)
+	}
+	setFields(tag, fields, downloader.tagEncoding)
+
+	if !downloader.disabledFrames[frameComposer] {
+		tag.AddTextFrame("TCOM", downloader.tagEncoding, t.Artist())
+	}
+	if !downloader.disabledFrames[frameComment] {
+		if comment := commentText(downloader.tagComment, t); comment != "" {
+			tag.AddCommentFrame(id3v2.CommentFrame{
+				Encoding: downloader.tagEncoding,
+				Language: commentLanguage(downloader.tagLanguage),
+				Text:     comment,
+			})
+		}
+	}
+	if desc := strings.TrimSpace(t.Description()); downloader.embedDescription && desc != "" {
+		tag.AddUnsynchronisedLyricsFrame(id3v2.UnsynchronisedLyricsFrame{
+			Encoding:          downloader.tagEncoding,
+			Language:          commentLanguage(downloader.tagLanguage),
+			ContentDescriptor: descriptionDescriptor,
+			Lyrics:            desc,
+		})
+	}
+	if url := t.PermalinkURL(); url != "" && !downloader.disabledFrames[frameURL] {
+		// URL frames are always in ISO-8859-1 and have no encoding byte.
+		tag.AddFrame("WOAF", id3v2.UnknownFrame{Body: []byte(url)})
+	}
+	tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+		Encoding:    downloader.tagEncoding,
+		Description: idDescription,
+		Value:       strconv.Itoa(t.ID()),
+	})
+	addSourceFrame(tag, source{
+		ID:           t.ID(),
+		Batch:        downloader.batch,
+		Version:      Version,
+		PermalinkURL: t.PermalinkURL(),
+		Encoding:     downloader.encoding,
+	}, downloader.tagEncoding)
+
+	language := downloader.tagLanguage
+	if downloader.detectLanguage {
+		if l, ok := langdetect.Detect(t.Title() + "\n" + t.Description()); ok {
+			if language == "" {
+				language = l.Code
+			}
+			tag.AddTextFrame("TIT1", downloader.tagEncoding, l.Name)
+		}
+	}
+	if language != "" {
+		tag.AddTextFrame("TLAN", downloader.tagEncoding, language)
+	}
+
+	if len(artwork) > 0 {
+		pic := id3v2.PictureFrame{
+			Encoding:    downloader.tagEncoding,
+			MimeType:    artworkMIME(artwork),
+			PictureType: id3v2.PTFrontCover,
+			Picture:     artwork,
+		}
+		tag.AddAttachedPicture(pic)
+	}
+	if len(waveform) > 0 {
+		tag.AddAttachedPicture(id3v2.PictureFrame{
+			Encoding:    downloader.tagEncoding,
+			MimeType:    "image/png",
+			PictureType: id3v2.PTOther,
+			Description: "Waveform",
+			Picture:     waveform,
+		})
+	}
+
+	if len(existing) > 0 {
+		mergeFrames(tag, existing)
+	}
+
+	_, err := tag.WriteTo(w)
+	return err
+}
+
+// tagMetadata returns metadata of t for files, which are not MP3.
+// It has the same fields as ID3 tag written by writeTag.
+func (downloader Downloader) tagMetadata(t track.Track, trackNumber int, artwork []byte) tags.Metadata {
+	m := tags.Metadata{
+		Artist:   t.Artist(),
+		Title:    t.Title(),
+		Artwork:  artwork,
+		Encoding: downloader.encoding,
+	}
+	if !downloader.disabledFrames[fieldYear] {
+		m.Year = t.Year()
+	}
+	if !downloader.disabledFrames[fieldGenre] {
+		m.Genre = t.Genre()
+	}
+	if downloader.album != "" && !downloader.disabledFrames[fieldAlbum] {
+		m.Album = downloader.album
+	}
+	if downloader.album != "" && !downloader.disabledFrames[fieldTrack] {
+		m.TrackNumber = trackNumber
+	}
+	if !downloader.disabledFrames[frameComment] {
+		m.Comment = downloader.tagComment
+		if m.Comment == "" {
+			m.Comment = t.PermalinkURL()
+		}
+	}
+	return m
+}
+
+// commentText returns the text of COMM frame for t: tagComment or,
+// if it's empty, the URL of t.
+func commentText(tagComment string, t track.Track) string {
+	if tagComment != "" {
+		return tagComment
+	}
+	return t.PermalinkURL()
+}
+
+// readFrames returns frames of tag of file at path. If tag
+// can't be read, it returns nil.
+func readFrames(path string) map[string][]id3v2.Framer {
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		logs.WARN.Printf("couldn't read existing tag of %q: %v\n", path, err)
+		return nil
+	}
+	defer tag.Close()
+	return tag.AllFrames()
+}
+
+// mergeFrames replaces frames of tag with existing ones and adds
+// frames of tag, which are missing in existing.
+func mergeFrames(tag *id3v2.Tag, existing map[string][]id3v2.Framer) {
+	added := tag.AllFrames()
+	tag.DeleteAllFrames()
+
+	has := make(map[string]bool)
+	for id, frames := range existing {
+		for _, f := range frames {
+			has[frameKey(id, f)] = true
+			tag.AddFrame(id, f)
+		}
+	}
+	for id, frames := range added {
+		for _, f := range frames {
+			if !has[frameKey(id, f)] {
+				tag.AddFrame(id, f)
+			}
+		}
+	}
+}
+
+// frameKey returns the key, by which frames are compared in mergeFrames.
+// Frames, which can be several in tag, are distinguished by descriptions.
+func frameKey(id string, f id3v2.Framer) string {
+	switch f := f.(type) {
+	case id3v2.UserDefinedTextFrame:
+		return id + "\t" + f.Description
+	case id3v2.CommentFrame:
+		return id + "\t" + f.Description
+	case id3v2.PictureFrame:
+		return id + "\t" + strconv.Itoa(int(f.PictureType)) + "\t" + f.Description
+	}
+	return id
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stages of processing of track, which are measured.
+const (
+	stageDownload = "download"
+	stageArtwork  = "artwork"
+	stageTag      = "tag"
+	stageWrite    = "write"
+	stageTrim     = "trim"
+	stageImport   = "import"
+	stageUpload   = "upload"
+)
+
+// stages is the order, in which stages are reported.
+var stages = [...]string{stageDownload, stageArtwork, stageTag, stageWrite, stageTrim, stageImport, stageUpload}
+
+// timings holds the time spent in each stage. It's safe to use
+// from several goroutines.
+type timings struct {
+	mu     sync.Mutex
+	stages map[string]time.Duration
+}
+
+func newTimings() *timings {
+	return &timings{stages: make(map[string]time.Duration)}
+}
+
+// measure adds the time elapsed since start to stage.
+func (t *timings) measure(stage string, start time.Time) {
+	t.add(stage, time.Since(start))
+}
+
+func (t *timings) add(stage string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stages[stage] += d
+}
+
+// merge adds all stages of other to t.
+func (t *timings) merge(other *timings) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+
+	for stage, d := range other.stages {
+		t.add(stage, d)
+	}
+}
+
+// seconds returns the time of stages in seconds.
+func (t *timings) seconds() map[string]float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.stages) == 0 {
+		return nil
+	}
+	seconds := make(map[string]float64, len(t.stages))
+	for stage, d := range t.stages {
+		seconds[stage] = d.Seconds()
+	}
+	return seconds
+}
+
+// String returns the measured stages in order, e.g.
+// "download 3.2s, artwork 400ms, tag 2ms".
+func (t *timings) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var parts []string
+	for _, stage := range stages {
+		if d, exists := t.stages[stage]; exists {
+			parts = append(parts, fmt.Sprintf("%v %v", stage, roundDuration(d)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d / time.Millisecond * time.Millisecond
+	}
+	return d / time.Microsecond * time.Microsecond
+}
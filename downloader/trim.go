@@ -0,0 +1,178 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bogem/nehm/capability"
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/tempdir"
+	"github.com/bogem/nehm/track"
+)
+
+const (
+	// trimModeCut removes intro and outro from track file.
+	trimModeCut = "cut"
+	// trimModeChapters keeps track as is, but adds chapters,
+	// so players can skip intro and outro.
+	trimModeChapters = "chapters"
+)
+
+// trim is the rule to trim intro and outro of track.
+// It is set in trims section of config, e.g.:
+//
+//	trims:
+//	  some-dj:
+//	    intro: 1m30s
+//	    outro: 45s
+//	    mode: chapters
+type trim struct {
+	intro, outro time.Duration
+	mode         string
+}
+
+// trimsFromConfig returns the rules from trims section of config.
+// If ffmpeg isn't found, trims are disabled.
+// The program is terminating, if rule is invalid.
+func trimsFromConfig() map[string]trim {
+	section := config.GetNestedStringMap("trims")
+	if len(section) == 0 {
+		return nil
+	}
+	if !capability.Available("ffmpeg") {
+		logs.WARN.Printf("%v isn't found, so tracks won't be trimmed. Install ffmpeg or set ffmpegPath. Run 'nehm doctor' for details.\n", ffmpegPath())
+		return nil
+	}
+
+	trims := make(map[string]trim, len(section))
+	for uploader, rule := range section {
+		var tr trim
+		var err error
+		if tr.intro, err = parseTrimDuration(rule["intro"]); err != nil {
+			logs.FATAL.Fatalf("invalid intro of %q in trims: %v\n", uploader, err)
+		}
+		if tr.outro, err = parseTrimDuration(rule["outro"]); err != nil {
+			logs.FATAL.Fatalf("invalid outro of %q in trims: %v\n", uploader, err)
+		}
+		tr.mode = rule["mode"]
+		if tr.mode == "" {
+			tr.mode = trimModeCut
+		}
+		if tr.mode != trimModeCut && tr.mode != trimModeChapters {
+			logs.FATAL.Fatalf("invalid mode of %q in trims: %q. Use %q or %q\n", uploader, tr.mode, trimModeCut, trimModeChapters)
+		}
+		trims[uploader] = tr
+	}
+	return trims
+}
+
+// parseTrimDuration parses duration like "1m30s" or count of seconds.
+func parseTrimDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		d, err = time.ParseDuration(s + "s")
+	}
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q is not a valid duration", s)
+	}
+	return d, nil
+}
+
+// trimFor returns the rule for uploader of t and whether it exists.
+func (downloader Downloader) trimFor(t track.Track) (trim, bool) {
+	if tr, exists := downloader.trims[t.UploaderPermalink()]; exists {
+		return tr, true
+	}
+	tr, exists := downloader.trims[t.Uploader()]
+	return tr, exists
+}
+
+// apply trims the track file at path with ffmpeg.
+// durationMs is the duration of track in milliseconds.
+func (tr trim) apply(path string, durationMs int) error {
+	duration := time.Duration(durationMs) * time.Millisecond
+	end := duration - tr.outro
+	if end <= tr.intro {
+		return fmt.Errorf("intro and outro (%v, %v) are longer than track (%v)", tr.intro, tr.outro, duration)
+	}
+
+	tmp, err := tempdir.TempDir("trim")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	tmpPath := filepath.Join(tmp, "track.mp3")
+	var args []string
+	switch tr.mode {
+	case trimModeCut:
+		args = []string{"-i", path,
+			"-ss", seconds(tr.intro), "-to", seconds(end),
+			"-map", "0", "-map_metadata", "0", "-c", "copy"}
+	case trimModeChapters:
+		metadataPath, err := writeChapters(tr, end, duration)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(metadataPath)
+
+		args = []string{"-i", path, "-i", metadataPath,
+			"-map", "0", "-map_metadata", "0", "-map_chapters", "1", "-c", "copy"}
+	}
+	args = append([]string{"-v", "error", "-y"}, append(args, tmpPath)...)
+
+	out, err := exec.Command(ffmpegPath(), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return moveFile(tmpPath, path)
+}
+
+// writeChapters writes chapters of intro, track and outro to temporary
+// file in ffmpeg metadata format and returns its path.
+func writeChapters(tr trim, end, duration time.Duration) (string, error) {
+	metadata := ";FFMETADATA1\n"
+	addChapter := func(title string, start, end time.Duration) {
+		if end > start {
+			metadata += fmt.Sprintf("[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+				start/time.Millisecond, end/time.Millisecond, title)
+		}
+	}
+	addChapter("Intro", 0, tr.intro)
+	addChapter("Track", tr.intro, end)
+	addChapter("Outro", end, duration)
+
+	file, err := tempdir.TempFile("chapters")
+	if err != nil {
+		return "", fmt.Errorf("couldn't create file for chapters: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(metadata); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("couldn't write chapters: %v", err)
+	}
+	return file.Name(), nil
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// ffmpegPath returns the path to ffmpeg executable.
+func ffmpegPath() string {
+	if path := config.Get("ffmpegPath"); path != "" {
+		return path
+	}
+	return "ffmpeg"
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bogem/nehm/manifest"
+	"github.com/bogem/nehm/tempdir"
+	"github.com/bogem/nehm/util"
+)
+
+// upload copies track at trackPath to the same relative path in
+// downloader.uploadTo and verifies the copy. If downloader.moveAfterUpload
+// is true, it removes the local copy after that.
+// It returns the path of uploaded track.
+func (downloader Downloader) upload(trackPath string) (string, error) {
+	rel, err := filepath.Rel(downloader.dist, trackPath)
+	if err != nil {
+		rel = filepath.Base(trackPath)
+	}
+	dst := filepath.Join(downloader.uploadTo, rel)
+	if !util.IsWithin(downloader.uploadTo, dst) {
+		return "", fmt.Errorf("refusing to write %q outside of upload target", dst)
+	}
+
+	if err := mkdirAll(filepath.Dir(dst)); err != nil {
+		return "", fmt.Errorf("couldn't create folder in upload target: %v", err)
+	}
+	if _, err := os.Stat(dst); err == nil && downloader.archive {
+		return "", fmt.Errorf("%q already exists and can't be overwritten in archive mode", dst)
+	}
+	if err := copyFile(trackPath, dst); err != nil {
+		return "", fmt.Errorf("couldn't copy track to upload target: %v", err)
+	}
+	if err := chown(dst); err != nil {
+		return "", err
+	}
+
+	srcHash, err := manifest.Hash(trackPath)
+	if err != nil {
+		return "", err
+	}
+	dstHash, err := manifest.Hash(dst)
+	if err != nil {
+		return "", err
+	}
+	if srcHash != dstHash {
+		return "", errors.New("uploaded copy of track differs from local one")
+	}
+
+	if downloader.moveAfterUpload {
+		if err := os.Remove(trackPath); err != nil {
+			return "", fmt.Errorf("couldn't remove local copy of track: %v", err)
+		}
+	}
+	return dst, nil
+}
+
+// moveFile moves file from src to dst. If they're on different
+// filesystems, file is copied to temporary file next to dst first,
+// so dst is never left half-written.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	tmp := dst + tempdir.TmpSuffix
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies file from src to dst preserving modification time.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, time.Now(), fi.ModTime())
+}
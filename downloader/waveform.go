@@ -0,0 +1,74 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package downloader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogem/nehm/httpclient"
+	"github.com/bogem/nehm/track"
+)
+
+// Ways to save waveform of track.
+const (
+	// waveformPNG saves waveform image next to track.
+	waveformPNG = "png"
+	// waveformJSON saves waveform samples next to track.
+	waveformJSON = "json"
+	// waveformEmbed embeds waveform image to tag as second picture.
+	waveformEmbed = "embed"
+)
+
+// waveformURL returns the URL of waveform of t in format
+// ("png" or "json"). It returns blank string, if track has no waveform.
+func waveformURL(t track.Track, format string) string {
+	u := t.WaveformURL()
+	if u == "" || format != waveformJSON {
+		return u
+	}
+	// Samples are on another host with the same name,
+	// e.g. https://wave.sndcdn.com/fxguEjG4ax6B_m.json
+	// for https://w1.sndcdn.com/fxguEjG4ax6B_m.png.
+	name := strings.TrimSuffix(path.Base(u), path.Ext(u))
+	return "https://wave.sndcdn.com/" + name + ".json"
+}
+
+func fetchWaveform(url string) ([]byte, error) {
+	statusCode, body, err := httpclient.Get(nil, url)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != 200 {
+		return nil, fmt.Errorf("HTTP %v", statusCode)
+	}
+	return body, nil
+}
+
+// writeWaveform saves waveform of t next to track at trackPath.
+func (downloader Downloader) writeWaveform(t track.Track, trackPath string) error {
+	url := waveformURL(t, downloader.waveform)
+	if url == "" {
+		return nil
+	}
+
+	path := strings.TrimSuffix(trackPath, filepath.Ext(trackPath)) + ".waveform." + downloader.waveform
+	if _, err := os.Stat(path); err == nil && downloader.archive {
+		return nil
+	}
+
+	data, err := fetchWaveform(url)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	return chown(path)
+}
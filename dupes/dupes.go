@@ -0,0 +1,167 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package dupes finds likely duplicates among downloaded tracks:
+// tracks with the same normalized artist and title and similar duration.
+package dupes
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bogem/id3v2"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/mp3"
+)
+
+// File is the downloaded track.
+type File struct {
+	// ID is the ID of track on SoundCloud. It's 0, if file
+	// is not in the index of downloaded tracks.
+	ID            int
+	Path          string
+	Artist, Title string
+	Size          int64
+	mp3.Info
+}
+
+// Fullname returns the name of track in the same format as track.Fullname.
+func (f File) Fullname() string {
+	return f.Artist + " — " + f.Title
+}
+
+// Group is the list of files, which are likely the same track.
+type Group []File
+
+// Best returns the index of file in g, which should be kept:
+// file with the highest bitrate or, if bitrates are equal, the biggest one.
+func (g Group) Best() int {
+	best := 0
+	for i, f := range g {
+		b := g[best]
+		if f.Bitrate > b.Bitrate || (f.Bitrate == b.Bitrate && f.Size > b.Size) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Files returns existing files of tracks from index. If folder is not
+// blank, mp3 files in folder, which are not in index, are added too.
+func Files(folder string) ([]File, error) {
+	var files []File
+	var infos []os.FileInfo
+
+	add := func(f File) {
+		fi, err := os.Stat(f.Path)
+		if err != nil {
+			return
+		}
+		// The same file can be linked to several paths (see linkMode).
+		for _, other := range infos {
+			if os.SameFile(fi, other) {
+				return
+			}
+		}
+		infos = append(infos, fi)
+
+		f.Size = fi.Size()
+		info, err := mp3.ReadInfo(f.Path)
+		if err != nil {
+			logs.INFO.Printf("couldn't read MP3 info of %q: %v\n", f.Path, err)
+		}
+		f.Info = info
+		files = append(files, f)
+	}
+
+	for _, e := range index.All() {
+		add(File{ID: e.ID, Path: e.Path, Artist: e.Artist, Title: e.Title})
+	}
+
+	if folder == "" {
+		return files, nil
+	}
+	err := filepath.Walk(folder, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !strings.EqualFold(filepath.Ext(path), ".mp3") {
+			return nil
+		}
+		tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+		if err != nil {
+			logs.INFO.Printf("couldn't read tag of %q: %v\n", path, err)
+			return nil
+		}
+		artist, title := tag.Artist(), tag.Title()
+		tag.Close()
+		if title == "" {
+			return nil
+		}
+		add(File{Path: path, Artist: artist, Title: title})
+		return nil
+	})
+	return files, err
+}
+
+// Find returns groups of files with the same normalized artist and title,
+// which durations differ not more than tolerance. Files with unknown
+// duration are similar to all files.
+func Find(files []File, tolerance time.Duration) []Group {
+	byKey := make(map[string][]File)
+	var keys []string
+	for _, f := range files {
+		k := key(f.Artist, f.Title)
+		if _, exists := byKey[k]; !exists {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], f)
+	}
+	sort.Strings(keys)
+
+	var groups []Group
+	for _, k := range keys {
+		same := byKey[k]
+		if len(same) < 2 {
+			continue
+		}
+		sort.Slice(same, func(i, j int) bool {
+			return same[i].Duration < same[j].Duration
+		})
+
+		// Sorted by duration, so similar files are neighbours.
+		group := Group{same[0]}
+		for _, f := range same[1:] {
+			prev := group[len(group)-1]
+			if prev.Duration == 0 || f.Duration-prev.Duration <= tolerance {
+				group = append(group, f)
+				continue
+			}
+			if len(group) > 1 {
+				groups = append(groups, group)
+			}
+			group = Group{f}
+		}
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// key returns artist and title in lower case with only letters and digits,
+// so names, which differ in punctuation and spaces, are equal.
+func key(artist, title string) string {
+	normalize := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, s)
+	}
+	return normalize(artist) + "\x00" + normalize(title)
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package feed parses RSS feeds of podcasts and radio shows (feeds
+// in config) and OPML files with lists of feeds (feedsOPML in config).
+package feed
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bogem/nehm/httpclient"
+)
+
+// Feed is the podcast or show with its episodes.
+type Feed struct {
+	URL, Title, Author string
+	// ImageURL is the URL of cover of feed. It can be blank.
+	ImageURL string
+	Episodes []Episode
+}
+
+// Episode is the item of feed, which has an audio file.
+type Episode struct {
+	// GUID identifies episode in feed. If feed has no GUIDs,
+	// it's the URL of audio file.
+	GUID, Title, Description string
+	// URL is the URL of audio file and Type is its MIME type.
+	URL, Type string
+	// ImageURL is the URL of cover of episode. It can be blank.
+	ImageURL  string
+	Published time.Time
+}
+
+// Namespaced fields go first, because encoding/xml uses the first field,
+// which matches element, and fields without namespace match every namespace.
+// ITunesTitle keeps itunes:title from overwriting title.
+type rss struct {
+	Channel struct {
+		ITunesTitle string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd title"`
+		Title       string `xml:"title"`
+		Author      string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+		ITunesImage struct {
+			Href string `xml:"href,attr"`
+		} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+		Image struct {
+			URL string `xml:"url"`
+		} `xml:"image"`
+		Items []struct {
+			ITunesTitle string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd title"`
+			Title       string `xml:"title"`
+			GUID        string `xml:"guid"`
+			PubDate     string `xml:"pubDate"`
+			Summary     string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+			Description string `xml:"description"`
+			ITunesImage struct {
+				Href string `xml:"href,attr"`
+			} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+			Enclosure struct {
+				URL  string `xml:"url,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// pubDateLayouts are layouts of pubDate found in feeds.
+// RSS requires RFC 822, but many feeds omit the day of week.
+var pubDateLayouts = []string{time.RFC1123Z, time.RFC1123, "2 Jan 2006 15:04:05 -0700", "2 Jan 2006 15:04:05 MST"}
+
+// Parse parses RSS feed from data. Items without audio files are skipped.
+func Parse(data []byte) (Feed, error) {
+	var r rss
+	if err := xml.Unmarshal(data, &r); err != nil {
+		return Feed{}, fmt.Errorf("couldn't parse feed: %v", err)
+	}
+
+	ch := r.Channel
+	f := Feed{
+		Title:    strings.TrimSpace(ch.Title),
+		Author:   strings.TrimSpace(ch.Author),
+		ImageURL: ch.ITunesImage.Href,
+	}
+	if f.Title == "" {
+		return Feed{}, errors.New("feed has no title")
+	}
+	if f.ImageURL == "" {
+		f.ImageURL = ch.Image.URL
+	}
+
+	for _, item := range ch.Items {
+		if item.Enclosure.URL == "" {
+			continue
+		}
+		if item.Enclosure.Type != "" && !strings.HasPrefix(item.Enclosure.Type, "audio/") {
+			continue
+		}
+		e := Episode{
+			GUID:        strings.TrimSpace(item.GUID),
+			Title:       strings.TrimSpace(item.Title),
+			Description: strings.TrimSpace(item.Summary),
+			URL:         item.Enclosure.URL,
+			Type:        item.Enclosure.Type,
+			ImageURL:    item.ITunesImage.Href,
+		}
+		if e.GUID == "" {
+			e.GUID = e.URL
+		}
+		if e.Description == "" {
+			e.Description = strings.TrimSpace(item.Description)
+		}
+		for _, layout := range pubDateLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(item.PubDate)); err == nil {
+				e.Published = t
+				break
+			}
+		}
+		f.Episodes = append(f.Episodes, e)
+	}
+	return f, nil
+}
+
+// Fetch downloads and parses feed from url.
+// Feeds are never cached, so new episodes are always found.
+func Fetch(url string) (Feed, error) {
+	statusCode, body, err := httpclient.GetMedia(nil, url)
+	if err != nil {
+		return Feed{}, fmt.Errorf("couldn't get feed: %v", err)
+	}
+	if statusCode != 200 {
+		return Feed{}, fmt.Errorf("couldn't get feed: HTTP %v", statusCode)
+	}
+	f, err := Parse(body)
+	if err != nil {
+		return Feed{}, err
+	}
+	f.URL = url
+	return f, nil
+}
+
+type outline struct {
+	XMLURL   string    `xml:"xmlUrl,attr"`
+	Outlines []outline `xml:"outline"`
+}
+
+// ParseOPML returns URLs of feeds listed in OPML data.
+// Outlines can be nested, e.g. in folders.
+func ParseOPML(data []byte) ([]string, error) {
+	var opml struct {
+		Outlines []outline `xml:"body>outline"`
+	}
+	if err := xml.Unmarshal(data, &opml); err != nil {
+		return nil, fmt.Errorf("couldn't parse OPML: %v", err)
+	}
+
+	var urls []string
+	var walk func([]outline)
+	walk = func(outlines []outline) {
+		for _, o := range outlines {
+			if o.XMLURL != "" {
+				urls = append(urls, o.XMLURL)
+			}
+			walk(o.Outlines)
+		}
+	}
+	walk(opml.Outlines)
+	return urls, nil
+}
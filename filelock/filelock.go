@@ -0,0 +1,87 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package filelock protects in-place edits of track files, which can be
+// read by players at the same time (e.g. in shared folder). Files are
+// locked with advisory locks, and edits are retried, while files are busy.
+package filelock
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// retryDelays are the delays between attempts to edit file, while it's busy.
+var retryDelays = [...]time.Duration{500 * time.Millisecond, 2 * time.Second, 5 * time.Second, 15 * time.Second}
+
+// Do locks file at path, calls edit and unlocks file. If file is busy
+// (locked or opened by other program), it retries after retryDelays.
+func Do(path string, edit func() error) error {
+	for i := 0; ; i++ {
+		err := do(path, edit)
+		if !IsBusy(err) || i == len(retryDelays) {
+			if IsBusy(err) {
+				return fmt.Errorf("%v is busy, it's probably being played: %v", path, err)
+			}
+			return err
+		}
+		time.Sleep(retryDelays[i])
+	}
+}
+
+// do calls edit with locked file at path. If file can't be locked
+// for other reason than it's busy, edit is called anyway, so it reports
+// the clearer error.
+func do(path string, edit func() error) error {
+	unlock, err := lock(path)
+	if IsBusy(err) {
+		return err
+	}
+	if err == nil {
+		defer unlock()
+	}
+	return edit()
+}
+
+// holdTimeout is the maximal time of waiting for lock file in Exclusive.
+const holdTimeout = 10 * time.Second
+
+// Exclusive calls fn, while lock file at path is held, so fn isn't
+// run by several nehm processes at the same time, e.g. while index is
+// merged and saved. Lock file is created, if it doesn't exist.
+func Exclusive(path string, fn func() error) error {
+	deadline := time.Now().Add(holdTimeout)
+	for {
+		release, err := Hold(path)
+		if err == nil {
+			defer release()
+			return fn()
+		}
+		if !IsBusy(err) {
+			return fmt.Errorf("couldn't lock %v: %v", path, err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%v is locked by other nehm process for more than %v", path, holdTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// IsBusy returns true, if err means, that file is locked or
+// opened by other program.
+func IsBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	return isBusyErrno(err)
+}
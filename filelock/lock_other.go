@@ -0,0 +1,22 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package filelock
+
+// lock does nothing, because there are no file locks on this system.
+func lock(path string) (func(), error) {
+	return func() {}, nil
+}
+
+// Hold does nothing, because there are no file locks on this system.
+func Hold(path string) (func(), error) {
+	return func() {}, nil
+}
+
+func isBusyErrno(err error) bool {
+	return false
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package filelock
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// lock opens file at path without sharing to check, that no other
+// program has it opened. Locks on Windows are mandatory, so file can't
+// be held locked while it's edited, and it's closed at once.
+// If file is opened by other program, it returns sharing violation.
+func lock(path string) (func(), error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	syscall.CloseHandle(h)
+	return func() {}, nil
+}
+
+// Hold opens file at path without sharing and holds it opened until
+// release, so other processes get sharing violation. File is created,
+// if it doesn't exist. It's used only for lock files, which aren't
+// edited, so mandatory lock doesn't matter.
+func Hold(path string) (func(), error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return func() { syscall.CloseHandle(h) }, nil
+}
+
+func isBusyErrno(err error) bool {
+	return err == errorSharingViolation || err == errorLockViolation
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package format detects formats of audio files by their content,
+// because names of files from SoundCloud can be wrong.
+package format
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Format is the format of audio file.
+type Format struct {
+	Name string
+	// Ext is the extension of files with this format, e.g. ".mp3".
+	Ext string
+}
+
+// Known formats. Unknown is the zero value.
+var (
+	Unknown = Format{}
+	MP3     = Format{"MP3", ".mp3"}
+	WAV     = Format{"WAV", ".wav"}
+	FLAC    = Format{"FLAC", ".flac"}
+	Ogg     = Format{"Ogg", ".ogg"}
+	Opus    = Format{"Opus", ".opus"}
+	M4A     = Format{"MPEG-4 audio", ".m4a"}
+	AIFF    = Format{"AIFF", ".aiff"}
+)
+
+// headerSize is the count of bytes needed by Sniff.
+const headerSize = 36
+
+// Sniff returns the format of file beginning with header.
+func Sniff(header []byte) Format {
+	switch {
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return MP3
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		return MP3
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		return WAV
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return FLAC
+	case bytes.HasPrefix(header, []byte("OggS")):
+		// The first page of Opus stream has one segment with OpusHead.
+		if len(header) >= 36 && bytes.Equal(header[28:36], []byte("OpusHead")) {
+			return Opus
+		}
+		return Ogg
+	case len(header) >= 8 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return M4A
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("FORM")) &&
+		(bytes.Equal(header[8:12], []byte("AIFF")) || bytes.Equal(header[8:12], []byte("AIFC"))):
+		return AIFF
+	}
+	return Unknown
+}
+
+// SniffFile returns the format of file at path.
+func SniffFile(path string) (Format, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Unknown, err
+	}
+	defer file.Close()
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return Unknown, err
+	}
+	return Sniff(header[:n]), nil
+}
@@ -0,0 +1,243 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package hls downloads HLS streams and joins their segments
+// into one file.
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/bogem/nehm/httpclient"
+)
+
+// DefaultWorkers is the count of segments downloaded at the same time.
+const DefaultWorkers = 4
+
+// ErrEncrypted is returned, if segments of stream are encrypted.
+var ErrEncrypted = errors.New("stream is encrypted")
+
+// ErrMPEGTS is returned, if segments of stream are in MPEG-TS container,
+// which can't be joined into audio file without demuxing.
+var ErrMPEGTS = errors.New("MPEG-TS segments aren't supported")
+
+// Playlist is the media playlist of HLS stream.
+type Playlist struct {
+	// Init is the URL of initialization segment (EXT-X-MAP),
+	// e.g. of fragmented MP4. It's blank, if there is no one.
+	Init     string
+	Segments []string
+}
+
+// Parse parses the playlist with URL base. If it's master playlist,
+// variant is the URL of the first variant stream and p is empty.
+func Parse(data []byte, base string) (p Playlist, variant string, err error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return p, "", err
+	}
+	resolve := func(ref string) (string, error) {
+		u, err := baseURL.Parse(ref)
+		if err != nil {
+			return "", fmt.Errorf("invalid URI %q in playlist: %v", ref, err)
+		}
+		return u.String(), nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return p, "", errors.New("it's not HLS playlist")
+	}
+	var isMaster bool
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			isMaster = true
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			if attr(line, "METHOD") != "NONE" {
+				return p, "", ErrEncrypted
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			if p.Init, err = resolve(attr(line, "URI")); err != nil {
+				return p, "", err
+			}
+		case strings.HasPrefix(line, "#"):
+		case isMaster:
+			variant, err = resolve(line)
+			return Playlist{}, variant, err
+		default:
+			segment, err := resolve(line)
+			if err != nil {
+				return p, "", err
+			}
+			p.Segments = append(p.Segments, segment)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return p, "", err
+	}
+	if len(p.Segments) == 0 {
+		return p, "", errors.New("there are no segments in playlist")
+	}
+	return p, "", nil
+}
+
+// attr returns the value of attribute with name in tag line,
+// e.g. URI in `#EXT-X-MAP:URI="init.mp4"`.
+func attr(line, name string) string {
+	list := line[strings.Index(line, ":")+1:]
+	for len(list) > 0 {
+		eq := strings.Index(list, "=")
+		if eq < 0 {
+			return ""
+		}
+		key := strings.TrimSpace(list[:eq])
+		list = list[eq+1:]
+
+		var value string
+		if strings.HasPrefix(list, `"`) {
+			end := strings.Index(list[1:], `"`)
+			if end < 0 {
+				return ""
+			}
+			value, list = list[1:end+1], list[end+2:]
+		} else if comma := strings.Index(list, ","); comma >= 0 {
+			value, list = list[:comma], list[comma:]
+		} else {
+			value, list = list, ""
+		}
+		list = strings.TrimPrefix(list, ",")
+
+		if key == name {
+			return value
+		}
+	}
+	return ""
+}
+
+// Download downloads HLS stream from playlistURL to file at path.
+// workers segments are downloaded at the same time and written to file
+// in order. If status code of some response is not 2xx, it's returned
+// and file is removed. progress is called after each segment,
+// the size is always -1.
+func Download(path, playlistURL string, workers int, progress httpclient.ProgressFunc) (statusCode int, err error) {
+	p, statusCode, err := fetchPlaylist(playlistURL)
+	if err != nil || statusCode >= 300 {
+		return statusCode, err
+	}
+
+	urls := p.Segments
+	if p.Init != "" {
+		urls = append([]string{p.Init}, urls...)
+	}
+	segments := make([][]byte, len(urls))
+	codes := make([]int, len(urls))
+	errs := make([]error, len(urls))
+
+	var mu sync.Mutex
+	var written int64
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	if workers < 1 {
+		workers = 1
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				codes[i], segments[i], errs[i] = httpclient.GetMedia(nil, urls[i])
+				if errs[i] == nil && progress != nil {
+					mu.Lock()
+					written += int64(len(segments[i]))
+					progress(written, -1)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range urls {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i := range urls {
+		if errs[i] != nil {
+			return 0, fmt.Errorf("couldn't download segment %v: %v", i, errs[i])
+		}
+		if codes[i] < 200 || codes[i] >= 300 {
+			return codes[i], nil
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for _, segment := range segments {
+		segment, err = audio(segment)
+		if err != nil {
+			f.Close()
+			os.Remove(path)
+			return 0, err
+		}
+		w.Write(segment)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return 200, f.Close()
+}
+
+// fetchPlaylist downloads and parses the media playlist at playlistURL.
+// If it's master playlist, the first variant is fetched.
+func fetchPlaylist(playlistURL string) (Playlist, int, error) {
+	for redirects := 0; redirects < 2; redirects++ {
+		statusCode, body, err := httpclient.GetMedia(nil, playlistURL)
+		if err != nil {
+			return Playlist{}, 0, fmt.Errorf("couldn't download playlist: %v", err)
+		}
+		if statusCode < 200 || statusCode >= 300 {
+			return Playlist{}, statusCode, nil
+		}
+		p, variant, err := Parse(body, playlistURL)
+		if err != nil || variant == "" {
+			return p, statusCode, err
+		}
+		playlistURL = variant
+	}
+	return Playlist{}, 0, errors.New("there are nested master playlists")
+}
+
+// audio returns audio data of segment. ID3 tags with timestamps,
+// which are written in the beginning of segments of raw audio,
+// are removed, so segments can be concatenated.
+func audio(segment []byte) ([]byte, error) {
+	if len(segment) >= 188 && segment[0] == 0x47 && len(segment)%188 == 0 {
+		return nil, ErrMPEGTS
+	}
+	if bytes.HasPrefix(segment, []byte("ID3")) && len(segment) >= 10 {
+		size := int(segment[6])<<21 | int(segment[7])<<14 | int(segment[8])<<7 | int(segment[9])
+		size += 10
+		if segment[5]&0x10 != 0 {
+			size += 10 // footer
+		}
+		if size <= len(segment) {
+			segment = segment[size:]
+		}
+	}
+	return segment, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package hooks runs shell commands set by user in hooks section
+// of config after downloading, e.g. to transcode tracks or
+// to refresh music library.
+package hooks
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+)
+
+// Events, after which hooks are run.
+const (
+	// AfterTrack is run after each downloaded track.
+	AfterTrack = "afterTrack"
+	// AfterAll is run after all tracks are downloaded.
+	AfterAll = "afterAll"
+)
+
+// envPrefix is the prefix of variables passed to hooks. It differs from
+// NEHM_ prefix of config variables, so nehm run in hook doesn't take
+// e.g. NEHM_HOOK_ALBUM as album of all tracks.
+const envPrefix = "NEHM_HOOK_"
+
+// Hooks are shell commands by events.
+type Hooks map[string]string
+
+// FromConfig returns hooks from hooks section in config.
+// The program is terminating, if there is an unknown event.
+func FromConfig() Hooks {
+	h := Hooks(config.GetStringMap("hooks"))
+	for event := range h {
+		if event != AfterTrack && event != AfterAll {
+			logs.FATAL.Fatalf("unknown hook %q. Use %q or %q.\n", event, AfterTrack, AfterAll)
+		}
+	}
+	return h
+}
+
+// Run runs the hook of event, if it's set. Values of env are passed
+// to command as environment variables with envPrefix,
+// e.g. path as NEHM_HOOK_PATH. Failed hooks are only reported,
+// they don't stop downloading.
+func (h Hooks) Run(event string, env map[string]string) {
+	command := h[event]
+	if command == "" {
+		return
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = os.Environ()
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, envPrefix+strings.ToUpper(k)+"="+env[k])
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	logs.INFO.Printf("running %v hook: %v\n", event, command)
+	if err := cmd.Run(); err != nil {
+		logs.WARN.Printf("%v hook %q failed: %v\n", event, command, err)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package httpclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/util"
+)
+
+const (
+	defaultCacheSize = 100 << 20
+	defaultCacheTTL  = 10 * time.Minute
+)
+
+// diskCache is the cache of responses of Get on disk. It's enabled
+// with httpCache. The folder of cache (httpCacheDir) doesn't depend
+// on account, so it can be shared by all accounts and users of machine.
+//
+// Artworks never change, so they're kept until they're evicted.
+// API responses are kept for httpCacheTTL. Responses to requests
+// with oauth_token are private and never cached. In polite mode
+// cache is always enabled.
+type diskCache struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	ttl     time.Duration
+}
+
+// cache is nil, if caching is disabled.
+var cache *diskCache
+
+func configureCache() error {
+	cache = nil
+	if !config.GetBool("httpCache") && !polite {
+		return nil
+	}
+
+	c := &diskCache{
+		dir:     filepath.Join(os.Getenv("HOME"), ".nehm", "cache", "http"),
+		maxSize: defaultCacheSize,
+		ttl:     defaultCacheTTL,
+	}
+	if dir := config.Get("httpCacheDir"); dir != "" {
+		c.dir = util.SanitizePath(dir)
+	}
+	if size := config.Get("httpCacheSize"); size != "" {
+		var err error
+		if c.maxSize, err = util.ParseSize(size); err != nil {
+			return fmt.Errorf("invalid httpCacheSize: %v", err)
+		}
+	}
+	if ttl := config.Get("httpCacheTTL"); ttl != "" {
+		var err error
+		if c.ttl, err = time.ParseDuration(ttl); err != nil {
+			return fmt.Errorf("invalid httpCacheTTL %q: %v", ttl, err)
+		}
+	}
+	// Group-writable, so one folder can be shared by several users.
+	// Permissions are set after creating, because umask usually
+	// removes write permission of group. setgid bit makes files
+	// of all users belong to the group of folder.
+	if err := os.MkdirAll(c.dir, 0775); err != nil {
+		return fmt.Errorf("couldn't create cache folder: %v", err)
+	}
+	if err := os.Chmod(c.dir, 0775|os.ModeSetgid); err != nil {
+		// Folder of other user is already shared by its owner.
+		logs.INFO.Println("couldn't make cache folder group-writable:", err)
+	}
+
+	cache = c
+	return nil
+}
+
+// key returns the name of cache file for rawurl. client_id is not
+// a part of key, so accounts with different client IDs share responses.
+// If response to rawurl shouldn't be cached, key returns blank string.
+func key(rawurl string) (name string, immutable bool) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", false
+	}
+	q := u.Query()
+	if q.Get("oauth_token") != "" {
+		return "", false
+	}
+	q.Del("client_id")
+	u.RawQuery = q.Encode()
+
+	sum := sha256.Sum256([]byte(u.String()))
+	return hex.EncodeToString(sum[:]), strings.HasSuffix(u.Host, "sndcdn.com")
+}
+
+// get returns the cached body of rawurl, if it's not expired.
+func (c *diskCache) get(rawurl string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	name, immutable := key(rawurl)
+	if name == "" {
+		return nil, false
+	}
+
+	path := filepath.Join(c.dir, name)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if !immutable && time.Since(fi.ModTime()) > c.ttl {
+		return nil, false
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	logs.INFO.Printf("%q is taken from cache\n", rawurl)
+	return body, true
+}
+
+// put saves body of rawurl and evicts the oldest responses,
+// if the size of cache exceeds httpCacheSize.
+func (c *diskCache) put(rawurl string, body []byte) {
+	if c == nil || int64(len(body)) > c.maxSize {
+		return
+	}
+	name, _ := key(rawurl)
+	if name == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Write to temporary file first, so other nehm processes
+	// don't read partially written response.
+	path := filepath.Join(c.dir, name)
+	tmpPath := fmt.Sprintf("%v.%v.tmp", path, os.Getpid())
+	if err := ioutil.WriteFile(tmpPath, body, 0664); err != nil {
+		logs.INFO.Println("couldn't write response to cache:", err)
+		return
+	}
+	// Responses are group-writable regardless of umask like the folder.
+	if err := os.Chmod(tmpPath, 0664); err != nil {
+		logs.INFO.Println("couldn't make cached response group-writable:", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		logs.INFO.Println("couldn't write response to cache:", err)
+		return
+	}
+
+	c.evict()
+}
+
+// evict removes the oldest files, until the size of cache
+// is not greater than maxSize.
+func (c *diskCache) evict() {
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+
+	var size int64
+	for _, fi := range infos {
+		size += fi.Size()
+	}
+	if size <= c.maxSize {
+		return
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	for _, fi := range infos {
+		if size <= c.maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, fi.Name())); err == nil || os.IsNotExist(err) {
+			size -= fi.Size()
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package httpclient
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/bogem/nehm/logs"
+	"github.com/valyala/fasthttp"
+)
+
+const dialTimeout = 10 * time.Second
+
+// newDial returns the dial function, which uses only IPv4 or IPv6,
+// if ipVersion is "4" or "6" respectively, and connects to overridden
+// addresses of hosts in dnsOverride. If nothing should be changed,
+// it returns nil, so fasthttp uses its default dial function.
+func newDial(ipVersion string, dnsOverride map[string]string) (fasthttp.DialFunc, error) {
+	var network string
+	switch ipVersion {
+	case "":
+		network = "tcp"
+	case "4", "6":
+		network = "tcp" + ipVersion
+	default:
+		return nil, fmt.Errorf("invalid IP version %q: should be 4 or 6", ipVersion)
+	}
+
+	if network == "tcp" && len(dnsOverride) == 0 {
+		return nil, nil
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	return func(addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if ip, exists := dnsOverride[host]; exists {
+			logs.INFO.Printf("Connecting to %v instead of %v\n", ip, host)
+			addr = net.JoinHostPort(ip, port)
+		}
+		return dialer.Dial(network, addr)
+	}, nil
+}
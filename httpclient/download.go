@@ -0,0 +1,217 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+	"github.com/valyala/fasthttp"
+)
+
+// defaultMaxRedirects is the count of redirects followed by Download,
+// if maxRedirects isn't set in config.
+const defaultMaxRedirects = 10
+
+// downloadClient is the net/http client used for downloading tracks.
+// Unlike client, it streams the body, so it can report progress.
+var downloadClient = new(http.Client)
+
+// idleTimeout is the maximal time between chunks of body in DownloadFile
+// (timeout in config). If it's 0, body can stall forever.
+var idleTimeout time.Duration
+
+// configureDownloadClient configures downloadClient with the same
+// TLS config and dial function as client.
+func configureDownloadClient(tlsConfig *tls.Config, dial fasthttp.DialFunc, timeout time.Duration) error {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSClientConfig:       tlsConfig,
+		ResponseHeaderTimeout: timeout,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   dialTimeout,
+	}
+	if dial != nil {
+		transport.DialContext = func(_ context.Context, _, addr string) (net.Conn, error) {
+			return dial(addr)
+		}
+	} else {
+		transport.DialContext = (&net.Dialer{Timeout: dialTimeout}).DialContext
+	}
+	downloadClient.Transport = transport
+	idleTimeout = timeout
+
+	maxRedirects := defaultMaxRedirects
+	if value := config.Get("maxRedirects"); value != "" {
+		var err error
+		maxRedirects, err = strconv.Atoi(value)
+		if err != nil || maxRedirects < 0 {
+			return fmt.Errorf("invalid maxRedirects %q: should be non-negative integer", value)
+		}
+	}
+	downloadClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %v redirects", maxRedirects)
+		}
+		return nil
+	}
+	return nil
+}
+
+// ProgressFunc is called while downloading with the count of written bytes
+// and the size of body. If size is unknown, it's -1.
+type ProgressFunc func(written, size int64)
+
+// DownloadFile writes the body of url to file at path. If file already
+// exists (e.g. previous download was interrupted), only the rest of body
+// is requested with Range header. If server doesn't support ranges,
+// file is downloaded from the beginning. If status code of response
+// is not 2xx, file is not changed. If progress is not nil,
+// it's called after each written chunk.
+func DownloadFile(path, url string, progress ProgressFunc) (statusCode int, err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	offset := fi.Size()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req = req.WithContext(ctx)
+	pause()
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		logs.INFO.Printf("resuming download of %q from %v bytes\n", path, offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// Server answers so, if file is already complete.
+		if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%v", offset) {
+			return http.StatusOK, nil
+		}
+		if err := f.Truncate(0); err != nil {
+			return 0, err
+		}
+		f.Close()
+		return DownloadFile(path, url, progress)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		offset = 0
+		if err := f.Truncate(0); err != nil {
+			return 0, err
+		}
+	default:
+		if offset == 0 {
+			f.Close()
+			os.Remove(path)
+		}
+		return resp.StatusCode, nil
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var w io.Writer = f
+	if progress != nil {
+		size := int64(-1)
+		if resp.ContentLength >= 0 {
+			size = offset + resp.ContentLength
+		}
+		w = &progressWriter{w: f, written: offset, size: size, progress: progress}
+	}
+	var body io.Reader = resp.Body
+	if idleTimeout > 0 {
+		ir := newIdleReader(resp.Body, idleTimeout, cancel)
+		defer ir.stop()
+		body = ir
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		if ir, ok := body.(*idleReader); ok && ir.timedOut() {
+			return resp.StatusCode, fmt.Errorf("no data was received for %v", idleTimeout)
+		}
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, f.Close()
+}
+
+// idleReader cancels the request, if no data is read from body
+// during timeout, so stalled downloads don't block forever.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	expired chan struct{}
+	once    sync.Once
+}
+
+func newIdleReader(r io.Reader, timeout time.Duration, cancel func()) *idleReader {
+	ir := &idleReader{r: r, timeout: timeout, expired: make(chan struct{})}
+	ir.timer = time.AfterFunc(timeout, func() {
+		ir.once.Do(func() { close(ir.expired) })
+		cancel()
+	})
+	return ir
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 {
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+func (ir *idleReader) timedOut() bool {
+	select {
+	case <-ir.expired:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ir *idleReader) stop() {
+	ir.timer.Stop()
+}
+
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	size     int64
+	progress ProgressFunc
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+	pw.progress(pw.written, pw.size)
+	return n, err
+}
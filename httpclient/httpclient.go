@@ -0,0 +1,169 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package httpclient holds the HTTP clients, which are shared
+// by all requests to SoundCloud.
+package httpclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/bogem/nehm/color"
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/util"
+	"github.com/valyala/fasthttp"
+)
+
+var client = new(fasthttp.Client)
+
+// Configure configures the client with values from config.
+// It should be called once after reading the config.
+func Configure() error {
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return err
+	}
+	client.TLSConfig = tlsConfig
+
+	dial, err := newDial(config.Get("ipVersion"), config.GetStringMap("dnsOverride"))
+	if err != nil {
+		return err
+	}
+	client.Dial = dial
+
+	var timeout time.Duration
+	if value := config.Get("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid timeout %q: %v", value, err)
+		}
+		client.ReadTimeout = timeout
+		client.WriteTimeout = timeout
+	}
+
+	polite = config.GetBool("polite")
+
+	if err := configureCache(); err != nil {
+		return err
+	}
+
+	return configureDownloadClient(tlsConfig, dial, timeout)
+}
+
+// newTLSConfig returns TLS config formed from tlsCACert,
+// tlsInsecureSkipVerify, tlsClientCert and tlsClientKey keys.
+// If none of them are set, it returns nil, so default TLS config is used.
+func newTLSConfig() (*tls.Config, error) {
+	caCert := config.Get("tlsCACert")
+	clientCert := config.Get("tlsClientCert")
+	clientKey := config.Get("tlsClientKey")
+	insecure := config.GetBool("tlsInsecureSkipVerify")
+
+	if caCert == "" && clientCert == "" && clientKey == "" && !insecure {
+		return nil, nil
+	}
+
+	tlsConfig := new(tls.Config)
+
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(util.SanitizePath(caCert))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read CA certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("there are no valid certificates in " + caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return nil, errors.New("both tlsClientCert and tlsClientKey should be set")
+		}
+		cert, err := tls.LoadX509KeyPair(util.SanitizePath(clientCert), util.SanitizePath(clientKey))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if insecure {
+		logs.WARN.Println(color.RedString("TLS certificate verification is disabled (tlsInsecureSkipVerify). " +
+			"Anybody between you and SoundCloud can read and change your traffic!"))
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+// Get appends the contents of url to dst and returns it as body.
+// It follows redirects. If httpCache is enabled, successful responses
+// are cached on disk.
+func Get(dst []byte, url string) (statusCode int, body []byte, err error) {
+	if cached, ok := cache.get(url); ok {
+		return fasthttp.StatusOK, append(dst, cached...), nil
+	}
+
+	pause()
+	statusCode, body, err = client.Get(dst, url)
+	if err == nil && statusCode == fasthttp.StatusOK {
+		cache.put(url, body[len(dst):])
+	}
+	return statusCode, body, err
+}
+
+// GetMedia is like Get, but responses are never cached, so segments
+// of streams don't evict artworks and API responses from cache.
+func GetMedia(dst []byte, url string) (statusCode int, body []byte, err error) {
+	pause()
+	return client.Get(dst, url)
+}
+
+// PostJSON sends v encoded to JSON to url, e.g. to webhook.
+func PostJSON(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := downloadClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// Do sends the request without body with method and header to url,
+// e.g. to API of media server. Response body is discarded.
+func Do(method, url string, header map[string]string) error {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %v", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package httpclient
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Bounds of random delay between requests in polite mode.
+const (
+	politeMinDelay = 1 * time.Second
+	politeMaxDelay = 3 * time.Second
+)
+
+// polite is set by polite in config. In polite mode requests are sent
+// one by one with random delays and responses are cached, so nehm
+// behind IP shared with other people doesn't get it rate-limited.
+var polite bool
+
+var (
+	politeMu    sync.Mutex
+	politeRand  = rand.New(rand.NewSource(time.Now().UnixNano()))
+	lastRequest time.Time
+)
+
+// Throttle is called before each request, which isn't answered from cache,
+// e.g. to wait for the rate limit shared with other processes.
+var Throttle func()
+
+// Polite reports whether polite mode is enabled. Callers should
+// download tracks one by one then.
+func Polite() bool {
+	return polite
+}
+
+// pause waits, until random delay after the previous request is over.
+// It does nothing, if polite mode is disabled. Throttle is called anyway.
+func pause() {
+	if Throttle != nil {
+		Throttle()
+	}
+	if !polite {
+		return
+	}
+	politeMu.Lock()
+	defer politeMu.Unlock()
+
+	delay := politeMinDelay + time.Duration(politeRand.Int63n(int64(politeMaxDelay-politeMinDelay)))
+	if wait := time.Until(lastRequest.Add(delay)); wait > 0 {
+		time.Sleep(wait)
+	}
+	lastRequest = time.Now()
+}
@@ -0,0 +1,457 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package index keeps the persistent list of tracks downloaded by nehm.
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/filelock"
+)
+
+// Entry is a record about one downloaded track.
+type Entry struct {
+	ID      int       `json:"id"`
+	Path    string    `json:"path"`
+	Artist  string    `json:"artist"`
+	Title   string    `json:"title"`
+	AddedAt time.Time `json:"added_at"`
+
+	// Filename is the name of track file given on the first download.
+	// It's reused on downloading track again, so filename doesn't change,
+	// even if normalization rules or fileNameTemplate are changed.
+	Filename string `json:"filename,omitempty"`
+
+	// OldNames holds the previous names ("Artist — Title") of track,
+	// if it was renamed on SoundCloud after downloading.
+	OldNames []string `json:"old_names,omitempty"`
+
+	// Album and TrackNumber are set, if track was downloaded as a part
+	// of album (see album in config).
+	Album       string `json:"album,omitempty"`
+	TrackNumber int    `json:"track_number,omitempty"`
+
+	Genre string `json:"genre,omitempty"`
+
+	// BuyURL and FreeDownloadURL are links to better versions of track.
+	// Purchased is set by user, when track was bought.
+	BuyURL          string `json:"buy_url,omitempty"`
+	FreeDownloadURL string `json:"free_download_url,omitempty"`
+	Purchased       bool   `json:"purchased,omitempty"`
+
+	// DescriptionHash is the hash of description of track (see
+	// track.DescriptionHash), so changes of description can be detected.
+	DescriptionHash string `json:"description_hash,omitempty"`
+
+	// Label is the label of batch, in which track was downloaded,
+	// e.g. "festival prep" (--label flag).
+	Label string `json:"label,omitempty"`
+
+	// Protected is set by user for tracks, which disappearance from
+	// SoundCloud should be alerted, because local copy is the only one then.
+	Protected bool `json:"protected,omitempty"`
+
+	// Links are the paths of copies of track, which were linked
+	// to Path in link mode (see linkMode in config).
+	Links []string `json:"links,omitempty"`
+
+	// ItunesID is the persistent ID of track in iTunes library,
+	// if track was added to iTunes.
+	ItunesID string `json:"itunes_id,omitempty"`
+}
+
+// HasLink returns true, if track was linked to path.
+func (e Entry) HasLink(path string) bool {
+	path = filepath.Clean(path)
+	for _, l := range e.Links {
+		if filepath.Clean(l) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Fullname returns the name of track in the same format as track.Fullname.
+func (e Entry) Fullname() string {
+	return e.Artist + " — " + e.Title
+}
+
+// Unavailable is a record about track, which couldn't be downloaded,
+// because it was private, geo-blocked or not streamable.
+type Unavailable struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason"`
+	Since     time.Time `json:"since"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// file is the format of index file.
+type file struct {
+	Tracks      []Entry       `json:"tracks"`
+	Unavailable []Unavailable `json:"unavailable,omitempty"`
+}
+
+var (
+	mu          sync.Mutex
+	entries     map[int]Entry
+	unavailable map[int]Unavailable
+	loaded      bool
+
+	// changed and changedUnavailable are IDs of tracks, which entries
+	// were added, changed or removed since the index was loaded or saved.
+	// Other entries are updated from disk on saving, so changes made
+	// by other nehm processes (e.g. watch daemon) aren't lost.
+	changed            map[int]bool
+	changedUnavailable map[int]bool
+)
+
+func indexPath() string {
+	return filepath.Join(config.StateDir(), "library.json")
+}
+
+// Load reads the index from disk. If there is no index file yet,
+// Load starts with an empty index.
+func Load() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	entries = make(map[int]Entry)
+	unavailable = make(map[int]Unavailable)
+	changed = make(map[int]bool)
+	changedUnavailable = make(map[int]bool)
+	f, err := readFile(indexPath())
+	if err != nil {
+		return err
+	}
+	for _, e := range f.Tracks {
+		entries[e.ID] = e
+	}
+	for _, u := range f.Unavailable {
+		unavailable[u.ID] = u
+	}
+
+	loaded = true
+	return nil
+}
+
+// readFile reads the index file at path. If there is no such file,
+// it returns the empty index.
+func readFile(path string) (file, error) {
+	var f file
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return f, fmt.Errorf("couldn't read the index file: %v", err)
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		// The first version of index was only the list of entries.
+		if err := json.Unmarshal(data, &f.Tracks); err != nil {
+			return f, fmt.Errorf("couldn't unmarshal the index file: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// merge replaces entries, which weren't changed by this process,
+// with entries from index file at path.
+func merge(path string) error {
+	f, err := readFile(path)
+	if err != nil {
+		return err
+	}
+
+	onDisk := make(map[int]bool, len(f.Tracks))
+	for _, e := range f.Tracks {
+		onDisk[e.ID] = true
+		if !changed[e.ID] {
+			entries[e.ID] = e
+		}
+	}
+	for id := range entries {
+		if !onDisk[id] && !changed[id] {
+			delete(entries, id)
+		}
+	}
+
+	onDisk = make(map[int]bool, len(f.Unavailable))
+	for _, u := range f.Unavailable {
+		onDisk[u.ID] = true
+		if !changedUnavailable[u.ID] {
+			unavailable[u.ID] = u
+		}
+	}
+	for id := range unavailable {
+		if !onDisk[id] && !changedUnavailable[id] {
+			delete(unavailable, id)
+		}
+	}
+	return nil
+}
+
+// Save writes the index to disk. It does nothing, if the index
+// wasn't loaded, so the existing index file is never truncated.
+// Index file is locked while saving, and changes saved by other
+// nehm processes since loading are merged.
+func Save() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !loaded {
+		return nil
+	}
+
+	path := indexPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("couldn't create the index folder: %v", err)
+	}
+	return filelock.Exclusive(path+".lock", func() error {
+		if err := merge(path); err != nil {
+			return err
+		}
+		if err := write(path); err != nil {
+			return err
+		}
+		changed = make(map[int]bool)
+		changedUnavailable = make(map[int]bool)
+		return nil
+	})
+}
+
+// write writes entries to index file at path.
+func write(path string) error {
+	f := file{Tracks: all()}
+	for _, u := range unavailable {
+		f.Unavailable = append(f.Unavailable, u)
+	}
+	sort.Slice(f.Unavailable, func(i, j int) bool {
+		return f.Unavailable[i].ID < f.Unavailable[j].ID
+	})
+
+	data, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal the index: %v", err)
+	}
+
+	// Write to temporary file first, so the index isn't corrupted
+	// if nehm is interrupted while writing.
+	tmpPath := path + "." + strconv.Itoa(os.Getpid()) + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("couldn't write the index file: %v", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Add adds e to the index or replaces the entry with the same ID.
+func Add(e Entry) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if entries == nil {
+		entries = make(map[int]Entry)
+	}
+	if e.AddedAt.IsZero() {
+		e.AddedAt = time.Now()
+	}
+	entries[e.ID] = e
+	markChanged(e.ID)
+}
+
+func markChanged(id int) {
+	if changed == nil {
+		changed = make(map[int]bool)
+	}
+	changed[id] = true
+}
+
+func markChangedUnavailable(id int) {
+	if changedUnavailable == nil {
+		changedUnavailable = make(map[int]bool)
+	}
+	changedUnavailable[id] = true
+}
+
+// Get returns the entry of track with id and whether it exists.
+func Get(id int) (Entry, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	e, exists := entries[id]
+	return e, exists
+}
+
+// GetByPath returns the entry of track, which file is at path,
+// and whether it exists.
+func GetByPath(path string) (Entry, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	path = filepath.Clean(path)
+	for _, e := range entries {
+		if filepath.Clean(e.Path) == path {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// NextTrackNumber returns the number, which should be given to the next
+// track of album, so track numbers continue across sync runs.
+func NextTrackNumber(album string) int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var max int
+	for _, e := range entries {
+		if e.Album == album && e.TrackNumber > max {
+			max = e.TrackNumber
+		}
+	}
+	return max + 1
+}
+
+// MarkPurchased marks track with id as purchased.
+// It returns false, if there is no such track in index.
+func MarkPurchased(id int) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	e, exists := entries[id]
+	if !exists {
+		return false
+	}
+	e.Purchased = true
+	entries[id] = e
+	markChanged(id)
+	return true
+}
+
+// SetProtected sets whether track with id is protected.
+// It returns false, if there is no such track in index.
+func SetProtected(id int, protected bool) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	e, exists := entries[id]
+	if !exists {
+		return false
+	}
+	e.Protected = protected
+	entries[id] = e
+	markChanged(id)
+	return true
+}
+
+// Protected returns protected entries sorted by the time they were added.
+func Protected() []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var list []Entry
+	for _, e := range all() {
+		if e.Protected {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// WithLabel returns entries with label sorted by the time they were added.
+func WithLabel(label string) []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var list []Entry
+	for _, e := range all() {
+		if e.Label == label {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// Remove removes the entry of track with id from the index.
+func Remove(id int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(entries, id)
+	markChanged(id)
+}
+
+// All returns all entries sorted by the time they were added.
+func All() []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return all()
+}
+
+func all() []Entry {
+	list := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].AddedAt.Equal(list[j].AddedAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].AddedAt.Before(list[j].AddedAt)
+	})
+	return list
+}
+
+// MarkUnavailable records that track with id and name couldn't be
+// downloaded because of reason. If track is already marked,
+// only the time of check is updated.
+func MarkUnavailable(id int, name, reason string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if unavailable == nil {
+		unavailable = make(map[int]Unavailable)
+	}
+	now := time.Now()
+	u, exists := unavailable[id]
+	if !exists {
+		u = Unavailable{ID: id, Name: name, Since: now}
+	}
+	u.Reason = reason
+	u.CheckedAt = now
+	unavailable[id] = u
+	markChangedUnavailable(id)
+}
+
+// MarkAvailable removes track with id from the list of unavailable tracks.
+func MarkAvailable(id int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := unavailable[id]; exists {
+		delete(unavailable, id)
+		markChangedUnavailable(id)
+	}
+}
+
+// GetUnavailable returns the record about unavailable track with id
+// and whether it exists.
+func GetUnavailable(id int) (Unavailable, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	u, exists := unavailable[id]
+	return u, exists
+}
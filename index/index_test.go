@@ -0,0 +1,83 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/nehm/config"
+)
+
+// saveByOtherProcess writes index file with tracks, like other
+// nehm process would do it.
+func saveByOtherProcess(t *testing.T, tracks ...Entry) {
+	data, err := json.Marshal(file{Tracks: tracks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(indexPath(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveMergesChangesOfOtherProcesses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nehm-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Set("stateDir", dir)
+	defer config.Set("stateDir", "")
+
+	saveByOtherProcess(t, Entry{ID: 1, Title: "One"}, Entry{ID: 2, Title: "Two"})
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other process adds 3, changes 2 and removes 1, while this process
+	// adds 4 and protects 2.
+	saveByOtherProcess(t, Entry{ID: 2, Title: "Two (edit)"}, Entry{ID: 3, Title: "Three"})
+	Add(Entry{ID: 4, Title: "Four"})
+	SetProtected(2, true)
+	if err := Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := Get(1); exists {
+		t.Error("entry removed by other process is restored")
+	}
+	if _, exists := Get(3); !exists {
+		t.Error("entry added by other process is lost")
+	}
+	if _, exists := Get(4); !exists {
+		t.Error("entry added by this process is lost")
+	}
+	if e, _ := Get(2); !e.Protected {
+		t.Error("entry changed by this process isn't saved")
+	}
+
+	// Unchanged entries are updated from disk.
+	saveByOtherProcess(t, Entry{ID: 2, Title: "Two", Protected: true}, Entry{ID: 3, Title: "Three (edit)"}, Entry{ID: 4, Title: "Four"})
+	Remove(4)
+	if err := Save(); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := Get(3); e.Title != "Three (edit)" {
+		t.Errorf("entry changed by other process isn't merged: %q", e.Title)
+	}
+	if _, exists := Get(4); exists {
+		t.Error("entry removed by this process is restored")
+	}
+	if matches, _ := filepath.Glob(dir + "/*.tmp"); len(matches) > 0 {
+		t.Errorf("temporary files are left: %v", matches)
+	}
+}
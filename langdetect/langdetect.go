@@ -0,0 +1,172 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package langdetect guesses the language of short texts, like titles and
+// descriptions of tracks. It uses scripts of letters and common words,
+// so it's fast, but it recognizes only the most common languages.
+package langdetect
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Language is the detected language.
+type Language struct {
+	// Code is ISO-639-2 code, which is used in TLAN frame.
+	Code string
+	Name string
+}
+
+var (
+	arabic     = Language{"ara", "Arabic"}
+	chinese    = Language{"chi", "Chinese"}
+	english    = Language{"eng", "English"}
+	french     = Language{"fre", "French"}
+	german     = Language{"ger", "German"}
+	greek      = Language{"gre", "Greek"}
+	hebrew     = Language{"heb", "Hebrew"}
+	hindi      = Language{"hin", "Hindi"}
+	italian    = Language{"ita", "Italian"}
+	japanese   = Language{"jpn", "Japanese"}
+	korean     = Language{"kor", "Korean"}
+	portuguese = Language{"por", "Portuguese"}
+	russian    = Language{"rus", "Russian"}
+	spanish    = Language{"spa", "Spanish"}
+	thai       = Language{"tha", "Thai"}
+	turkish    = Language{"tur", "Turkish"}
+	ukrainian  = Language{"ukr", "Ukrainian"}
+)
+
+// stopwords are common words of languages using Latin script.
+var stopwords = map[Language][]string{
+	english:    {"the", "and", "you", "of", "in", "is", "my", "me", "your", "love", "with", "for", "this", "it", "on", "we", "don't", "i'm", "all", "night"},
+	spanish:    {"el", "la", "los", "las", "que", "de", "y", "en", "mi", "tu", "amor", "con", "para", "por", "una", "es", "se", "corazón", "noche", "sin"},
+	french:     {"le", "la", "les", "et", "de", "des", "une", "est", "je", "tu", "moi", "toi", "pour", "dans", "avec", "pas", "mon", "ma", "nuit", "amour"},
+	german:     {"der", "die", "das", "und", "ich", "du", "nicht", "ist", "mit", "ein", "eine", "mein", "dich", "mich", "auf", "für", "liebe", "nacht", "wir", "zu"},
+	portuguese: {"o", "os", "as", "que", "de", "e", "em", "um", "uma", "meu", "você", "não", "com", "para", "amor", "coração", "noite", "sem", "é", "do"},
+	italian:    {"il", "lo", "gli", "che", "di", "e", "un", "una", "non", "per", "con", "mio", "tu", "sono", "amore", "cuore", "notte", "del", "della", "ti"},
+	turkish:    {"bir", "ve", "ben", "sen", "bu", "ne", "aşk", "gece", "için", "gibi", "değil", "beni", "seni", "daha", "çok", "kalbim", "yok", "var", "ile", "da"},
+}
+
+// letters are letters, which appear only in one of languages above.
+var letters = map[rune]Language{
+	'ñ': spanish, '¿': spanish, '¡': spanish,
+	'ß': german,
+	'ç': french, 'œ': french, 'è': french, 'ê': french,
+	'ã': portuguese, 'õ': portuguese,
+	'ğ': turkish, 'ı': turkish, 'ş': turkish,
+}
+
+// Detect returns the language of text and whether it was detected.
+func Detect(text string) (Language, bool) {
+	if l, ok := detectScript(text); ok {
+		return l, true
+	}
+	return detectLatin(text)
+}
+
+// detectScript detects languages, which use their own scripts.
+func detectScript(text string) (Language, bool) {
+	var total, latin int
+	counts := make(map[Language]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		total++
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			counts[japanese]++
+		case unicode.Is(unicode.Han, r):
+			counts[chinese]++
+		case unicode.Is(unicode.Hangul, r):
+			counts[korean]++
+		case unicode.Is(unicode.Cyrillic, r):
+			if strings.ContainsRune("іїєґІЇЄҐ", r) {
+				counts[ukrainian] += 100 // These letters are not in Russian.
+			}
+			counts[russian]++
+		case unicode.Is(unicode.Arabic, r):
+			counts[arabic]++
+		case unicode.Is(unicode.Hebrew, r):
+			counts[hebrew]++
+		case unicode.Is(unicode.Greek, r):
+			counts[greek]++
+		case unicode.Is(unicode.Thai, r):
+			counts[thai]++
+		case unicode.Is(unicode.Devanagari, r):
+			counts[hindi]++
+		}
+	}
+	if total == 0 || latin*2 >= total {
+		return Language{}, false
+	}
+
+	// Japanese texts contain kanji (Han), but kana means Japanese.
+	if counts[japanese] > 0 {
+		return japanese, true
+	}
+	if counts[ukrainian] > 0 {
+		return ukrainian, true
+	}
+	return max(counts)
+}
+
+// detectLatin detects languages using Latin script by common words
+// and specific letters.
+func detectLatin(text string) (Language, bool) {
+	text = strings.ToLower(text)
+	counts := make(map[Language]int)
+
+	for _, r := range text {
+		if l, ok := letters[r]; ok {
+			counts[l] += 2
+		}
+	}
+
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	isWord := make(map[string]bool, len(words))
+	for _, w := range words {
+		isWord[w] = true
+	}
+	for l, list := range stopwords {
+		for _, w := range list {
+			if isWord[w] {
+				counts[l]++
+			}
+		}
+	}
+
+	// One common word is too little, e.g. "de" is in several languages.
+	l, ok := max(counts)
+	if !ok || counts[l] < 2 {
+		return Language{}, false
+	}
+	return l, true
+}
+
+// max returns the language with the most count. If there are several
+// such languages, it returns false.
+func max(counts map[Language]int) (Language, bool) {
+	var best Language
+	var bestCount int
+	var ambiguous bool
+	for l, c := range counts {
+		switch {
+		case c > bestCount:
+			best, bestCount, ambiguous = l, c, false
+		case c == bestCount:
+			ambiguous = true
+		}
+	}
+	if bestCount == 0 || ambiguous {
+		return Language{}, false
+	}
+	return best, true
+}
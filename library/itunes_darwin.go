@@ -0,0 +1,75 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package library
+
+import (
+	"strings"
+
+	"github.com/bogem/nehm/applescript"
+)
+
+// Program is the program, which controls iTunes.
+const Program = "osascript"
+
+func newDefault() MusicLibraryAdder {
+	return appleScriptLibrary{}
+}
+
+// appleScriptLibrary controls iTunes with AppleScript.
+type appleScriptLibrary struct{}
+
+func (appleScriptLibrary) AddTrackToPlaylist(trackPath, playlistName string, props TrackProperties) (AddedTrack, error) {
+	added, err := applescript.AddTrackToPlaylist(trackPath, playlistName, applescript.TrackProperties(props))
+	return AddedTrack(added), err
+}
+
+func (appleScriptLibrary) AddTracksToPlaylist(trackPaths []string, playlistName string, props []TrackProperties) ([]AddResult, error) {
+	asProps := make([]applescript.TrackProperties, len(props))
+	for i, p := range props {
+		asProps[i] = applescript.TrackProperties(p)
+	}
+	asResults, err := applescript.AddTracksToPlaylist(trackPaths, playlistName, asProps)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]AddResult, len(asResults))
+	for i, r := range asResults {
+		results[i] = AddResult{AddedTrack(r.AddedTrack), r.Err}
+	}
+	return results, nil
+}
+
+func (appleScriptLibrary) CreatePlaylist(playlistName string) error {
+	return applescript.CreatePlaylist(playlistName)
+}
+
+func (appleScriptLibrary) CheckAndCreatePlaylist(playlistName, folderName string) error {
+	return applescript.CheckAndCreatePlaylist(playlistName, folderName)
+}
+
+func (appleScriptLibrary) Playlists() ([]string, error) {
+	out, err := applescript.ListOfPlaylists()
+	if err != nil {
+		return nil, err
+	}
+	// AppleScript lists are separated by commas.
+	return strings.Split(out, ", "), nil
+}
+
+func (appleScriptLibrary) TracksOfPlaylist(playlistName string) ([]PlaylistTrack, error) {
+	list, err := applescript.TracksOfPlaylist(playlistName)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]PlaylistTrack, len(list))
+	for i, t := range list {
+		tracks[i] = PlaylistTrack(t)
+	}
+	return tracks, nil
+}
+
+func (appleScriptLibrary) IsBusy(err error) bool {
+	return applescript.IsBusy(err)
+}
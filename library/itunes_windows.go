@@ -0,0 +1,249 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package library
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/nehm/tempdir"
+)
+
+// script is JScript, which controls iTunes through its COM interface.
+// It's executed by Windows Script Host (cscript).
+var script = []byte(`
+var args = WScript.Arguments;
+var iTunes = WScript.CreateObject("iTunes.Application");
+var playlists = iTunes.LibrarySource.Playlists;
+
+function playlistByName(name) {
+	var playlist = playlists.ItemByName(name);
+	if (!playlist) {
+		throw new Error("playlist " + name + " doesn't exist");
+	}
+	return playlist;
+}
+
+function addFile(playlist, path, rating) {
+	var status = playlist.AddFile(path);
+	// Files are added asynchronously.
+	while (status && status.InProgress) {
+		WScript.Sleep(100);
+	}
+	// iTunes adds nothing without error, if format of file is not supported.
+	if (!status || status.Tracks.Count == 0) {
+		throw new Error("iTunes rejected " + path + ", its format may be unsupported");
+	}
+	var added = status.Tracks.Item(1);
+	if (parseInt(rating, 10) > 0) {
+		added.Rating = parseInt(rating, 10) * 20;
+	}
+	var high = iTunes.ITObjectPersistentIDHigh(added);
+	var low = iTunes.ITObjectPersistentIDLow(added);
+	if (!playlist.Tracks.ItemByPersistentID(high, low)) {
+		throw new Error("track " + path + " isn't in playlist " + playlist.Name + " after adding");
+	}
+	return hex(high) + hex(low) + "\t" + added.Location;
+}
+
+// hex formats 32-bit part of persistent ID as it's shown by iTunes.
+function hex(n) {
+	var s = (n >>> 0).toString(16).toUpperCase();
+	while (s.length < 8) {
+		s = "0" + s;
+	}
+	return s;
+}
+
+switch (args.Item(0)) {
+case "add_track_to_playlist":
+	WScript.Echo(addFile(playlistByName(args.Item(2)), args.Item(1), args.Item(3)));
+	break;
+case "add_tracks_to_playlist":
+	// Arguments are the playlist and then path and rating of each track.
+	// Tracks are added, even if some of them fail.
+	var playlist = playlistByName(args.Item(1));
+	for (var i = 2; i + 1 < args.Count; i += 2) {
+		try {
+			WScript.Echo("ok\t" + addFile(playlist, args.Item(i), args.Item(i + 1)));
+		} catch (e) {
+			WScript.Echo("error\t" + e.message);
+		}
+	}
+	break;
+case "create_playlist":
+	if (!playlists.ItemByName(args.Item(1))) {
+		iTunes.CreatePlaylist(args.Item(1));
+	}
+	break;
+case "check_and_create_playlist":
+	if (!playlists.ItemByName(args.Item(1))) {
+		if (args.Count < 3) {
+			iTunes.CreatePlaylist(args.Item(1));
+		} else {
+			var folder = playlists.ItemByName(args.Item(2));
+			if (!folder) {
+				folder = iTunes.CreateFolder(args.Item(2));
+			}
+			folder.CreatePlaylist(args.Item(1));
+		}
+	}
+	break;
+case "list_of_playlists":
+	for (var i = 1; i <= playlists.Count; i++) {
+		WScript.Echo(playlists.Item(i).Name);
+	}
+	break;
+case "list_tracks_of_playlist":
+	var tracks = playlistByName(args.Item(1)).Tracks;
+	for (var i = 1; i <= tracks.Count; i++) {
+		var t = tracks.Item(i);
+		var location = "";
+		try {
+			location = t.Location || "";
+		} catch (e) {}
+		WScript.Echo(t.Artist + "\t" + t.Name + "\t" + location);
+	}
+	break;
+}
+`)
+
+var scriptFile *os.File
+
+// Program is the program, which controls iTunes.
+const Program = "cscript"
+
+func newDefault() MusicLibraryAdder {
+	return comLibrary{}
+}
+
+// comLibrary controls iTunes for Windows with COM automation.
+// Loved tracks are not supported by COM interface of iTunes.
+type comLibrary struct{}
+
+func (comLibrary) AddTrackToPlaylist(trackPath, playlistName string, props TrackProperties) (AddedTrack, error) {
+	absPath, err := filepath.Abs(trackPath)
+	if err != nil {
+		return AddedTrack{}, err
+	}
+	out, err := executeScript("add_track_to_playlist", absPath, playlistName, strconv.Itoa(props.Rating))
+	if err != nil {
+		return AddedTrack{}, err
+	}
+	return parseAddedTrack(out)
+}
+
+func (comLibrary) AddTracksToPlaylist(trackPaths []string, playlistName string, props []TrackProperties) ([]AddResult, error) {
+	args := []string{"add_tracks_to_playlist", playlistName}
+	for i, path := range trackPaths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, absPath, strconv.Itoa(props[i].Rating))
+	}
+	out, err := executeScript(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(out, "\n")
+	results := make([]AddResult, len(trackPaths))
+	for i := range results {
+		if i >= len(lines) {
+			results[i].Err = errors.New("iTunes didn't report the result of adding track")
+			continue
+		}
+		fields := strings.SplitN(strings.TrimRight(lines[i], "\r"), "\t", 2)
+		if len(fields) == 2 && fields[0] == "ok" {
+			results[i].AddedTrack, results[i].Err = parseAddedTrack(fields[1])
+		} else {
+			results[i].Err = errors.New(fields[len(fields)-1])
+		}
+	}
+	return results, nil
+}
+
+// parseAddedTrack parses the output "persistentID\tlocation" of add_track_to_playlist.
+func parseAddedTrack(out string) (AddedTrack, error) {
+	fields := strings.SplitN(out, "\t", 2)
+	if len(fields) != 2 {
+		return AddedTrack{}, fmt.Errorf("unexpected answer of iTunes %q", out)
+	}
+	return AddedTrack{PersistentID: fields[0], Location: fields[1]}, nil
+}
+
+func (comLibrary) CreatePlaylist(playlistName string) error {
+	_, err := executeScript("create_playlist", playlistName)
+	return err
+}
+
+func (comLibrary) CheckAndCreatePlaylist(playlistName, folderName string) error {
+	args := []string{"check_and_create_playlist", playlistName}
+	// Windows Script Host can drop empty arguments, so folder is passed
+	// only if it's set.
+	if folderName != "" {
+		args = append(args, folderName)
+	}
+	_, err := executeScript(args...)
+	return err
+}
+
+func (comLibrary) Playlists() ([]string, error) {
+	out, err := executeScript("list_of_playlists")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range strings.Split(out, "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (comLibrary) TracksOfPlaylist(playlistName string) ([]PlaylistTrack, error) {
+	out, err := executeScript("list_tracks_of_playlist", playlistName)
+	if err != nil {
+		return nil, err
+	}
+	return parseTracks(out), nil
+}
+
+// IsBusy reports whether iTunes rejected the call, because
+// it shows a modal dialog.
+func (comLibrary) IsBusy(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "0x8001010A")
+}
+
+// executeScript executes script with args and returns output and error.
+func executeScript(args ...string) (string, error) {
+	if scriptFile == nil {
+		var err error
+		scriptFile, err = tempdir.TempFile("itunes")
+		if err != nil {
+			return "", fmt.Errorf("couldn't create script file: %v", err)
+		}
+		if _, err = scriptFile.Write(script); err != nil {
+			return "", fmt.Errorf("couldn't write script to file: %v", err)
+		}
+		scriptFile.Close()
+	}
+
+	args = append([]string{"//Nologo", "//E:JScript", scriptFile.Name()}, args...)
+	bOut, err := exec.Command("cscript", args...).CombinedOutput()
+	out := strings.TrimSpace(string(bOut))
+	// When script failed, out contains error message.
+	if err != nil && out != "" {
+		err = errors.New(out)
+	}
+	return out, err
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package library adds downloaded tracks to music library of the system.
+// On macOS iTunes is controlled with AppleScript, on Windows with COM
+// automation of iTunes. On other systems there is no music library.
+package library
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+)
+
+// TrackProperties are the properties set to track added to library.
+// Zero values don't change the properties of track.
+type TrackProperties struct {
+	Loved bool
+	// Rating is the rating in stars from 1 to 5.
+	Rating int
+}
+
+// PlaylistTrack is the track in playlist of library.
+type PlaylistTrack struct {
+	Artist, Title string
+	// Location is the path of track file. It's blank,
+	// if file of track is missing.
+	Location string
+}
+
+// AddedTrack is the track added to library.
+type AddedTrack struct {
+	// PersistentID is the ID of track in library, which
+	// doesn't change, e.g. "6E2F4F8B1A2C3D4E".
+	PersistentID string
+	// Location is the path of track file in library. If library
+	// copies files to its media folder, it differs from added path.
+	Location string
+}
+
+// AddResult is the result of adding one of several tracks.
+type AddResult struct {
+	AddedTrack
+	Err error
+}
+
+// MusicLibraryAdder adds tracks to playlists of music library.
+type MusicLibraryAdder interface {
+	// AddTrackToPlaylist adds track to playlist, sets props to it
+	// and returns the added track. It checks, that track is in playlist,
+	// and returns error, if library rejected the file (e.g. its format).
+	AddTrackToPlaylist(trackPath, playlistName string, props TrackProperties) (AddedTrack, error)
+	// AddTracksToPlaylist adds all tracks at trackPaths to playlist at once
+	// and sets props[i] to the track at trackPaths[i]. results[i] is
+	// the result of track at trackPaths[i]. Error is returned, only if
+	// tracks couldn't be added at all.
+	AddTracksToPlaylist(trackPaths []string, playlistName string, props []TrackProperties) (results []AddResult, err error)
+	// CreatePlaylist creates playlist, if it doesn't exist yet.
+	CreatePlaylist(playlistName string) error
+	// CheckAndCreatePlaylist is like CreatePlaylist, but new playlist
+	// is created in folder with folderName, if it's not blank.
+	CheckAndCreatePlaylist(playlistName, folderName string) error
+	// Playlists returns names of all playlists.
+	Playlists() ([]string, error)
+	// TracksOfPlaylist returns tracks of playlist.
+	TracksOfPlaylist(playlistName string) ([]PlaylistTrack, error)
+	// IsBusy reports whether err means, that library is busy
+	// (e.g. it's syncing) and track should be added later.
+	IsBusy(err error) bool
+}
+
+// ErrUnsupported is returned on systems without music library.
+var ErrUnsupported = errors.New("adding to iTunes is not supported on " + runtime.GOOS)
+
+// Default is the music library of the system.
+var Default MusicLibraryAdder = newDefault()
+
+// Supported reports whether there is music library on the system.
+func Supported() bool {
+	_, unsupported := Default.(unsupportedLibrary)
+	return !unsupported
+}
+
+// unsupportedLibrary is used on systems without music library.
+// All its methods return ErrUnsupported.
+type unsupportedLibrary struct{}
+
+func (unsupportedLibrary) AddTrackToPlaylist(string, string, TrackProperties) (AddedTrack, error) {
+	return AddedTrack{}, ErrUnsupported
+}
+
+func (unsupportedLibrary) AddTracksToPlaylist([]string, string, []TrackProperties) ([]AddResult, error) {
+	return nil, ErrUnsupported
+}
+
+func (unsupportedLibrary) CreatePlaylist(string) error {
+	return ErrUnsupported
+}
+
+func (unsupportedLibrary) CheckAndCreatePlaylist(string, string) error {
+	return ErrUnsupported
+}
+
+func (unsupportedLibrary) Playlists() ([]string, error) {
+	return nil, ErrUnsupported
+}
+
+func (unsupportedLibrary) TracksOfPlaylist(string) ([]PlaylistTrack, error) {
+	return nil, ErrUnsupported
+}
+
+func (unsupportedLibrary) IsBusy(error) bool {
+	return false
+}
+
+// parseTracks parses tracks from lines "artist\ttitle\tlocation".
+func parseTracks(out string) []PlaylistTrack {
+	var tracks []PlaylistTrack
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		tracks = append(tracks, PlaylistTrack{fields[0], fields[1], fields[2]})
+	}
+	return tracks
+}
@@ -0,0 +1,16 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package library
+
+// Program is the program, which controls iTunes.
+// There is no such program on this system.
+const Program = ""
+
+func newDefault() MusicLibraryAdder {
+	return unsupportedLibrary{}
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package manifest writes and verifies manifests with SHA-256 hashes of
+// downloaded files. Manifests have the format of sha256sum, so they can be
+// also verified with "sha256sum -c".
+package manifest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Statuses of verified files.
+const (
+	OK      = "OK"
+	Failed  = "FAILED"
+	Missing = "MISSING"
+)
+
+// Result is the result of verifying one file from manifest.
+type Result struct {
+	Path   string
+	Status string
+}
+
+// Write writes the manifest of files to path. Paths in manifest are
+// relative to the folder of manifest.
+func Write(path string, files []string) error {
+	dir := filepath.Dir(path)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, file := range files {
+		hash, err := Hash(file)
+		if err != nil {
+			return fmt.Errorf("couldn't hash %q: %v", file, err)
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			rel = file
+		}
+		fmt.Fprintf(w, "%v  %v\n", hash, filepath.ToSlash(rel))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Verify checks the files listed in manifest at path.
+func Verify(path string) ([]Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(path)
+	var results []Result
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "  ", 2)
+		if len(parts) != 2 {
+			return results, errors.New("invalid line in manifest: " + line)
+		}
+
+		file := filepath.FromSlash(parts[1])
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+
+		status := OK
+		hash, err := Hash(file)
+		if os.IsNotExist(err) {
+			status = Missing
+		} else if err != nil || hash != parts[0] {
+			status = Failed
+		}
+		results = append(results, Result{Path: file, Status: status})
+	}
+	return results, scanner.Err()
+}
+
+// Hash returns hex-encoded SHA-256 hash of file at path.
+func Hash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package mediaserver asks Plex or Jellyfin to scan its library,
+// after tracks are downloaded, so they appear there at once.
+package mediaserver
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/httpclient"
+	"github.com/bogem/nehm/logs"
+)
+
+// Kinds of media servers.
+const (
+	Plex     = "plex"
+	Jellyfin = "jellyfin"
+)
+
+// Server is the media server set in mediaServer section of config.
+type Server struct {
+	Kind  string
+	URL   string
+	Token string
+	// Section is the ID of library section in Plex or
+	// the ID of library (collection folder) in Jellyfin.
+	Section string
+}
+
+// FromConfig returns the server from mediaServer section of config
+// or nil, if it's not set.
+// The program is terminating, if section is invalid.
+func FromConfig() *Server {
+	section := config.GetStringMap("mediaServer")
+	if len(section) == 0 {
+		return nil
+	}
+
+	s := &Server{
+		Kind:    strings.ToLower(section["type"]),
+		URL:     strings.TrimSuffix(section["url"], "/"),
+		Token:   section["token"],
+		Section: section["section"],
+	}
+	if s.Kind != Plex && s.Kind != Jellyfin {
+		logs.FATAL.Fatalf("invalid type %q in mediaServer. Use %q or %q.\n", section["type"], Plex, Jellyfin)
+	}
+	if u, err := url.Parse(s.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		logs.FATAL.Fatalf("invalid url %q in mediaServer: it should be like http://localhost:32400\n", s.URL)
+	}
+	if s.Token == "" {
+		logs.FATAL.Fatalln("mediaServer needs token to access API of server")
+	}
+	if s.Section == "" {
+		logs.FATAL.Fatalln("mediaServer needs section, the ID of library, which should be scanned")
+	}
+	return s
+}
+
+// Refresh asks server to scan the library section.
+func (s Server) Refresh() error {
+	section := url.PathEscape(s.Section)
+	switch s.Kind {
+	case Plex:
+		return httpclient.Do(http.MethodGet, s.URL+"/library/sections/"+section+"/refresh", map[string]string{
+			"X-Plex-Token": s.Token,
+		})
+	default:
+		return httpclient.Do(http.MethodPost, s.URL+"/Items/"+section+"/Refresh?Recursive=true", map[string]string{
+			"X-Emby-Token": s.Token,
+		})
+	}
+}
+
+// Name returns the human-readable name of server.
+func (s Server) Name() string {
+	if s.Kind == Plex {
+		return "Plex"
+	}
+	return "Jellyfin"
+}
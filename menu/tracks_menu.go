@@ -21,6 +21,12 @@ func NewTracksMenu(firstPageURL string) *TracksMenu {
 	return &TracksMenu{paginator: api.NewPaginator(firstPageURL)}
 }
 
+// NewTracksMenuFromTracks returns TracksMenu, which shows tracks
+// by pages with limit tracks on each page.
+func NewTracksMenuFromTracks(tracks []track.Track, limit uint) *TracksMenu {
+	return &TracksMenu{paginator: api.NewPaginatorFromTracks(tracks, limit)}
+}
+
 // TracksMenu gets tracks from paginator, shows them in menu
 // and returns selected tracks.
 //
@@ -0,0 +1,241 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package mp3 reads the bitrate and duration of MP3 files
+// from headers of MPEG frames.
+package mp3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// Info is the information about MP3 stream.
+type Info struct {
+	// Bitrate is the bitrate in kbit/s. For VBR streams it's average.
+	Bitrate  int
+	Duration time.Duration
+	VBR      bool
+}
+
+// ErrNoFrames is returned, if there are no MPEG frames
+// in the beginning of file.
+var ErrNoFrames = errors.New("there are no MPEG frames")
+
+// searchLimit is the count of bytes after ID3v2 tag,
+// where the first frame is searched.
+const searchLimit = 64 << 10
+
+// ReadInfo reads the information about MP3 file at path.
+func ReadInfo(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+
+	start, err := tagSize(f)
+	if err != nil {
+		return Info{}, err
+	}
+	end := fi.Size()
+	if hasID3v1(f, end) {
+		end -= 128
+	}
+
+	buf := make([]byte, searchLimit)
+	n, err := f.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return Info{}, err
+	}
+	buf = buf[:n]
+
+	i := firstFrame(buf)
+	if i < 0 {
+		return Info{}, ErrNoFrames
+	}
+	h, _ := parseHeader(buf[i:])
+	return h.info(buf[i:], end-start-int64(i)), nil
+}
+
+// tagSize returns the size of ID3v2 tag in the beginning of f.
+func tagSize(f *os.File) (int64, error) {
+	header := make([]byte, 10)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return 0, ErrNoFrames
+		}
+		return 0, err
+	}
+	if !bytes.HasPrefix(header, []byte("ID3")) {
+		return 0, nil
+	}
+	// Size is "synchsafe" integer.
+	size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+	size += 10
+	if header[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return size, nil
+}
+
+func hasID3v1(f *os.File, size int64) bool {
+	if size < 128 {
+		return false
+	}
+	marker := make([]byte, 3)
+	if _, err := f.ReadAt(marker, size-128); err != nil {
+		return false
+	}
+	return string(marker) == "TAG"
+}
+
+const (
+	mpeg1 = iota
+	mpeg2
+	mpeg25
+)
+
+var bitrates = map[[2]int][16]int{
+	{mpeg1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{mpeg1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{mpeg1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{mpeg2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{mpeg2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{mpeg2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var sampleRates = [3][3]int{
+	mpeg1:  {44100, 48000, 32000},
+	mpeg2:  {22050, 24000, 16000},
+	mpeg25: {11025, 12000, 8000},
+}
+
+// header is the parsed header of MPEG frame.
+type header struct {
+	version    int
+	layer      int
+	bitrate    int // kbit/s
+	sampleRate int
+	padding    bool
+	mono       bool
+}
+
+func parseHeader(b []byte) (header, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return header{}, false
+	}
+
+	var h header
+	switch (b[1] >> 3) & 3 {
+	case 0:
+		h.version = mpeg25
+	case 2:
+		h.version = mpeg2
+	case 3:
+		h.version = mpeg1
+	default:
+		return header{}, false
+	}
+	h.layer = 4 - int((b[1]>>1)&3)
+	if h.layer == 4 {
+		return header{}, false
+	}
+
+	bitrateIndex := b[2] >> 4
+	sampleRateIndex := (b[2] >> 2) & 3
+	if bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return header{}, false
+	}
+	table := h.version
+	if table == mpeg25 {
+		table = mpeg2
+	}
+	h.bitrate = bitrates[[2]int{table, h.layer}][bitrateIndex]
+	h.sampleRate = sampleRates[h.version][sampleRateIndex]
+	h.padding = b[2]&2 != 0
+	h.mono = b[3]>>6 == 3
+	return h, true
+}
+
+// samplesPerFrame returns the count of samples in one frame.
+func (h header) samplesPerFrame() int {
+	switch {
+	case h.layer == 1:
+		return 384
+	case h.layer == 3 && h.version != mpeg1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// frameSize returns the size of frame in bytes.
+func (h header) frameSize() int {
+	var padding int
+	if h.padding {
+		padding = 1
+	}
+	if h.layer == 1 {
+		return (12*h.bitrate*1000/h.sampleRate + padding) * 4
+	}
+	return h.samplesPerFrame()/8*h.bitrate*1000/h.sampleRate + padding
+}
+
+// info returns the information about stream starting with frame
+// with header h. audioSize is the size of stream in bytes.
+func (h header) info(frame []byte, audioSize int64) Info {
+	if frames, ok := vbrFrames(h, frame); ok && frames > 0 {
+		seconds := float64(frames) * float64(h.samplesPerFrame()) / float64(h.sampleRate)
+		return Info{
+			Bitrate:  int(float64(audioSize) * 8 / seconds / 1000),
+			Duration: time.Duration(seconds * float64(time.Second)),
+			VBR:      true,
+		}
+	}
+
+	seconds := float64(audioSize) * 8 / float64(h.bitrate*1000)
+	return Info{
+		Bitrate:  h.bitrate,
+		Duration: time.Duration(seconds * float64(time.Second)),
+	}
+}
+
+// vbrFrames returns the count of frames from Xing or VBRI header,
+// which is written in the first frame of VBR streams.
+func vbrFrames(h header, frame []byte) (uint32, bool) {
+	// Offset of Xing header depends on version and channel mode.
+	offset := 4 + 32
+	switch {
+	case h.version == mpeg1 && h.mono:
+		offset = 4 + 17
+	case h.version != mpeg1 && !h.mono:
+		offset = 4 + 17
+	case h.version != mpeg1 && h.mono:
+		offset = 4 + 9
+	}
+	if len(frame) >= offset+12 {
+		id := string(frame[offset : offset+4])
+		flags := binary.BigEndian.Uint32(frame[offset+4:])
+		// "Info" is written by LAME to CBR streams.
+		if id == "Xing" && flags&1 != 0 {
+			return binary.BigEndian.Uint32(frame[offset+8:]), true
+		}
+	}
+
+	const vbriOffset = 4 + 32
+	if len(frame) >= vbriOffset+18 && string(frame[vbriOffset:vbriOffset+4]) == "VBRI" {
+		return binary.BigEndian.Uint32(frame[vbriOffset+14:]), true
+	}
+	return 0, false
+}
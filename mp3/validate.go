@@ -0,0 +1,106 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package mp3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// ErrTruncated is returned by Validate, if the last frame of stream
+// is cut off, e.g. because download was interrupted.
+var ErrTruncated = errors.New("the last MPEG frame is truncated")
+
+// trailingTags are markers of tags, which can follow the last frame.
+var trailingTags = [][]byte{[]byte("APETAGEX"), []byte("LYRICSBEGIN")}
+
+// Validate checks, that MP3 file at path consists of complete MPEG frames
+// following each other. ID3 tags and APE and Lyrics3 tags at the end
+// are skipped. It returns nil, if file is valid.
+func Validate(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(data, []byte("ID3")) && len(data) >= 10 {
+		size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9])
+		size += 10
+		if data[5]&0x10 != 0 {
+			size += 10 // footer
+		}
+		if size > len(data) {
+			return ErrNoFrames
+		}
+		data = data[size:]
+	}
+	if len(data) >= 128 && bytes.Equal(data[len(data)-128:len(data)-125], []byte("TAG")) {
+		data = data[:len(data)-128]
+	}
+
+	pos := firstFrame(data)
+	if pos < 0 {
+		return ErrNoFrames
+	}
+
+	var frames int
+	for pos < len(data) {
+		h, ok := parseHeader(data[pos:])
+		if !ok {
+			if isTrailingTag(data[pos:]) {
+				break
+			}
+			if frames == 0 {
+				return ErrNoFrames
+			}
+			return fmt.Errorf("there is no MPEG frame at offset %v after %v frame(s)", pos, frames)
+		}
+		size := h.frameSize()
+		if size < 4 {
+			return fmt.Errorf("invalid MPEG frame at offset %v", pos)
+		}
+		if pos+size > len(data) {
+			return ErrTruncated
+		}
+		pos += size
+		frames++
+	}
+	if frames == 0 {
+		return ErrNoFrames
+	}
+	return nil
+}
+
+// firstFrame returns the offset of the first frame in data or -1,
+// if there is no frame in searchLimit bytes. Encoders can write padding
+// before the first frame.
+func firstFrame(data []byte) int {
+	for i := 0; i+4 <= len(data) && i < searchLimit; i++ {
+		h, ok := parseHeader(data[i:])
+		if !ok {
+			continue
+		}
+		// Check the next frame too, because sync bits
+		// can occur in random data.
+		if next := i + h.frameSize(); next+4 <= len(data) {
+			if _, ok := parseHeader(data[next:]); !ok {
+				continue
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+func isTrailingTag(b []byte) bool {
+	for _, marker := range trailingTags {
+		if bytes.HasPrefix(b, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package mpd is the minimal client of Music Player Daemon protocol.
+// It's used to add downloaded tracks to MPD database and playlists.
+package mpd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+
+	// updateTimeout is the maximal time of waiting, while MPD
+	// updates its database.
+	updateTimeout = time.Minute
+	pollInterval  = 100 * time.Millisecond
+)
+
+// Client is the connection to MPD.
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Dial connects to MPD at address. Address is "host:port" or the path
+// to unix socket. It may be prefixed with "password@" like MPD_HOST.
+// If there is no port, the default port 6600 is used.
+func Dial(address string) (*Client, error) {
+	var password string
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		password, address = address[:i], address[i+1:]
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	} else if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "6600")
+	}
+
+	conn, err := net.DialTimeout(network, address, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to MPD: %v", err)
+	}
+	c := &Client{conn: conn, r: bufio.NewReader(conn)}
+
+	greeting, err := c.r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("couldn't read greeting of MPD: %v", err)
+	}
+	if !strings.HasPrefix(greeting, "OK MPD ") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting of MPD: %q", strings.TrimSpace(greeting))
+	}
+
+	if password != "" {
+		if _, err := c.command("password", password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	c.command("close")
+	return c.conn.Close()
+}
+
+// MusicDirectory returns the music directory of MPD. MPD gives it
+// only to clients connected through unix socket.
+func (c *Client) MusicDirectory() (string, error) {
+	pairs, err := c.command("config")
+	if err != nil {
+		return "", err
+	}
+	dir := pairs["music_directory"]
+	if dir == "" {
+		return "", errors.New("MPD didn't return music directory")
+	}
+	return dir, nil
+}
+
+// Update updates the database of MPD for uri, which is the path relative
+// to music directory, and waits until the update is finished.
+func (c *Client) Update(uri string) error {
+	pairs, err := c.command("update", uri)
+	if err != nil {
+		return err
+	}
+	job := pairs["updating_db"]
+
+	deadline := time.Now().Add(updateTimeout)
+	for time.Now().Before(deadline) {
+		status, err := c.command("status")
+		if err != nil {
+			return err
+		}
+		if current, updating := status["updating_db"]; !updating || current != job {
+			return nil
+		}
+		time.Sleep(pollInterval)
+	}
+	return fmt.Errorf("MPD didn't update database in %v", updateTimeout)
+}
+
+// PlaylistAdd appends uri to stored playlist name.
+// Playlist is created, if it doesn't exist.
+func (c *Client) PlaylistAdd(name, uri string) error {
+	_, err := c.command("playlistadd", name, uri)
+	return err
+}
+
+// command sends command with args to MPD and returns the pairs of answer.
+func (c *Client) command(name string, args ...string) (map[string]string, error) {
+	line := name
+	for _, arg := range args {
+		line += " " + quote(arg)
+	}
+	c.conn.SetDeadline(time.Now().Add(dialTimeout))
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		return nil, fmt.Errorf("couldn't send command to MPD: %v", err)
+	}
+	if name == "close" {
+		return nil, nil
+	}
+
+	pairs := make(map[string]string)
+	for {
+		l, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read answer of MPD: %v", err)
+		}
+		l = strings.TrimSuffix(l, "\n")
+		if l == "OK" {
+			return pairs, nil
+		}
+		if strings.HasPrefix(l, "ACK ") {
+			return nil, fmt.Errorf("MPD rejected %q: %v", name, ackMessage(l))
+		}
+		if i := strings.Index(l, ": "); i > 0 {
+			pairs[l[:i]] = l[i+2:]
+		}
+	}
+}
+
+// quote quotes arg as MPD expects.
+func quote(arg string) string {
+	arg = strings.Replace(arg, `\`, `\\`, -1)
+	arg = strings.Replace(arg, `"`, `\"`, -1)
+	return `"` + arg + `"`
+}
+
+// ackMessage returns the message of error line,
+// e.g. "ACK [50@0] {playlistadd} No such song".
+func ackMessage(line string) string {
+	if i := strings.Index(line, "} "); i >= 0 {
+		return line[i+2:]
+	}
+	return strings.TrimPrefix(line, "ACK ")
+}
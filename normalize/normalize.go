@@ -0,0 +1,169 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package normalize normalizes artists and titles of tracks:
+// it moves "feat." credits, standardizes remix suffixes and
+// capitalizes words.
+package normalize
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bogem/nehm/config"
+)
+
+// Places, where "feat." credits can be moved.
+const (
+	ToArtist = "artist"
+	ToTitle  = "title"
+)
+
+// Rules holds the toggles of normalization rules.
+type Rules struct {
+	// Feat is the field, where "feat." credits are moved: ToArtist or ToTitle.
+	// If it's blank, credits are kept as is.
+	Feat string
+
+	// Suffixes enables standardizing of "Remix", "Bootleg", "Edit" etc.
+	// suffixes to form "Title (Somebody Remix)".
+	Suffixes bool
+
+	// TitleCase enables capitalizing of the first letter of each word.
+	TitleCase bool
+}
+
+// RulesFromConfig returns rules set in normalize section of config.
+func RulesFromConfig() Rules {
+	m := config.GetStringMap("normalize")
+	suffixes, _ := strconv.ParseBool(m["suffixes"])
+	titleCase, _ := strconv.ParseBool(m["titleCase"])
+	return Rules{
+		Feat:      strings.ToLower(m["feat"]),
+		Suffixes:  suffixes,
+		TitleCase: titleCase,
+	}
+}
+
+// Enabled reports whether at least one rule is enabled.
+func (r Rules) Enabled() bool {
+	return r.Feat != "" || r.Suffixes || r.TitleCase
+}
+
+// Normalize returns artist and title normalized according to r.
+func (r Rules) Normalize(artist, title string) (string, string) {
+	switch r.Feat {
+	case ToArtist:
+		if feat, rest := extractFeat(title); feat != "" {
+			artist, title = artist+" feat. "+feat, rest
+		}
+	case ToTitle:
+		if feat, rest := extractFeat(artist); feat != "" {
+			artist, title = rest, title+" (feat. "+feat+")"
+		}
+	}
+
+	if r.Suffixes {
+		title = standardizeSuffixes(title)
+	}
+
+	if r.TitleCase {
+		artist, title = titleCase(artist), titleCase(title)
+	}
+
+	return artist, title
+}
+
+var featRe = regexp.MustCompile(`(?i)([(\[]\s*)?\b(?:feat\.|feat|ft\.|featuring)\s+`)
+
+// extractFeat returns the credited artists from "feat." part of s
+// and s without this part. If there is no such part, feat is blank.
+//
+// Credits in brackets end with closing bracket, otherwise they end
+// before " - ", opening bracket or at the end of s.
+func extractFeat(s string) (feat, rest string) {
+	loc := featRe.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return "", s
+	}
+
+	start, end := loc[1], len(s)
+	bracketed := loc[2] >= 0
+	if bracketed {
+		if i := strings.IndexAny(s[start:], ")]"); i >= 0 {
+			end = start + i + 1
+			feat = s[start : end-1]
+		} else {
+			feat = s[start:]
+		}
+	} else {
+		if i := strings.Index(s[start:], " - "); i >= 0 {
+			end = start + i
+		}
+		if i := strings.IndexAny(s[start:end], "(["); i >= 0 {
+			end = start + i
+		}
+		feat = s[start:end]
+	}
+
+	rest = strings.Join(strings.Fields(s[:loc[0]]+" "+s[end:]), " ")
+	return strings.TrimSpace(feat), rest
+}
+
+// suffixes maps lower-cased version suffixes to their standard form.
+var suffixes = map[string]string{
+	"remix":   "Remix",
+	"rmx":     "Remix",
+	"bootleg": "Bootleg",
+	"edit":    "Edit",
+	"rework":  "Rework",
+	"flip":    "Flip",
+	"vip":     "VIP",
+}
+
+var (
+	bracketedSuffixRe = regexp.MustCompile(`(?i)[(\[]\s*([^)\]]*?)\s*\b(remix|rmx|bootleg|edit|rework|flip|vip)\s*[)\]]`)
+	dashedSuffixRe    = regexp.MustCompile(`(?i)\s+-\s+([^-]*?)\s*\b(remix|rmx|bootleg|edit|rework|flip|vip)\s*$`)
+)
+
+// standardizeSuffixes converts version suffixes like "[X rmx]"
+// or "- X Remix" to "(X Remix)".
+func standardizeSuffixes(title string) string {
+	replace := func(re *regexp.Regexp, prefix string) {
+		title = re.ReplaceAllStringFunc(title, func(match string) string {
+			sm := re.FindStringSubmatch(match)
+			suffix := suffixes[strings.ToLower(sm[2])]
+			if sm[1] != "" {
+				suffix = sm[1] + " " + suffix
+			}
+			return prefix + "(" + suffix + ")"
+		})
+	}
+	replace(bracketedSuffixRe, "")
+	replace(dashedSuffixRe, " ")
+	return title
+}
+
+// titleCase capitalizes the first letter of each word in s.
+// Other letters are kept as is, so abbreviations like "DJ" are preserved,
+// and "feat." stays lower-cased.
+func titleCase(s string) string {
+	words := strings.Split(s, " ")
+	for i, word := range words {
+		if strings.EqualFold(word, "feat.") {
+			words[i] = "feat."
+			continue
+		}
+		for j, r := range word {
+			if unicode.IsLetter(r) {
+				words[i] = word[:j] + string(unicode.ToUpper(r)) + word[j+utf8.RuneLen(r):]
+				break
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package notify sends notifications about events, which need
+// attention of user, to desktop and webhook.
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/httpclient"
+)
+
+// Event is the notification. It's sent to webhook as JSON.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+
+	TrackID int    `json:"track_id,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Path    string `json:"path,omitempty"`
+
+	// Priority is PriorityHigh for events, which need attention
+	// as soon as possible. Otherwise it's blank.
+	Priority string `json:"priority,omitempty"`
+}
+
+// PriorityHigh is the priority of urgent events. Desktop notifications
+// with it are critical and have sound.
+const PriorityHigh = "high"
+
+// Send sends e to desktop, if notifyDesktop is enabled,
+// and to notifyWebhook, if it's set.
+func Send(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	var err error
+	if config.GetBool("notifyDesktop") {
+		if de := desktop(e.Title, e.Message, e.Priority == PriorityHigh); de != nil {
+			err = fmt.Errorf("couldn't show desktop notification: %v", de)
+		}
+	}
+	if url := config.Get("notifyWebhook"); url != "" {
+		if we := httpclient.PostJSON(url, e); we != nil && err == nil {
+			err = fmt.Errorf("couldn't send notification to webhook: %v", we)
+		}
+	}
+	return err
+}
+
+// DesktopProgram returns the program, which shows desktop notifications
+// on this system. It's blank, if they're not supported.
+func DesktopProgram() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "osascript"
+	case "linux":
+		return "notify-send"
+	}
+	return ""
+}
+
+func desktop(title, message string, urgent bool) error {
+	switch runtime.GOOS {
+	case "darwin":
+		script := "display notification " + strconv.Quote(message) + " with title " + strconv.Quote(title)
+		if urgent {
+			script += ` sound name "Basso"`
+		}
+		return exec.Command("osascript", "-e", script).Run()
+	case "linux":
+		if urgent {
+			return exec.Command("notify-send", "--urgency=critical", title, message).Run()
+		}
+		return exec.Command("notify-send", title, message).Run()
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %v", runtime.GOOS)
+	}
+}
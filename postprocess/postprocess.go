@@ -0,0 +1,141 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package postprocess lets users change metadata and paths of tracks
+// before they're written, e.g. with their own scripts.
+package postprocess
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/logs"
+	"github.com/bogem/nehm/util"
+)
+
+// Metadata is the metadata of track passed to post-processors.
+// Post-processors can change Artist, Title, Album and Path. Other fields
+// are only informational and changes of them are ignored.
+type Metadata struct {
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+	Album  string `json:"album"`
+	// Path is the path, where track will be written.
+	// Relative paths are relative to download folder.
+	Path string `json:"path"`
+
+	ID          int    `json:"id"`
+	Uploader    string `json:"uploader"`
+	Permalink   string `json:"uploader_permalink"`
+	RawTitle    string `json:"raw_title"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+	Duration    int    `json:"duration"`
+}
+
+// Processor changes metadata of track.
+type Processor interface {
+	Process(m Metadata) (Metadata, error)
+}
+
+// Executable is the post-processor, which runs external program.
+// Program gets metadata as JSON on stdin and should write
+// changed metadata as JSON to stdout.
+type Executable struct {
+	Path string
+	Args []string
+}
+
+func (e Executable) Process(m Metadata) (Metadata, error) {
+	input, err := json.Marshal(m)
+	if err != nil {
+		return m, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(e.Path, e.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return m, fmt.Errorf("%v failed: %v: %s", e.Path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out Metadata
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return m, fmt.Errorf("couldn't unmarshal output of %v: %v", e.Path, err)
+	}
+
+	// Only artist, title, album and path can be changed.
+	m.Artist, m.Title, m.Album, m.Path = out.Artist, out.Title, out.Album, out.Path
+	return m, nil
+}
+
+// FromConfig returns post-processors from postProcessors list in config.
+// Each item is a command line of external program. If editMetadata
+// is set, Prompt is the last post-processor.
+func FromConfig() []Processor {
+	var processors []Processor
+	for _, command := range config.GetStringSlice("postProcessors") {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+		processors = append(processors, Executable{
+			Path: util.SanitizePath(fields[0]),
+			Args: fields[1:],
+		})
+	}
+	if config.GetBool("editMetadata") {
+		processors = append(processors, Prompt{})
+	}
+	return processors
+}
+
+// Prompt is the post-processor, which lets user edit artist, title
+// and album of track in terminal.
+type Prompt struct{}
+
+// stdin is shared between prompts, so buffered input isn't lost.
+var stdin = bufio.NewReader(os.Stdin)
+
+func (Prompt) Process(m Metadata) (Metadata, error) {
+	logs.FEEDBACK.Println()
+	m.Artist = ask("Artist", m.Artist)
+	m.Title = ask("Title", m.Title)
+	m.Album = ask("Album", m.Album)
+	return m, nil
+}
+
+// ask asks user for the value of field. If user enters nothing,
+// value is kept.
+func ask(field, value string) string {
+	logs.FEEDBACK.Printf("  %v [%v]: ", field, value)
+	input, _ := stdin.ReadString('\n')
+	if input = strings.TrimSpace(input); input != "" {
+		return input
+	}
+	return value
+}
+
+// Run runs processors one by one on m. Processor gets the metadata
+// changed by previous one.
+func Run(processors []Processor, m Metadata) (Metadata, error) {
+	for _, p := range processors {
+		var err error
+		if m, err = p.Process(m); err != nil {
+			return m, err
+		}
+		if m.Artist == "" || m.Title == "" || m.Path == "" {
+			return m, fmt.Errorf("post-processor returned blank artist, title or path")
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package progress writes events of downloading as JSON lines to file
+// or named pipe, so other programs (e.g. menubar apps) can show
+// the progress of nehm without parsing its output.
+package progress
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bogem/nehm/logs"
+)
+
+// Types of events.
+const (
+	BatchStart = "batch_start"
+	TrackStart = "track_start"
+	// TrackProgress is emitted periodically while track is downloading.
+	TrackProgress = "track_progress"
+	TrackDone     = "track_done"
+	TrackError    = "track_error"
+	BatchDone     = "batch_done"
+)
+
+// Event is one event of downloading.
+type Event struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	ID    int       `json:"id,omitempty"`
+	Track string    `json:"track,omitempty"`
+
+	// Index is the number of track in batch starting from 1.
+	Index int `json:"index,omitempty"`
+	Total int `json:"total,omitempty"`
+
+	Bytes int64 `json:"bytes,omitempty"`
+	// Size is the size of track in bytes. It's set only in TrackProgress
+	// events and only if server sent the length of track.
+	Size           int64   `json:"size,omitempty"`
+	BytesPerSecond float64 `json:"bytes_per_second,omitempty"`
+
+	// Stages is the time in seconds spent in each stage of processing
+	// of track (download, artwork, tag, write, trim, import, upload).
+	Stages map[string]float64 `json:"stages,omitempty"`
+
+	Failed int    `json:"failed,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+var (
+	mu sync.Mutex
+	w  *os.File
+)
+
+// Open opens file at path for writing events. If path is a named pipe
+// without reader, events are not written.
+func Open(path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// O_NONBLOCK prevents blocking on opening named pipe without reader
+	// and writing to full pipe. It has no effect on regular files.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NONBLOCK, 0644)
+	if err != nil {
+		return err
+	}
+	w = f
+	return nil
+}
+
+// Emit writes e to opened file. If there is no opened file, it does nothing.
+func Emit(e Event) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if w == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		logs.INFO.Println("couldn't write progress event:", err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package site generates the static HTML gallery of downloaded tracks,
+// so the library can be browsed in web browser from any device.
+package site
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2"
+	"github.com/bogem/nehm/index"
+	"github.com/bogem/nehm/logs"
+)
+
+// artworksDir is the folder in site, where artworks of tracks are extracted.
+const artworksDir = "artworks"
+
+// item is the track in gallery.
+type item struct {
+	Name, Album, Genre, Label, Added string
+	// File, Artwork and Page are links to track file,
+	// its artwork and its page on SoundCloud.
+	File, Artwork, Page string
+	// Search is the lowercased text, by which track is found.
+	Search string
+}
+
+// Generate writes the gallery of tracks from entries to dir.
+// Links to track files are relative, so dir and dlFolder should be
+// served by the same web server. coverFile is the name of cover files
+// in folders of tracks, which are used, if tracks have no artworks
+// in their tags. It returns the count of tracks in gallery.
+func Generate(dir string, entries []index.Entry, coverFile string) (int, error) {
+	if err := os.MkdirAll(filepath.Join(dir, artworksDir), 0755); err != nil {
+		return 0, fmt.Errorf("couldn't create folder of site: %v", err)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	items := make([]item, 0, len(entries))
+	// The newest tracks go first.
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if _, err := os.Stat(e.Path); err != nil {
+			logs.INFO.Printf("skipping %q: file doesn't exist\n", e.Path)
+			continue
+		}
+
+		it := item{
+			Name:  e.Fullname(),
+			Album: e.Album,
+			Genre: e.Genre,
+			Label: e.Label,
+			Added: e.AddedAt.Format("2006-01-02"),
+			File:  relLink(absDir, e.Path),
+			Page:  "https://w.soundcloud.com/player/?url=" + url.QueryEscape("https://api.soundcloud.com/tracks/"+strconv.Itoa(e.ID)),
+		}
+		it.Search = strings.ToLower(strings.Join([]string{it.Name, it.Album, it.Genre, it.Label}, " "))
+
+		artwork, permalink := readTag(e.Path)
+		if permalink != "" {
+			it.Page = permalink
+		}
+		if len(artwork) > 0 {
+			name := strconv.Itoa(e.ID) + artworkExt(artwork)
+			if err := ioutil.WriteFile(filepath.Join(dir, artworksDir, name), artwork, 0644); err != nil {
+				return 0, fmt.Errorf("couldn't write artwork: %v", err)
+			}
+			it.Artwork = artworksDir + "/" + name
+		} else if coverFile != "" {
+			cover := filepath.Join(filepath.Dir(e.Path), coverFile)
+			if _, err := os.Stat(cover); err == nil {
+				it.Artwork = relLink(absDir, cover)
+			}
+		}
+		items = append(items, it)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return 0, fmt.Errorf("couldn't create index.html: %v", err)
+	}
+	defer f.Close()
+	if err := page.Execute(f, items); err != nil {
+		return 0, fmt.Errorf("couldn't write index.html: %v", err)
+	}
+	return len(items), nil
+}
+
+// readTag returns the front cover and the URL of SoundCloud page
+// from ID3 tag of track file at path. Other formats have no results.
+func readTag(path string) (artwork []byte, permalink string) {
+	if strings.ToLower(filepath.Ext(path)) != ".mp3" {
+		return nil, ""
+	}
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		logs.WARN.Printf("couldn't read tag of %q: %v\n", path, err)
+		return nil, ""
+	}
+	defer tag.Close()
+
+	for _, f := range tag.GetFrames(tag.CommonID("Attached picture")) {
+		if pf, ok := f.(id3v2.PictureFrame); ok && pf.PictureType == id3v2.PTFrontCover {
+			artwork = pf.Picture
+			break
+		}
+	}
+	if uf, ok := tag.GetLastFrame("WOAF").(id3v2.UnknownFrame); ok {
+		permalink = string(uf.Body)
+	}
+	return artwork, permalink
+}
+
+func artworkExt(artwork []byte) string {
+	if http.DetectContentType(artwork) == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// relLink returns the link to file at path from page in dir.
+// If path can't be made relative, it's the file URL.
+func relLink(dir, path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(dir, absPath)
+	if err != nil {
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath)}).String()
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel)}).String()
+}
+
+var page = template.Must(template.New("site").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>nehm library</title>
+<style>
+body { margin: 0; font-family: -apple-system, "Helvetica Neue", Arial, sans-serif; background: #f2f2f2; color: #333; }
+header { position: sticky; top: 0; padding: 12px 16px; background: #333; color: #fff; }
+header input { width: 100%; max-width: 480px; padding: 8px; font-size: 16px; border: 0; border-radius: 4px; }
+main { display: grid; grid-template-columns: repeat(auto-fill, minmax(180px, 1fr)); gap: 16px; padding: 16px; }
+.track { background: #fff; border-radius: 4px; overflow: hidden; box-shadow: 0 1px 2px rgba(0, 0, 0, .2); }
+.track .artwork { display: block; width: 100%; aspect-ratio: 1; object-fit: cover; background: #ff5500; }
+.track div { padding: 8px; font-size: 14px; word-wrap: break-word; }
+.track small { display: block; color: #999; }
+.track a { color: #ff5500; text-decoration: none; }
+</style>
+</head>
+<body>
+<header><input id="search" type="search" placeholder="Search {{len .}} tracks" autofocus></header>
+<main>
+{{range .}}<section class="track" data-search="{{.Search}}">
+<a href="{{.File}}">{{if .Artwork}}<img class="artwork" src="{{.Artwork}}" alt="" loading="lazy">{{else}}<span class="artwork"></span>{{end}}</a>
+<div>
+<a href="{{.File}}">{{.Name}}</a>
+<small>{{if .Album}}{{.Album}} · {{end}}{{if .Genre}}{{.Genre}} · {{end}}{{.Added}}{{if .Label}} · {{.Label}}{{end}}</small>
+<small><a href="{{.Page}}">SoundCloud</a></small>
+</div>
+</section>
+{{end}}</main>
+<script>
+document.getElementById("search").addEventListener("input", function () {
+	var words = this.value.toLowerCase().split(/\s+/);
+	var tracks = document.querySelectorAll(".track");
+	for (var i = 0; i < tracks.length; i++) {
+		var text = tracks[i].getAttribute("data-search");
+		var shown = words.every(function (w) { return text.indexOf(w) >= 0; });
+		tracks[i].style.display = shown ? "" : "none";
+	}
+});
+</script>
+</body>
+</html>
+`))
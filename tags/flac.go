@@ -0,0 +1,217 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tags
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Types of FLAC metadata blocks.
+const (
+	flacStreamInfo    = 0
+	flacPadding       = 1
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// flacVendor is the vendor string of written Vorbis comments.
+const flacVendor = "nehm"
+
+type flacBlock struct {
+	typ  byte
+	data []byte
+}
+
+// flacTagger writes Vorbis comments and picture to FLAC files.
+type flacTagger struct{}
+
+func (flacTagger) WriteTags(path string, m Metadata) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	r := bufio.NewReader(src)
+	blocks, err := readFLACBlocks(r)
+	if err != nil {
+		return err
+	}
+
+	var comments []string
+	kept := make([]flacBlock, 0, len(blocks)+2)
+	for _, b := range blocks {
+		switch {
+		case b.typ == flacVorbisComment:
+			comments = parseVorbisComments(b.data)
+		case b.typ == flacPicture && len(m.Artwork) > 0, b.typ == flacPadding:
+		default:
+			kept = append(kept, b)
+		}
+	}
+	kept = append(kept, flacBlock{flacVorbisComment, vorbisComments(comments, m)})
+	if len(m.Artwork) > 0 {
+		kept = append(kept, flacBlock{flacPicture, flacPictureBlock(m.Artwork)})
+	}
+
+	return replaceFile(path, func(w *os.File) error {
+		bw := bufio.NewWriter(w)
+		bw.WriteString("fLaC")
+		for i, b := range kept {
+			if len(b.data) >= 1<<24 {
+				return fmt.Errorf("metadata block is too big")
+			}
+			typ := b.typ
+			if i == len(kept)-1 {
+				typ |= 0x80
+			}
+			size := len(b.data)
+			bw.Write([]byte{typ, byte(size >> 16), byte(size >> 8), byte(size)})
+			bw.Write(b.data)
+		}
+		// The rest of file is audio frames.
+		if _, err := io.Copy(bw, r); err != nil {
+			return err
+		}
+		// src must be closed before it's replaced on Windows.
+		src.Close()
+		return bw.Flush()
+	})
+}
+
+// readFLACBlocks reads the marker and metadata blocks of FLAC stream.
+// STREAMINFO is always the first block.
+func readFLACBlocks(r io.Reader) ([]flacBlock, error) {
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(r, marker); err != nil || string(marker) != "fLaC" {
+		return nil, errors.New("file is not FLAC")
+	}
+
+	var blocks []flacBlock
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, fmt.Errorf("couldn't read metadata block: %v", err)
+		}
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("couldn't read metadata block: %v", err)
+		}
+		blocks = append(blocks, flacBlock{header[0] & 0x7F, data})
+		if header[0]&0x80 != 0 {
+			break
+		}
+	}
+	if blocks[0].typ != flacStreamInfo {
+		return nil, errors.New("there is no STREAMINFO block")
+	}
+	return blocks, nil
+}
+
+// parseVorbisComments returns comments ("KEY=value") of Vorbis comment block.
+// Invalid comments are skipped.
+func parseVorbisComments(data []byte) []string {
+	r := bytes.NewReader(data)
+	var vendorSize uint32
+	if binary.Read(r, binary.LittleEndian, &vendorSize) != nil || int64(vendorSize) > int64(r.Len()) {
+		return nil
+	}
+	r.Seek(int64(vendorSize), io.SeekCurrent)
+
+	var count uint32
+	if binary.Read(r, binary.LittleEndian, &count) != nil {
+		return nil
+	}
+	var comments []string
+	for i := uint32(0); i < count; i++ {
+		var size uint32
+		if binary.Read(r, binary.LittleEndian, &size) != nil || int64(size) > int64(r.Len()) {
+			break
+		}
+		comment := make([]byte, size)
+		r.Read(comment)
+		comments = append(comments, string(comment))
+	}
+	return comments
+}
+
+// vorbisComments returns Vorbis comment block with fields of m.
+// Existing comments with other keys are kept.
+func vorbisComments(existing []string, m Metadata) []byte {
+	return vorbisCommentsWith(existing, m, nil)
+}
+
+// vorbisCommentsWith is like vorbisComments, but it adds extra comments
+// and replaces existing comments with the same keys.
+func vorbisCommentsWith(existing []string, m Metadata, extra []string) []byte {
+	fields := []struct{ key, value string }{
+		{"TITLE", m.Title},
+		{"ARTIST", m.Artist},
+		{"ALBUM", m.Album},
+		{"GENRE", m.Genre},
+		{"DATE", m.Year},
+		{"COMMENT", m.Comment},
+		{"ENCODING", m.Encoding},
+	}
+	if m.TrackNumber > 0 {
+		fields = append(fields, struct{ key, value string }{"TRACKNUMBER", strconv.Itoa(m.TrackNumber)})
+	}
+
+	set := make(map[string]bool)
+	var comments []string
+	for _, f := range fields {
+		if f.value != "" {
+			set[f.key] = true
+			comments = append(comments, f.key+"="+f.value)
+		}
+	}
+	for _, c := range extra {
+		set[strings.ToUpper(strings.SplitN(c, "=", 2)[0])] = true
+		comments = append(comments, c)
+	}
+	for _, c := range existing {
+		key := strings.ToUpper(strings.SplitN(c, "=", 2)[0])
+		if !set[key] {
+			comments = append(comments, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(flacVendor)))
+	buf.WriteString(flacVendor)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(c)))
+		buf.WriteString(c)
+	}
+	return buf.Bytes()
+}
+
+// flacPictureBlock returns PICTURE block with front cover.
+func flacPictureBlock(artwork []byte) []byte {
+	mime := "image/jpeg"
+	if isPNG(artwork) {
+		mime = "image/png"
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(3)) // Front cover.
+	binary.Write(&buf, binary.BigEndian, uint32(len(mime)))
+	buf.WriteString(mime)
+	binary.Write(&buf, binary.BigEndian, uint32(0)) // Description.
+	// Width, height, color depth and count of colors are unknown.
+	binary.Write(&buf, binary.BigEndian, [4]uint32{})
+	binary.Write(&buf, binary.BigEndian, uint32(len(artwork)))
+	buf.Write(artwork)
+	return buf.Bytes()
+}
@@ -0,0 +1,324 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// atom is the box of MP4 file. Atoms in containers are parsed
+// to children, data of other atoms is kept as is.
+type atom struct {
+	typ  string
+	data []byte
+	// prefix is the version and flags of meta atom before its children.
+	prefix   []byte
+	children []*atom
+}
+
+// m4aContainers are the atoms, which are parsed to reach metadata
+// and chunk offsets.
+var m4aContainers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true,
+	"stbl": true, "udta": true, "meta": true, "ilst": true,
+}
+
+// Data types of values in ilst.
+const (
+	m4aImplicit = 0
+	m4aUTF8     = 1
+	m4aJPEG     = 13
+	m4aPNG      = 14
+)
+
+// m4aTagger writes iTunes metadata (moov/udta/meta/ilst) to M4A files.
+type m4aTagger struct{}
+
+func (m4aTagger) WriteTags(path string, m Metadata) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+
+	// Find moov and mdat in top level atoms.
+	moovOffset, moovSize, mdatOffset := int64(-1), int64(0), int64(-1)
+	for offset := int64(0); offset < size; {
+		atomSize, typ, _, err := readAtomHeader(src, offset, size)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case "moov":
+			moovOffset, moovSize = offset, atomSize
+		case "mdat":
+			if mdatOffset < 0 {
+				mdatOffset = offset
+			}
+		}
+		offset += atomSize
+	}
+	if moovOffset < 0 {
+		return errors.New("there is no moov atom")
+	}
+
+	moovData := make([]byte, moovSize)
+	if _, err := src.ReadAt(moovData, moovOffset); err != nil {
+		return fmt.Errorf("couldn't read moov atom: %v", err)
+	}
+	atoms, err := parseAtoms(moovData)
+	if err != nil {
+		return fmt.Errorf("couldn't parse moov atom: %v", err)
+	}
+	moov := atoms[0]
+	setM4AMetadata(moov, m)
+
+	newMoov := moov.bytes()
+	delta := int64(len(newMoov)) - moovSize
+	// Chunks in mdat after moov are moved by delta.
+	if delta != 0 && mdatOffset > moovOffset {
+		if err := shiftChunkOffsets(moov, delta); err != nil {
+			return err
+		}
+		newMoov = moov.bytes()
+	}
+
+	return replaceFile(path, func(w *os.File) error {
+		if _, err := io.Copy(w, io.NewSectionReader(src, 0, moovOffset)); err != nil {
+			return err
+		}
+		if _, err := w.Write(newMoov); err != nil {
+			return err
+		}
+		rest := moovOffset + moovSize
+		if _, err := io.Copy(w, io.NewSectionReader(src, rest, size-rest)); err != nil {
+			return err
+		}
+		// src must be closed before it's replaced on Windows.
+		return src.Close()
+	})
+}
+
+// readAtomHeader reads the header of atom at offset in file of size.
+func readAtomHeader(r io.ReaderAt, offset, size int64) (atomSize int64, typ string, headerSize int64, err error) {
+	header := make([]byte, 16)
+	n, _ := r.ReadAt(header, offset)
+	if n < 8 {
+		return 0, "", 0, fmt.Errorf("invalid atom at %v", offset)
+	}
+	atomSize, headerSize = int64(binary.BigEndian.Uint32(header)), 8
+	typ = string(header[4:8])
+	switch atomSize {
+	case 0:
+		atomSize = size - offset
+	case 1:
+		if n < 16 {
+			return 0, "", 0, fmt.Errorf("invalid atom at %v", offset)
+		}
+		atomSize, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+	}
+	if atomSize < headerSize || offset+atomSize > size {
+		return 0, "", 0, fmt.Errorf("invalid size of %q atom at %v", typ, offset)
+	}
+	return atomSize, typ, headerSize, nil
+}
+
+// parseAtoms parses atoms in b.
+func parseAtoms(b []byte) ([]*atom, error) {
+	var atoms []*atom
+	for offset := int64(0); offset < int64(len(b)); {
+		atomSize, typ, headerSize, err := readAtomHeader(bytes.NewReader(b), offset, int64(len(b)))
+		if err != nil {
+			return nil, err
+		}
+		a := &atom{typ: typ}
+		payload := b[offset+headerSize : offset+atomSize]
+		switch {
+		case typ == "meta":
+			// meta is usually full atom with version and flags,
+			// but it's omitted in files made by QuickTime.
+			if len(payload) >= 8 && string(payload[4:8]) == "hdlr" {
+				a.children, err = parseAtoms(payload)
+			} else if len(payload) >= 4 {
+				a.prefix = payload[:4]
+				a.children, err = parseAtoms(payload[4:])
+			}
+		case typ == "ilst":
+			// Items of ilst are kept as is.
+			a.children, err = parseRawAtoms(payload)
+		case m4aContainers[typ]:
+			a.children, err = parseAtoms(payload)
+		default:
+			a.data = payload
+		}
+		if err != nil {
+			return nil, err
+		}
+		atoms = append(atoms, a)
+		offset += atomSize
+	}
+	return atoms, nil
+}
+
+// parseRawAtoms parses atoms in b without parsing their children.
+func parseRawAtoms(b []byte) ([]*atom, error) {
+	var atoms []*atom
+	for offset := int64(0); offset < int64(len(b)); {
+		atomSize, typ, headerSize, err := readAtomHeader(bytes.NewReader(b), offset, int64(len(b)))
+		if err != nil {
+			return nil, err
+		}
+		atoms = append(atoms, &atom{typ: typ, data: b[offset+headerSize : offset+atomSize]})
+		offset += atomSize
+	}
+	return atoms, nil
+}
+
+// bytes returns a with header.
+func (a *atom) bytes() []byte {
+	body := a.data
+	if a.children != nil || m4aContainers[a.typ] {
+		var buf bytes.Buffer
+		buf.Write(a.prefix)
+		for _, c := range a.children {
+			buf.Write(c.bytes())
+		}
+		body = buf.Bytes()
+	}
+
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], a.typ)
+	return append(b, body...)
+}
+
+// child returns the first child of a with typ. If there is no such child
+// and create is true, it's added. Children of nil atom are nil.
+func (a *atom) child(typ string, create bool) *atom {
+	if a == nil {
+		return nil
+	}
+	for _, c := range a.children {
+		if c.typ == typ {
+			return c
+		}
+	}
+	if !create {
+		return nil
+	}
+	c := &atom{typ: typ}
+	a.children = append(a.children, c)
+	return c
+}
+
+// setM4AMetadata sets items of m to moov/udta/meta/ilst.
+// Existing items, which are not set in m, are kept.
+func setM4AMetadata(moov *atom, m Metadata) {
+	udta := moov.child("udta", true)
+	meta := udta.child("meta", false)
+	if meta == nil {
+		meta = &atom{typ: "meta", prefix: make([]byte, 4), children: []*atom{m4aHandler()}}
+		udta.children = append(udta.children, meta)
+	}
+	ilst := meta.child("ilst", true)
+
+	var items []*atom
+	text := func(typ, value string) {
+		if value != "" {
+			items = append(items, m4aItem(typ, m4aUTF8, []byte(value)))
+		}
+	}
+	text("\xa9nam", m.Title)
+	text("\xa9ART", m.Artist)
+	text("\xa9alb", m.Album)
+	text("\xa9gen", m.Genre)
+	text("\xa9day", m.Year)
+	text("\xa9cmt", m.Comment)
+	if m.TrackNumber > 0 && m.TrackNumber <= math.MaxUint16 {
+		trkn := make([]byte, 8)
+		binary.BigEndian.PutUint16(trkn[2:], uint16(m.TrackNumber))
+		items = append(items, m4aItem("trkn", m4aImplicit, trkn))
+	}
+	if len(m.Artwork) > 0 {
+		typ := uint32(m4aJPEG)
+		if isPNG(m.Artwork) {
+			typ = m4aPNG
+		}
+		items = append(items, m4aItem("covr", typ, m.Artwork))
+	}
+
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item.typ] = true
+	}
+	if set["\xa9gen"] {
+		// Numeric genre would conflict with text one.
+		set["gnre"] = true
+	}
+	for _, c := range ilst.children {
+		if !set[c.typ] {
+			items = append(items, c)
+		}
+	}
+	ilst.children = items
+}
+
+// m4aItem returns item of ilst with value of type dataType.
+func m4aItem(typ string, dataType uint32, value []byte) *atom {
+	data := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(data, dataType)
+	data = append(data, value...)
+	return &atom{typ: typ, children: []*atom{{typ: "data", data: data}}}
+}
+
+// m4aHandler returns hdlr atom of metadata in iTunes format.
+func m4aHandler() *atom {
+	data := make([]byte, 25)
+	copy(data[8:], "mdirappl")
+	return &atom{typ: "hdlr", data: data}
+}
+
+// shiftChunkOffsets adds delta to offsets of all chunks in stco
+// and co64 atoms of moov.
+func shiftChunkOffsets(moov *atom, delta int64) error {
+	for _, trak := range moov.children {
+		if trak.typ != "trak" {
+			continue
+		}
+		stbl := trak.child("mdia", false).child("minf", false).child("stbl", false)
+		if stbl == nil {
+			continue
+		}
+		for _, c := range stbl.children {
+			switch c.typ {
+			case "stco":
+				for i := 8; i+4 <= len(c.data); i += 4 {
+					offset := int64(binary.BigEndian.Uint32(c.data[i:])) + delta
+					if offset < 0 || offset > math.MaxUint32 {
+						return errors.New("chunk offset is out of range")
+					}
+					binary.BigEndian.PutUint32(c.data[i:], uint32(offset))
+				}
+			case "co64":
+				for i := 8; i+8 <= len(c.data); i += 8 {
+					offset := int64(binary.BigEndian.Uint64(c.data[i:])) + delta
+					binary.BigEndian.PutUint64(c.data[i:], uint64(offset))
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tags
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// oggContinued is the flag of Ogg page, which continues packet
+// of previous page.
+const oggContinued = 0x01
+
+// oggPage is the page of Ogg stream.
+type oggPage struct {
+	headerType byte
+	granule    uint64
+	serial     uint32
+	seq        uint32
+	segments   []byte
+	data       []byte
+}
+
+// opusTagger writes Vorbis comments and picture to OpusTags packet
+// of Opus files.
+type opusTagger struct{}
+
+func (opusTagger) WriteTags(path string, m Metadata) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	r := bufio.NewReader(src)
+	head, err := readOggPage(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(head.data, []byte("OpusHead")) {
+		return errors.New("file is not Opus")
+	}
+
+	// OpusTags packet can span several pages. Audio starts on a new page.
+	var packet []byte
+	var tagsPages uint32
+	for done := false; !done; {
+		p, err := readOggPage(r)
+		if err != nil {
+			return fmt.Errorf("couldn't read OpusTags: %v", err)
+		}
+		tagsPages++
+		packet = append(packet, p.data...)
+		done = len(p.segments) > 0 && p.segments[len(p.segments)-1] < 255
+	}
+	if !bytes.HasPrefix(packet, []byte("OpusTags")) {
+		return errors.New("there is no OpusTags packet")
+	}
+
+	var extra []string
+	if len(m.Artwork) > 0 {
+		extra = append(extra, "METADATA_BLOCK_PICTURE="+base64.StdEncoding.EncodeToString(flacPictureBlock(m.Artwork)))
+	}
+	var existing []string
+	for _, c := range parseVorbisComments(packet[8:]) {
+		if len(m.Artwork) == 0 || !strings.HasPrefix(strings.ToUpper(c), "METADATA_BLOCK_PICTURE=") {
+			existing = append(existing, c)
+		}
+	}
+	packet = append([]byte("OpusTags"), vorbisCommentsWith(existing, m, extra)...)
+	pages := oggPackets(head.serial, 1, packet)
+
+	return replaceFile(path, func(w *os.File) error {
+		bw := bufio.NewWriter(w)
+		writeOggPage(bw, head)
+		for _, p := range pages {
+			writeOggPage(bw, p)
+		}
+		// Sequence numbers of audio pages are shifted,
+		// if the count of OpusTags pages is changed.
+		delta := uint32(len(pages)) - tagsPages
+		for {
+			p, err := readOggPage(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			p.seq += delta
+			writeOggPage(bw, p)
+		}
+		// src must be closed before it's replaced on Windows.
+		src.Close()
+		return bw.Flush()
+	})
+}
+
+// readOggPage reads the next page of Ogg stream.
+// It returns io.EOF, if there are no more pages.
+func readOggPage(r io.Reader) (oggPage, error) {
+	header := make([]byte, 27)
+	if _, err := io.ReadFull(r, header); err == io.EOF {
+		return oggPage{}, io.EOF
+	} else if err != nil {
+		return oggPage{}, fmt.Errorf("couldn't read Ogg page: %v", err)
+	}
+	if string(header[:4]) != "OggS" {
+		return oggPage{}, errors.New("invalid Ogg page")
+	}
+
+	p := oggPage{
+		headerType: header[5],
+		granule:    binary.LittleEndian.Uint64(header[6:14]),
+		serial:     binary.LittleEndian.Uint32(header[14:18]),
+		seq:        binary.LittleEndian.Uint32(header[18:22]),
+		segments:   make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, p.segments); err != nil {
+		return oggPage{}, fmt.Errorf("couldn't read Ogg page: %v", err)
+	}
+	var size int
+	for _, s := range p.segments {
+		size += int(s)
+	}
+	p.data = make([]byte, size)
+	if _, err := io.ReadFull(r, p.data); err != nil {
+		return oggPage{}, fmt.Errorf("couldn't read Ogg page: %v", err)
+	}
+	return p, nil
+}
+
+// oggPackets splits header packet into pages starting with sequence
+// number seq.
+func oggPackets(serial, seq uint32, packet []byte) []oggPage {
+	var segments []byte
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			segments = append(segments, byte(n))
+			break
+		}
+		segments = append(segments, 255)
+	}
+
+	var pages []oggPage
+	for len(segments) > 0 {
+		n := len(segments)
+		if n > 255 {
+			n = 255
+		}
+		p := oggPage{serial: serial, seq: seq, segments: segments[:n]}
+		if len(pages) > 0 {
+			p.headerType = oggContinued
+		}
+		var size int
+		for _, s := range p.segments {
+			size += int(s)
+		}
+		p.data, packet = packet[:size], packet[size:]
+		pages = append(pages, p)
+		segments = segments[n:]
+		seq++
+	}
+	return pages
+}
+
+// writeOggPage writes p to w with recalculated checksum.
+func writeOggPage(w io.Writer, p oggPage) {
+	page := make([]byte, 27, 27+len(p.segments)+len(p.data))
+	copy(page, "OggS")
+	page[5] = p.headerType
+	binary.LittleEndian.PutUint64(page[6:14], p.granule)
+	binary.LittleEndian.PutUint32(page[14:18], p.serial)
+	binary.LittleEndian.PutUint32(page[18:22], p.seq)
+	page[26] = byte(len(p.segments))
+	page = append(page, p.segments...)
+	page = append(page, p.data...)
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC(page))
+	w.Write(page)
+}
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// oggCRC returns the checksum of Ogg page. Field of checksum
+// in page must be zero.
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package tags writes metadata to audio files, which are not MP3:
+// Vorbis comments to FLAC and Opus and iTunes atoms to M4A. ID3 tags of MP3
+// are written by downloader, while track is downloading.
+package tags
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/bogem/nehm/format"
+)
+
+// Metadata is the metadata of track. Blank fields are not written.
+type Metadata struct {
+	Artist, Title, Album, Genre, Year, Comment string
+	TrackNumber                                int
+	// Artwork is the cover of track in JPEG or PNG.
+	Artwork []byte
+	// Encoding is the preset of SoundCloud transcoding of track.
+	Encoding string
+}
+
+// Tagger writes metadata to audio file of some format.
+// Metadata, which is already in file and isn't set in m, is kept.
+type Tagger interface {
+	WriteTags(path string, m Metadata) error
+}
+
+// For returns the tagger of files with format f.
+// If format is not supported, it returns nil.
+func For(f format.Format) Tagger {
+	switch f {
+	case format.FLAC:
+		return flacTagger{}
+	case format.M4A:
+		return m4aTagger{}
+	case format.Opus:
+		return opusTagger{}
+	}
+	return nil
+}
+
+// isPNG reports whether image is PNG. Otherwise it's JPEG.
+func isPNG(image []byte) bool {
+	return bytes.HasPrefix(image, []byte("\x89PNG\r\n\x1a\n"))
+}
+
+// replaceFile writes file at path with write. File is written
+// to temporary file first, so it's not broken, if write fails.
+func replaceFile(path string, write func(*os.File) error) error {
+	tmpPath := path + ".tags-tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
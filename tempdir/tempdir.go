@@ -0,0 +1,259 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package tempdir manages the folder for intermediate files of nehm
+// (e.g. scripts and transcoded tracks). The folder is created in tmpDir
+// from config or, if it's not set, in the system temporary folder.
+// It's removed with Cleanup on exit. Partial downloads are kept in
+// tmpDir too, but between runs, so they can be resumed.
+package tempdir
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/filelock"
+	"github.com/bogem/nehm/util"
+)
+
+// Prefix is the prefix of folders created by nehm in tmpDir.
+const Prefix = "nehm-tmp-"
+
+var (
+	mu  sync.Mutex
+	dir string
+	// release releases the lock file in dir.
+	release = func() {}
+)
+
+// lockFile is the file in temporary folder, which is locked,
+// while the folder is used.
+const lockFile = ".lock"
+
+// Base returns the folder, where temporary folders are created.
+func Base() string {
+	if base := config.Get("tmpDir"); base != "" {
+		return util.SanitizePath(base)
+	}
+	return os.TempDir()
+}
+
+// Dir returns the temporary folder of this run of nehm.
+// It's created on the first call.
+func Dir() (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if dir != "" {
+		if _, err := os.Stat(dir); err == nil {
+			return dir, nil
+		}
+		// Folder was removed by somebody else, so it's created again.
+		release()
+	}
+	base := Base()
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return "", err
+	}
+	d, err := ioutil.TempDir(base, Prefix)
+	if err != nil {
+		return "", err
+	}
+	// Lock file is held while nehm is running, so other runs
+	// don't remove the folder as stale (see CleanStale).
+	if release, err = filelock.Hold(filepath.Join(d, lockFile)); err != nil {
+		os.RemoveAll(d)
+		return "", fmt.Errorf("couldn't lock temporary folder: %v", err)
+	}
+	dir = d
+	return dir, nil
+}
+
+// TempFile creates new temporary file with prefix in Dir.
+func TempFile(prefix string) (*os.File, error) {
+	d, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(d, prefix)
+}
+
+// TempDir creates new temporary folder with prefix in Dir.
+func TempDir(prefix string) (string, error) {
+	d, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(d, prefix)
+}
+
+// Cleanup removes Dir with all files in it.
+func Cleanup() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if dir != "" {
+		release()
+		release = func() {}
+		os.RemoveAll(dir)
+		dir = ""
+	}
+}
+
+// inUse returns true, if temporary folder at path is used by running
+// nehm process, i.e. its lock file is held.
+func inUse(path string) bool {
+	lockPath := filepath.Join(path, lockFile)
+	if _, err := os.Stat(lockPath); err != nil {
+		// Folders of older versions have no lock files.
+		return false
+	}
+	r, err := filelock.Hold(lockPath)
+	if err != nil {
+		return filelock.IsBusy(err)
+	}
+	r()
+	return false
+}
+
+// Suffixes of intermediate files. Partial downloads are in PartsDir,
+// older versions wrote them next to tracks. Temporary files of tracks
+// are written next to them, so they can be renamed to tracks.
+// util.LimitFilename leaves room for TmpSuffix in names of tracks.
+const (
+	PartSuffix = ".part"
+	TmpSuffix  = ".nehm-tmp"
+)
+
+// partsFolder is the folder in Base with partial downloads.
+const partsFolder = "nehm-parts"
+
+// PartsDir returns the folder of partial downloads. Unlike Dir,
+// it's not removed on exit, so interrupted downloads can be resumed.
+func PartsDir() string {
+	return filepath.Join(Base(), partsFolder)
+}
+
+// PartPath returns the path of partial download with name in PartsDir.
+// The folder is created, if it doesn't exist.
+func PartPath(name string) (string, error) {
+	d := PartsDir()
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(d, name+PartSuffix), nil
+}
+
+// DefaultStaleAfter is the age, after which intermediate files are
+// considered as left from crashed runs, if cleanAfter isn't set in config.
+const DefaultStaleAfter = 24 * time.Hour
+
+// StaleAfter returns the age of stale files from cleanAfter in config.
+func StaleAfter() (time.Duration, error) {
+	value := config.Get("cleanAfter")
+	if value == "" {
+		return DefaultStaleAfter, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid cleanAfter %q: should be duration (e.g. 24h)", value)
+	}
+	return d, nil
+}
+
+// CleanStale removes temporary folders of nehm and partial downloads
+// in Base and .part and .nehm-tmp files in dlFolder, which weren't
+// modified for olderThan.
+// Folders of running nehm processes (e.g. watch daemon) are kept.
+// It returns removed paths and the count of reclaimed bytes.
+func CleanStale(dlFolder string, olderThan time.Duration) ([]string, int64, error) {
+	threshold := time.Now().Add(-olderThan)
+	mu.Lock()
+	current := dir
+	mu.Unlock()
+
+	var removed []string
+	var reclaimed int64
+
+	// Temporary folders of previous runs.
+	base := Base()
+	infos, err := ioutil.ReadDir(base)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+	for _, fi := range infos {
+		path := filepath.Join(base, fi.Name())
+		if !fi.IsDir() || !strings.HasPrefix(fi.Name(), Prefix) || path == current || fi.ModTime().After(threshold) {
+			continue
+		}
+		if inUse(path) {
+			continue
+		}
+		size := dirSize(path)
+		if err := os.RemoveAll(path); err != nil {
+			return removed, reclaimed, err
+		}
+		removed = append(removed, path)
+		reclaimed += size
+	}
+
+	// Partial downloads in Base and intermediate files in dlFolder.
+	roots := []string{PartsDir()}
+	if dlFolder != "" {
+		roots = append(roots, dlFolder)
+	}
+	for _, root := range roots {
+		files, size, err := removeStaleFiles(root, threshold)
+		removed = append(removed, files...)
+		reclaimed += size
+		if err != nil {
+			return removed, reclaimed, err
+		}
+	}
+	return removed, reclaimed, nil
+}
+
+// removeStaleFiles removes .part and .nehm-tmp files in root, which
+// weren't modified after threshold. It returns removed paths and
+// the count of reclaimed bytes.
+func removeStaleFiles(root string, threshold time.Time) ([]string, int64, error) {
+	var removed []string
+	var reclaimed int64
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// Skip unreadable folders.
+			return nil
+		}
+		if fi.IsDir() || fi.ModTime().After(threshold) {
+			return nil
+		}
+		if !strings.HasSuffix(fi.Name(), PartSuffix) && !strings.HasSuffix(fi.Name(), TmpSuffix) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed = append(removed, path)
+		reclaimed += fi.Size()
+		return nil
+	})
+	return removed, reclaimed, err
+}
+
+func dirSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tempdir
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bogem/nehm/config"
+)
+
+func TestCleanStaleKeepsFoldersInUse(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("locks of other processes are simulated with flock")
+	}
+	base, err := ioutil.TempDir("", "nehm-tempdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	config.Set("tmpDir", base)
+	defer config.Set("tmpDir", "")
+
+	used, err := Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := ioutil.TempDir(base, Prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(used, old, old)
+	os.Chtimes(stale, old, old)
+
+	// Folder of this process looks like folder of other process,
+	// if it's not current.
+	mu.Lock()
+	dir = ""
+	mu.Unlock()
+	removed, _, err := CleanStale("", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != stale {
+		t.Errorf("removed %v, want only %v", removed, stale)
+	}
+	if _, err := os.Stat(used); err != nil {
+		t.Errorf("folder in use is removed: %v", err)
+	}
+
+	mu.Lock()
+	dir = used
+	mu.Unlock()
+	Cleanup()
+}
+
+func TestCleanStaleRemovesPartialDownloads(t *testing.T) {
+	base, err := ioutil.TempDir("", "nehm-tempdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	config.Set("tmpDir", base)
+	defer config.Set("tmpDir", "")
+
+	stale, err := PartPath("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := PartPath("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{stale, fresh} {
+		if err := ioutil.WriteFile(path, []byte("part"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(stale, old, old)
+
+	removed, _, err := CleanStale("", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != stale {
+		t.Errorf("removed %v, want only %v", removed, stale)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("fresh partial download is removed: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package track
+
+import "regexp"
+
+// ArtworkSizes are sizes of artworks on SoundCloud from the largest
+// to the smallest one, which are used for tags: original (as uploaded),
+// t500x500, crop (400x400), t300x300 and large (100x100).
+var ArtworkSizes = []string{"original", "t500x500", "crop", "t300x300", "large"}
+
+// ArtworkSize is the preferred size of artworks (artworkSize in config).
+var ArtworkSize = "t500x500"
+
+// ValidArtworkSize reports whether size is one of ArtworkSizes.
+func ValidArtworkSize(size string) bool {
+	for _, s := range ArtworkSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// artworkSizeRe matches the size suffix of URLs of artworks and avatars,
+// e.g. "-large.jpg".
+var artworkSizeRe = regexp.MustCompile(`-(original|t500x500|crop|t300x300|large|t67x67|badge|small|tiny|mini)(\.\w+)$`)
+
+// ArtworkURLs returns URLs of artwork of t in ArtworkSize and smaller
+// sizes down to large, so next one can be tried, if some size isn't
+// available. If t has no artwork, it returns nil.
+func (t Track) ArtworkURLs() []string {
+	return sizedURLs(t.JArtworkURL)
+}
+
+// AvatarURLs returns URLs of avatar of uploader like ArtworkURLs.
+func (t Track) AvatarURLs() []string {
+	return sizedURLs(t.JAuthor.AvatarURL)
+}
+
+func sizedURLs(rawurl string) []string {
+	if rawurl == "" {
+		return nil
+	}
+	if !artworkSizeRe.MatchString(rawurl) {
+		return []string{rawurl}
+	}
+
+	var urls []string
+	var preferred bool
+	for _, size := range ArtworkSizes {
+		if size == ArtworkSize {
+			preferred = true
+		}
+		if preferred {
+			urls = append(urls, artworkSizeRe.ReplaceAllString(rawurl, "-"+size+"$2"))
+		}
+	}
+	return urls
+}
@@ -5,10 +5,15 @@
 package track
 
 import (
+	"bytes"
+	"hash/fnv"
 	"net/url"
-	"runtime"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
+	"github.com/bogem/nehm/logs"
 	"github.com/bogem/nehm/util"
 )
 
@@ -19,14 +24,27 @@ type Track struct {
 	title  string
 
 	// Fields needed for JSON unmarshalling.
-	JArtworkURL string `json:"artwork_url"`
-	JCreatedAt  string `json:"created_at"`
-	JDuration   int    `json:"duration"`
-	JID         int    `json:"id"`
-	JTitle      string `json:"title"`
-	JURL        string `json:"stream_url"`
-	JAuthor     struct {
+	JArtworkURL   string `json:"artwork_url"`
+	JCreatedAt    string `json:"created_at"`
+	JDescription  string `json:"description"`
+	JDownloadURL  string `json:"download_url"`
+	JDownloadable bool   `json:"downloadable"`
+	JDuration     int    `json:"duration"`
+	JGenre        string `json:"genre"`
+	JID           int    `json:"id"`
+	JOriginalFmt  string `json:"original_format"`
+	JPermalinkURL string `json:"permalink_url"`
+	JPlayback     int    `json:"playback_count"`
+	JPurchaseURL  string `json:"purchase_url"`
+	JTitle        string `json:"title"`
+	JURL          string `json:"stream_url"`
+	JWaveformURL  string `json:"waveform_url"`
+	JMedia        struct {
+		Transcodings []Transcoding `json:"transcodings"`
+	} `json:"media"`
+	JAuthor struct {
 		AvatarURL string `json:"avatar_url"`
+		Permalink string `json:"permalink"`
 		Username  string `json:"username"`
 	} `json:"user"`
 }
@@ -37,49 +55,146 @@ func (t *Track) Artist() string {
 }
 
 func (t Track) ArtworkURL() string {
-	artworkURL := t.JArtworkURL
-	if artworkURL == "" {
-		artworkURL = t.JAuthor.AvatarURL
+	if urls := t.ArtworkURLs(); len(urls) > 0 {
+		return urls[0]
 	}
-	return strings.Replace(artworkURL, "large", "t500x500", 1)
+	return ""
+}
+
+// createdAtLayout is the layout of created_at field in SoundCloud API.
+const createdAtLayout = "2006/01/02 15:04:05 -0700"
+
+// CreatedAt returns the time, when track was uploaded.
+// If time can't be parsed, it returns zero time.
+func (t Track) CreatedAt() time.Time {
+	createdAt, _ := time.Parse(createdAtLayout, t.JCreatedAt)
+	return createdAt
+}
+
+// Description returns the description of track set by uploader.
+func (t Track) Description() string {
+	return t.JDescription
 }
 
 func (t Track) Duration() string {
 	return util.DurationString(util.ParseDuration(t.JDuration))
 }
 
-func (t Track) Filename() string {
-	// Replace all filesystem non-friendly runes with the underscore.
-	var toReplace string
-	if runtime.GOOS == "windows" {
-		toReplace = "<>:\"\\/|?*" // https://msdn.microsoft.com/en-us/library/windows/desktop/aa365247(v=vs.85).aspx
-	} else {
-		toReplace = ":/\\"
+// HasArtwork reports whether uploader set artwork of track.
+// If not, ArtworkURL returns the avatar of uploader.
+func (t Track) HasArtwork() bool {
+	return t.JArtworkURL != ""
+}
+
+func (t Track) AvatarURL() string {
+	if urls := t.AvatarURLs(); len(urls) > 0 {
+		return urls[0]
 	}
+	return ""
+}
+
+// DescriptionHash returns the hash of description of t, which is
+// stored in index to detect changes of description.
+func (t Track) DescriptionHash() string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.TrimSpace(t.JDescription)))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
 
-	replaceRunes := func(r rune) rune {
-		if strings.ContainsRune(toReplace, r) {
-			return '_'
+// Filename returns the name of track file. If FilenameTemplate is set,
+// it's used to make the name. Otherwise name is "Artist — Title.mp3".
+func (t Track) Filename() string {
+	name := t.Fullname()
+	if filenameTemplate != nil {
+		if n, err := t.executeFilenameTemplate(); err == nil {
+			name = n
+		} else {
+			logs.WARN.Printf("couldn't make filename of %q with fileNameTemplate: %v\n", t.Fullname(), err)
 		}
-		return r
 	}
+	return util.LimitFilename(util.SanitizeFilename(name), ".mp3")
+}
+
+// filenameTemplate is the template of filenames. If it's nil,
+// default filenames are used.
+var filenameTemplate *template.Template
 
-	return strings.Map(replaceRunes, t.Fullname()) + ".mp3"
+// SetFilenameTemplate sets the template of filenames (fileNameTemplate
+// in config), e.g. "{{.Artist}} - {{.Title}} [{{.Year}}]". Fields are
+// Artist, Title, Year, Genre, Uploader and ID. ".mp3" is added,
+// if template doesn't end with it.
+func SetFilenameTemplate(text string) error {
+	tmpl, err := template.New("fileNameTemplate").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+	filenameTemplate = tmpl
+
+	// Unknown fields are only found on execution.
+	if _, err := (Track{}).executeFilenameTemplate(); err != nil {
+		filenameTemplate = nil
+		return err
+	}
+	return nil
+}
+
+// TemplateData is the data of track used in templates of filenames
+// and folders. Album is set only in templates of folders.
+type TemplateData struct {
+	Artist, Title, Year, Genre, Uploader, Album string
+	ID                                          int
+}
+
+// TemplateData returns the data of t for templates.
+func (t Track) TemplateData() TemplateData {
+	return TemplateData{
+		Artist:   t.Artist(),
+		Title:    t.Title(),
+		Year:     t.Year(),
+		Genre:    t.Genre(),
+		Uploader: t.Uploader(),
+		ID:       t.ID(),
+	}
+}
+
+func (t Track) executeFilenameTemplate() (string, error) {
+	var buf bytes.Buffer
+	if err := filenameTemplate.Execute(&buf, t.TemplateData()); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(buf.String())
+	if strings.HasSuffix(strings.ToLower(name), ".mp3") {
+		name = name[:len(name)-len(".mp3")]
+	}
+	return name, nil
 }
 
 func (t Track) Fullname() string {
 	return t.Artist() + " — " + t.Title()
 }
 
+// Genre returns the genre of track set by uploader.
+func (t Track) Genre() string {
+	return strings.TrimSpace(t.JGenre)
+}
+
 func (t Track) ID() int {
 	return t.JID
 }
 
+// Normalize is used to normalize artist and title of tracks.
+// If it's nil, they are used as is.
+var Normalize func(artist, title string) (string, string)
+
+// UploaderAliases maps permalinks or usernames of uploaders to the names,
+// which are used instead of their usernames.
+var UploaderAliases map[string]string
+
 // name splits track's title to artist and title if there is one of separators
 // and sets to t.artist and to t.title respectively.
 // If t.artist or t.title are not blank, it will do nothing.
-// If there is no separator in title, it willt use t.JAuthor.Username and
-// t.JTitle.
+// If there is no separator in title, it willt use t.Uploader() and
+// t.JTitle. After that artist and title are normalized with Normalize.
 //
 // E.g. if track has title "Michael Jackson - Thriller", then it will use
 // "Michael Jackson" as artist and "Thriller" as title.
@@ -88,18 +203,28 @@ func (t *Track) setArtistAndTitle() {
 		return
 	}
 
+	t.artist, t.title = splitTitle(t.JTitle, t.Uploader())
+	if Normalize != nil {
+		t.artist, t.title = Normalize(t.artist, t.title)
+	}
+}
+
+func splitTitle(title, username string) (artist, name string) {
 	separators := [...]string{" - ", " ~ ", " – "}
 	for _, sep := range separators {
-		if strings.Contains(t.JTitle, sep) {
-			splitted := strings.SplitN(t.JTitle, sep, 2)
-			t.artist = strings.TrimSpace(splitted[0])
-			t.title = strings.TrimSpace(splitted[1])
-			return
+		if strings.Contains(title, sep) {
+			splitted := strings.SplitN(title, sep, 2)
+			return strings.TrimSpace(splitted[0]), strings.TrimSpace(splitted[1])
 		}
 	}
 
-	t.artist = strings.TrimSpace(t.JAuthor.Username)
-	t.title = strings.TrimSpace(t.JTitle)
+	return strings.TrimSpace(username), strings.TrimSpace(title)
+}
+
+// SetName sets artist and title of track instead of ones parsed from
+// SoundCloud title, e.g. after post-processing.
+func (t *Track) SetName(artist, title string) {
+	t.artist, t.title = artist, title
 }
 
 func (t *Track) Title() string {
@@ -108,7 +233,15 @@ func (t *Track) Title() string {
 }
 
 func (t Track) URL() string {
-	u, err := url.Parse(t.JURL)
+	if t.JURL == "" {
+		return ""
+	}
+	return addClientID(t.JURL)
+}
+
+// addClientID returns rawurl with client_id of nehm.
+func addClientID(rawurl string) string {
+	u, err := url.Parse(rawurl)
 	if err != nil {
 		return ""
 	}
@@ -120,6 +253,62 @@ func (t Track) URL() string {
 	return u.String()
 }
 
+// PurchaseURL returns the link, where track can be bought,
+// set by uploader.
+func (t Track) PurchaseURL() string {
+	return t.JPurchaseURL
+}
+
+// FreeDownloadURL returns the link to original file of track,
+// if uploader allowed to download it. Otherwise it returns blank string.
+func (t Track) FreeDownloadURL() string {
+	if !t.JDownloadable || t.JDownloadURL == "" {
+		return ""
+	}
+	return addClientID(t.JDownloadURL)
+}
+
+// OriginalFormat returns the format of original file of track,
+// e.g. "wav" or "mp3".
+func (t Track) OriginalFormat() string {
+	return t.JOriginalFmt
+}
+
+// PlaybackCount returns how many times track was played on SoundCloud.
+func (t Track) PlaybackCount() int {
+	return t.JPlayback
+}
+
+// Uploader returns the username of user, who uploaded the track,
+// or its alias from UploaderAliases.
+func (t Track) Uploader() string {
+	if alias, exists := UploaderAliases[t.JAuthor.Permalink]; exists {
+		return alias
+	}
+	if alias, exists := UploaderAliases[t.JAuthor.Username]; exists {
+		return alias
+	}
+	return strings.TrimSpace(t.JAuthor.Username)
+}
+
+// PermalinkURL returns the URL of track page on SoundCloud.
+func (t Track) PermalinkURL() string {
+	return t.JPermalinkURL
+}
+
+// UploaderPermalink returns the permalink of user, who uploaded the track.
+func (t Track) UploaderPermalink() string {
+	return t.JAuthor.Permalink
+}
+
+// WaveformURL returns the URL of PNG image with waveform of track.
+func (t Track) WaveformURL() string {
+	return t.JWaveformURL
+}
+
 func (t Track) Year() string {
+	if len(t.JCreatedAt) < 4 {
+		return ""
+	}
 	return t.JCreatedAt[0:4]
 }
@@ -0,0 +1,88 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package track
+
+import "strings"
+
+// Qualities of streams, which can be selected with quality in config.
+const (
+	QualityLow      = "low"
+	QualityStandard = "standard"
+	QualityHigh     = "high"
+)
+
+// Transcoding is the encoding of track, in which SoundCloud streams it.
+type Transcoding struct {
+	// URL is the URL of API, which returns the URL of stream.
+	URL     string `json:"url"`
+	Preset  string `json:"preset"`
+	Quality string `json:"quality"`
+	Snipped bool   `json:"snipped"`
+	Format  struct {
+		Protocol string `json:"protocol"`
+		MimeType string `json:"mime_type"`
+	} `json:"format"`
+}
+
+// Progressive reports whether stream is one file, not HLS playlist.
+func (tr Transcoding) Progressive() bool {
+	return tr.Format.Protocol == "progressive"
+}
+
+// Bitrate returns the approximate bitrate of transcoding in kbit/s.
+func (tr Transcoding) Bitrate() int {
+	switch {
+	case strings.Contains(tr.Format.MimeType, "opus"):
+		return 64
+	case tr.Quality == "hq":
+		return 256
+	}
+	return 128
+}
+
+// Transcodings returns the encodings, in which track is streamed.
+func (t Track) Transcodings() []Transcoding {
+	return t.JMedia.Transcodings
+}
+
+// Transcoding returns the transcoding of t, which fits quality best:
+// Opus for low, MP3 for standard and HQ one for high, if uploader
+// has Go+. Progressive transcodings are preferred to HLS ones.
+// Previews (snipped transcodings) are never returned.
+func (t Track) Transcoding(quality string) (Transcoding, bool) {
+	var candidates, hls []Transcoding
+	for _, tr := range t.Transcodings() {
+		switch {
+		case tr.Snipped:
+		case tr.Progressive():
+			candidates = append(candidates, tr)
+		default:
+			hls = append(hls, tr)
+		}
+	}
+	candidates = append(candidates, hls...)
+
+	prefer := func(match func(Transcoding) bool) (Transcoding, bool) {
+		for _, tr := range candidates {
+			if match(tr) {
+				return tr, true
+			}
+		}
+		return Transcoding{}, false
+	}
+	isMP3 := func(tr Transcoding) bool { return tr.Format.MimeType == "audio/mpeg" }
+
+	switch quality {
+	case QualityLow:
+		if tr, ok := prefer(func(tr Transcoding) bool { return strings.Contains(tr.Format.MimeType, "opus") }); ok {
+			return tr, true
+		}
+	case QualityHigh:
+		if tr, ok := prefer(func(tr Transcoding) bool { return tr.Quality == "hq" }); ok {
+			return tr, true
+		}
+	}
+	return prefer(isMP3)
+}
@@ -5,10 +5,15 @@
 package util
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -52,3 +57,140 @@ func SanitizePath(path string) string {
 	}
 	return filepath.Clean(path)
 }
+
+// windowsReservedNames are the names of devices, which can't be used
+// as filenames on Windows even with extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename replaces all filesystem non-friendly runes
+// in name with the underscore. Control characters are removed.
+// Names, which refer to the current or parent folder ("." and ".."),
+// and empty names are replaced with the underscore, so result
+// can be safely joined with folder path. On Windows trailing dots
+// and spaces are removed and reserved names get the underscore prefix.
+func SanitizeFilename(name string) string {
+	return sanitizeFilename(name, runtime.GOOS == "windows")
+}
+
+func sanitizeFilename(name string, windows bool) string {
+	var toReplace string
+	if windows {
+		toReplace = "<>:\"\\/|?*" // https://msdn.microsoft.com/en-us/library/windows/desktop/aa365247(v=vs.85).aspx
+	} else {
+		toReplace = ":/\\"
+	}
+
+	replaceRunes := func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		if strings.ContainsRune(toReplace, r) {
+			return '_'
+		}
+		return r
+	}
+
+	name = strings.Map(replaceRunes, name)
+	if strings.Trim(name, ". ") == "" {
+		return "_"
+	}
+	if windows {
+		name = strings.TrimRight(name, ". ")
+		base := strings.ToUpper(strings.SplitN(name, ".", 2)[0])
+		if windowsReservedNames[strings.TrimSpace(base)] {
+			name = "_" + name
+		}
+	}
+	return name
+}
+
+// IsWithin reports whether path is located inside of dir.
+func IsWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// maxFilenameLength is the maximum length of filename in bytes
+// on the most filesystems (ext4, APFS, HFS+).
+const maxFilenameLength = 255
+
+// workingSuffix is the longest suffix of working files (".nehm-tmp"),
+// which are written next to tracks before they're renamed.
+const workingSuffix = ".nehm-tmp"
+
+// LimitFilename returns name with ext. If it's longer than
+// maxFilenameLength bytes without room for workingSuffix, name is
+// truncated and gets the short hash of full name, so different
+// long names remain unique.
+func LimitFilename(name, ext string) string {
+	limit := maxFilenameLength - len(workingSuffix)
+	if len(name)+len(ext) <= limit {
+		return name + ext
+	}
+
+	sum := sha1.Sum([]byte(name))
+	suffix := "~" + hex.EncodeToString(sum[:4])
+
+	max := limit - len(ext) - len(suffix)
+	// Don't cut multibyte runes.
+	for max > 0 && !utf8.RuneStart(name[max]) {
+		max--
+	}
+	return strings.TrimSpace(name[:max]) + suffix + ext
+}
+
+// SizeString returns the human-readable size of bytes, e.g. "4.2 MB".
+func SizeString(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return strconv.FormatInt(bytes, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(bytes)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "B"
+}
+
+// ParseSize parses the size like "200MB", "1.5 GB" or count of bytes.
+// Units are powers of 1024 as in SizeString.
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	num := strings.TrimRight(strings.TrimSuffix(s, "B"), "KMGT ")
+	multiplier := int64(1)
+	if unit := strings.TrimSpace(strings.TrimSuffix(s[len(num):], "B")); unit != "" {
+		i := strings.Index("KMGT", unit)
+		if len(unit) != 1 || i < 0 {
+			return 0, fmt.Errorf("invalid unit of size %q", s)
+		}
+		for ; i >= 0; i-- {
+			multiplier *= 1024
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * float64(multiplier)), nil
+}
+
+// InContainer reports whether nehm is running inside a Docker
+// (or Podman) container.
+func InContainer() bool {
+	for _, path := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
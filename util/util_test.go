@@ -0,0 +1,96 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	cases := []struct {
+		name, unix, windows string
+	}{
+		{"Artist — Title", "Artist — Title", "Artist — Title"},
+		{"", "_", "_"},
+		{".", "_", "_"},
+		{"..", "_", "_"},
+		{" . ", "_", "_"},
+		{"...", "_", "_"},
+		{"../../etc/passwd", ".._.._etc_passwd", ".._.._etc_passwd"},
+		{`..\..\Windows`, ".._.._Windows", ".._.._Windows"},
+		{"a\x00b\nc\x7fd", "abcd", "abcd"},
+		{"\x01\x02", "_", "_"},
+		{"What?: <Yes>", "What?_ <Yes>", "What__ _Yes_"},
+		{"Title. ", "Title. ", "Title"},
+		{"CON", "CON", "_CON"},
+		{"con.mp3", "con.mp3", "_con.mp3"},
+		{"LPT1 .txt", "LPT1 .txt", "_LPT1 .txt"},
+		{"CONSOLE", "CONSOLE", "CONSOLE"},
+	}
+	for _, c := range cases {
+		if got := sanitizeFilename(c.name, false); got != c.unix {
+			t.Errorf("sanitizeFilename(%q) on unix = %q, want %q", c.name, got, c.unix)
+		}
+		if got := sanitizeFilename(c.name, true); got != c.windows {
+			t.Errorf("sanitizeFilename(%q) on windows = %q, want %q", c.name, got, c.windows)
+		}
+	}
+}
+
+func TestSanitizedFilenameStaysInFolder(t *testing.T) {
+	dir := filepath.FromSlash("/music/nehm")
+	for _, name := range []string{"..", ".", " . ", "../x", "/etc/passwd", `..\x`, "..\x00", "\x00"} {
+		for _, windows := range []bool{false, true} {
+			path := filepath.Join(dir, sanitizeFilename(name, windows))
+			if !IsWithin(dir, path) || filepath.Dir(path) != dir {
+				t.Errorf("%q (windows: %v) leads out of folder: %q", name, windows, path)
+			}
+		}
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	dir := filepath.FromSlash("/music/nehm")
+	cases := []struct {
+		path string
+		want bool
+	}{
+		{"/music/nehm/track.mp3", true},
+		{"/music/nehm/a/b/track.mp3", true},
+		{"/music/nehm/..track.mp3", true},
+		{"/music/nehm", true},
+		{"/music/nehm/../x", false},
+		{"/music/nehm/a/../../x", false},
+		{"/music", false},
+		{"/music/nehm-other/track.mp3", false},
+		{"/music/nehmtrack.mp3", false},
+		{"/etc/passwd", false},
+		{"../x", false},
+		{"track.mp3", false},
+	}
+	for _, c := range cases {
+		if got := IsWithin(dir, filepath.FromSlash(c.path)); got != c.want {
+			t.Errorf("IsWithin(%q, %q) = %v, want %v", dir, c.path, got, c.want)
+		}
+	}
+}
+
+func TestLimitFilename(t *testing.T) {
+	var name string
+	for len(name) < 300 {
+		name += "ÄÖÜ"
+	}
+	got := LimitFilename(name, ".mp3")
+	if len(got+workingSuffix) > maxFilenameLength {
+		t.Fatalf("len(LimitFilename) = %v, want <= %v", len(got), maxFilenameLength-len(workingSuffix))
+	}
+	if got == LimitFilename(name+"x", ".mp3") {
+		t.Error("different long names got the same filename")
+	}
+	if filepath.Ext(got) != ".mp3" {
+		t.Errorf("extension of %q is lost", got)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package watch keeps the uploads of watched artists (watchArtists
+// in config) and episodes of feeds (feeds in config), which were
+// already seen, so only new uploads and episodes are downloaded.
+package watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bogem/nehm/config"
+	"github.com/bogem/nehm/feed"
+	"github.com/bogem/nehm/track"
+)
+
+// maxSeen is the count of IDs of uploads kept for each artist.
+// It should be greater than the count of requested uploads.
+const maxSeen = 100
+
+// maxSeenEpisodes is the count of GUIDs of episodes kept for each feed.
+// Feeds aren't limited by nehm, so it's much greater than maxSeen.
+const maxSeenEpisodes = 5000
+
+// State is the list of seen uploads of artists by their permalinks
+// and seen episodes of feeds by their URLs.
+type State struct {
+	Seen     map[string][]int    `json:"seen"`
+	Episodes map[string][]string `json:"episodes,omitempty"`
+}
+
+func statePath() string {
+	return filepath.Join(config.StateDir(), "watch.json")
+}
+
+// Load loads state from disk. If there is no state yet, it's empty.
+func Load() (*State, error) {
+	s := &State{Seen: make(map[string][]int), Episodes: make(map[string][]string)}
+	data, err := ioutil.ReadFile(statePath())
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the state of watched artists: %v", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal the state of watched artists: %v", err)
+	}
+	if s.Seen == nil {
+		s.Seen = make(map[string][]int)
+	}
+	if s.Episodes == nil {
+		s.Episodes = make(map[string][]string)
+	}
+	return s, nil
+}
+
+// Save writes state to disk.
+func (s *State) Save() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.StateDir(), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(statePath(), data, 0644)
+}
+
+// NewUploads marks uploads of artist as seen and returns those,
+// which weren't seen before. When artist is checked the first time,
+// all uploads are only marked, so old uploads are not downloaded.
+func (s *State) NewUploads(artist string, uploads []track.Track) []track.Track {
+	seen, watched := s.Seen[artist]
+	isSeen := make(map[int]bool, len(seen))
+	for _, id := range seen {
+		isSeen[id] = true
+	}
+
+	var fresh []track.Track
+	for _, t := range uploads {
+		if isSeen[t.ID()] {
+			continue
+		}
+		seen = append(seen, t.ID())
+		if watched {
+			fresh = append(fresh, t)
+		}
+	}
+	if len(seen) > maxSeen {
+		seen = seen[len(seen)-maxSeen:]
+	}
+	if seen == nil {
+		seen = []int{}
+	}
+	s.Seen[artist] = seen
+	return fresh
+}
+
+// NewEpisodes marks episodes of feed with url as seen and returns those,
+// which weren't seen before. As with artists, episodes are only marked,
+// when feed is checked the first time.
+func (s *State) NewEpisodes(url string, episodes []feed.Episode) []feed.Episode {
+	seen, watched := s.Episodes[url]
+	isSeen := make(map[string]bool, len(seen))
+	for _, guid := range seen {
+		isSeen[guid] = true
+	}
+
+	var fresh []feed.Episode
+	for _, e := range episodes {
+		if isSeen[e.GUID] {
+			continue
+		}
+		isSeen[e.GUID] = true
+		seen = append(seen, e.GUID)
+		if watched {
+			fresh = append(fresh, e)
+		}
+	}
+	if len(seen) > maxSeenEpisodes {
+		seen = seen[len(seen)-maxSeenEpisodes:]
+	}
+	if seen == nil {
+		seen = []string{}
+	}
+	s.Episodes[url] = seen
+	return fresh
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Albert Nigmatzianov. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package webpage finds SoundCloud tracks embedded in web pages,
+// e.g. in blog posts with premieres.
+package webpage
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// playerRegexp matches URLs of SoundCloud widget in iframes, e.g.
+	// https://w.soundcloud.com/player/?url=https%3A//api.soundcloud.com/tracks/123.
+	playerRegexp = regexp.MustCompile(`(?:https?:)?//w\.soundcloud\.com/player/?\?[^"'\s<>]+`)
+
+	// apiRegexp matches API URLs of tracks and playlists, which are
+	// used by other players and in scripts.
+	apiRegexp = regexp.MustCompile(`https?:(?:\\?/){2}api\.soundcloud\.com(?:\\?/)(?:tracks|playlists)(?:\\?/)\d+`)
+)
+
+// SoundCloudURLs returns the unique URLs of tracks and playlists
+// embedded in page in order of their appearance.
+func SoundCloudURLs(page []byte) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	add := func(u string) {
+		if u != "" && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+
+	type match struct {
+		start int
+		url   string
+	}
+	var matches []match
+	for _, loc := range playerRegexp.FindAllIndex(page, -1) {
+		matches = append(matches, match{loc[0], playerURL(string(page[loc[0]:loc[1]]))})
+	}
+	for _, loc := range apiRegexp.FindAllIndex(page, -1) {
+		// In JSON in scripts slashes can be escaped.
+		u := strings.Replace(string(page[loc[0]:loc[1]]), `\/`, "/", -1)
+		matches = append(matches, match{loc[0], strings.Replace(u, "http://", "https://", 1)})
+	}
+
+	// Keep the order of appearance on page.
+	for len(matches) > 0 {
+		first := 0
+		for i, m := range matches {
+			if m.start < matches[first].start {
+				first = i
+			}
+		}
+		add(matches[first].url)
+		matches = append(matches[:first], matches[first+1:]...)
+	}
+	return urls
+}
+
+// playerURL returns the URL of track or playlist from URL of widget.
+func playerURL(raw string) string {
+	raw = html.UnescapeString(raw)
+	if strings.HasPrefix(raw, "//") {
+		raw = "https:" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("url")
+}